@@ -3,10 +3,18 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/carapace-sh/carapace"
+	"github.com/hokupod/fs-tracer/internal/aggregate"
 	"github.com/hokupod/fs-tracer/internal/app"
 	"github.com/hokupod/fs-tracer/internal/args"
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/sandbox"
+	"github.com/hokupod/fs-tracer/pkg/fstrace"
 	"github.com/spf13/cobra"
 )
 
@@ -29,16 +37,54 @@ func newRootCmd() *cobra.Command {
 		optJSON         bool
 		optSplitAccess  bool
 		optSandbox      bool
+		optLeastPriv    bool
+		optProfile      bool
+		optSandboxBase  string
+		optLandlock     bool
+		optBwrap        bool
+		optSystemd      bool
+		optAppArmor     bool
+		optOCIMounts    bool
+		optDockerVols   bool
+		optEntitlements bool
+		optFormat       string
+		optCollapseN    int
+		optCollapseR    float64
+		optRegexRules   bool
+		optRuleReport   bool
+		optParams       bool
+		optParamDefs    []string
 		optDirs         bool
 		optAllowProc    []string
 		optIgnoreProc   []string
 		optIgnorePrefix []string
+		optOpCategory   []string
 		optNoSudo       bool
 		optRaw          bool
 		optNoPIDFilter  bool
 		optFollowChild  bool
 		optIgnoreCWD    bool
 		optMaxDepth     int
+		optTimeout      time.Duration
+		optMaxEvents    int
+		optStopOnPath   string
+		optStream       bool
+		optMemoryLimit  string
+		memoryLimit     int64
+		optOutput       string
+		optOutputFD     int
+		optCmdStdout    string
+		optCmdStderr    string
+		optWaitAttach   bool
+		optAttachDelay  time.Duration
+		optRepeat       int
+		optMinFreq      float64
+		optEnv          []string
+		optUnsetEnv     []string
+		optCleanEnv     bool
+		optCwd          string
+		optStdin        string
+		optRunAs        string
 		optVersion      bool
 	)
 
@@ -55,6 +101,116 @@ func newRootCmd() *cobra.Command {
 			if optSandbox && optEvents {
 				return fmt.Errorf("--events cannot be used with --sandbox-snippet")
 			}
+			if err := exclusiveOutputs(map[string]bool{
+				"--events":           optEvents,
+				"--sandbox-snippet":  optSandbox,
+				"--sandbox-profile":  optProfile,
+				"--landlock-policy":  optLandlock,
+				"--bwrap-script":     optBwrap,
+				"--systemd-dropin":   optSystemd,
+				"--apparmor-profile": optAppArmor,
+				"--oci-mounts":       optOCIMounts,
+				"--docker-volumes":   optDockerVols,
+				"--entitlements":     optEntitlements,
+				"--profile-format":   optFormat != "",
+			}); err != nil {
+				return err
+			}
+			if optFormat != "" {
+				if _, err := sandbox.ParseFormat(optFormat); err != nil {
+					return err
+				}
+			}
+			sandboxFormat := optFormat == sandbox.FormatSnippet || optFormat == sandbox.FormatProfile
+			if optLeastPriv && !optSandbox && !optProfile && !sandboxFormat {
+				return fmt.Errorf("--least-privilege requires --sandbox-snippet or --sandbox-profile")
+			}
+			if _, err := sandbox.ParseBase(optSandboxBase); err != nil {
+				return err
+			}
+			if optCollapseR < 0 || optCollapseR > 1 {
+				return fmt.Errorf("--collapse-ratio must be between 0 and 1")
+			}
+			for _, def := range optParamDefs {
+				if _, err := sandbox.ParseParam(def); err != nil {
+					return err
+				}
+			}
+			for _, c := range optOpCategory {
+				if _, err := ops.ParseCategory(c); err != nil {
+					return err
+				}
+			}
+			profileOutput := optSandbox || optProfile || optLandlock || optBwrap || optSystemd || optAppArmor || optOCIMounts || optDockerVols || optEntitlements || optFormat != ""
+			if optStream && profileOutput {
+				return fmt.Errorf("--stream only applies to --events, --split-access and the default path list")
+			}
+			if optMemoryLimit != "" {
+				if !optEvents {
+					return fmt.Errorf("--memory-limit only applies to --events")
+				}
+				n, err := aggregate.ParseSize(optMemoryLimit)
+				if err != nil {
+					return fmt.Errorf("--memory-limit: %w", err)
+				}
+				memoryLimit = n
+			}
+			if optRepeat < 1 {
+				return fmt.Errorf("--repeat must be at least 1")
+			}
+			if optMinFreq < 0 || optMinFreq > 1 {
+				return fmt.Errorf("--min-frequency must be between 0 and 1")
+			}
+			if optMinFreq > 0 {
+				if optRepeat < 2 {
+					return fmt.Errorf("--min-frequency requires --repeat 2 or more")
+				}
+				if !profileOutput {
+					return fmt.Errorf("--min-frequency only applies to profile outputs")
+				}
+			}
+			for _, kv := range optEnv {
+				if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+					return fmt.Errorf("invalid --env %q (want KEY=VAL)", kv)
+				}
+			}
+			for _, key := range optUnsetEnv {
+				if key == "" || strings.Contains(key, "=") {
+					return fmt.Errorf("invalid --unset-env %q (want a variable name)", key)
+				}
+			}
+			if optCwd != "" {
+				if fi, err := os.Stat(optCwd); err != nil {
+					return fmt.Errorf("--cwd: %w", err)
+				} else if !fi.IsDir() {
+					return fmt.Errorf("--cwd %s is not a directory", optCwd)
+				}
+			}
+			if optStdin != "" {
+				if fi, err := os.Stat(optStdin); err != nil {
+					return fmt.Errorf("--stdin: %w", err)
+				} else if fi.IsDir() {
+					return fmt.Errorf("--stdin %s is a directory", optStdin)
+				}
+			}
+			if optOutput != "" && optOutputFD != 0 {
+				return fmt.Errorf("--output cannot be combined with --output-fd")
+			}
+			if optOutputFD < 0 {
+				return fmt.Errorf("--output-fd must not be negative")
+			}
+			if optAttachDelay < 0 {
+				return fmt.Errorf("--attach-delay must not be negative")
+			}
+			if optTimeout < 0 {
+				return fmt.Errorf("--timeout must not be negative")
+			}
+			if optMaxEvents < 0 {
+				return fmt.Errorf("--max-events must not be negative")
+			}
+			if _, err := filepath.Match(optStopOnPath, ""); err != nil {
+				return fmt.Errorf("invalid --stop-on-path %q: %w", optStopOnPath, err)
+			}
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, positional []string) error {
@@ -67,19 +223,56 @@ func newRootCmd() *cobra.Command {
 				JSON:            optJSON,
 				SplitAccess:     optSplitAccess,
 				SandboxSnippet:  optSandbox,
+				LeastPrivilege:  optLeastPriv,
+				SandboxProfile:  optProfile,
+				SandboxBase:     optSandboxBase,
+				LandlockPolicy:  optLandlock,
+				BwrapScript:     optBwrap,
+				SystemdDropIn:   optSystemd,
+				AppArmorProfile: optAppArmor,
+				OCIMounts:       optOCIMounts,
+				DockerVolumes:   optDockerVols,
+				Entitlements:    optEntitlements,
+				ProfileFormat:   optFormat,
+				CollapseCount:   optCollapseN,
+				CollapseRatio:   optCollapseR,
+				RegexRules:      optRegexRules,
+				RuleReport:      optRuleReport,
+				SandboxParams:   optParams,
+				ParamDefs:       optParamDefs,
 				DirsOnly:        optDirs,
 				AllowProcesses:  optAllowProc,
 				IgnoreProcesses: optIgnoreProc,
 				IgnorePrefixes:  optIgnorePrefix,
+				OpCategories:    optOpCategory,
 				NoSudo:          optNoSudo,
 				Raw:             optRaw,
 				NoPIDFilter:     optNoPIDFilter,
 				FollowChildren:  optFollowChild,
 				IgnoreCWD:       optIgnoreCWD,
 				MaxDepth:        optMaxDepth,
+				Timeout:         optTimeout,
+				MaxEvents:       optMaxEvents,
+				StopOnPath:      optStopOnPath,
+				Stream:          optStream,
+				MemoryLimit:     memoryLimit,
+				Output:          optOutput,
+				OutputFD:        optOutputFD,
+				CmdStdout:       optCmdStdout,
+				CmdStderr:       optCmdStderr,
+				WaitAttach:      optWaitAttach,
+				AttachDelay:     optAttachDelay,
+				Repeat:          optRepeat,
+				MinFrequency:    optMinFreq,
+				Env:             optEnv,
+				UnsetEnv:        optUnsetEnv,
+				CleanEnv:        optCleanEnv,
+				Cwd:             optCwd,
+				Stdin:           optStdin,
+				RunAs:           optRunAs,
 				Command:         append([]string(nil), positional...),
 			}
-			code := app.Run(app.Config{Options: opts})
+			code := app.Run(app.Config{Options: opts, Version: version})
 			os.Exit(code)
 			return nil
 		},
@@ -89,19 +282,56 @@ func newRootCmd() *cobra.Command {
 
 	flags := rootCmd.Flags()
 	flags.BoolVarP(&optEvents, "events", "v", false, "emit detailed event log")
-	flags.BoolVar(&optJSON, "json", false, "output JSON")
+	flags.BoolVar(&optJSON, "json", false, "output JSON in a versioned envelope; with --events or --stream, one object per line and the envelope (with schema_version) on the last line")
 	flags.BoolVar(&optSplitAccess, "split-access", false, "separate read/write sets")
 	flags.BoolVar(&optSandbox, "sandbox-snippet", false, "emit sandbox-exec s-expressions (exclusive with --events)")
+	flags.BoolVar(&optProfile, "sandbox-profile", false, "emit a complete, runnable sandbox-exec profile (exclusive with --events)")
+	flags.StringVar(&optSandboxBase, "sandbox-base", string(sandbox.BaseDenyDefault), "profile base template: deny-default or allow-default")
+	flags.BoolVar(&optLandlock, "landlock-policy", false, "emit a Linux Landlock policy (JSON) for fs-tracer enforce")
+	flags.BoolVar(&optBwrap, "bwrap-script", false, "emit a shell script running yourcmd under bubblewrap with the observed paths bound")
+	flags.BoolVar(&optSystemd, "systemd-dropin", false, "emit a systemd drop-in (ProtectSystem=strict, ReadWritePaths=, ...) for the traced service")
+	flags.BoolVar(&optAppArmor, "apparmor-profile", false, "emit an AppArmor profile for yourcmd (use with --follow-children to cover child execs)")
+	flags.BoolVar(&optOCIMounts, "oci-mounts", false, "emit an OCI runtime config.json \"mounts\" fragment binding the observed paths")
+	flags.BoolVar(&optDockerVols, "docker-volumes", false, "emit docker run -v arguments binding the observed paths")
+	flags.BoolVar(&optEntitlements, "entitlements", false, "emit an App Sandbox entitlements plist with temporary file-access exceptions")
+	flags.StringVar(&optFormat, "profile-format", "", "emit a sandbox profile in FORMAT ("+strings.Join(sandbox.Formats(), ", ")+"); the flags above are shorthands")
+	flags.BoolVar(&optLeastPriv, "least-privilege", false, "with --sandbox-snippet/--sandbox-profile, grant only the exercised operations (file-read-data, file-write-create, ...)")
+	flags.IntVar(&optCollapseN, "collapse-threshold", 0, "sandbox output: fold a directory into (subpath ...) once N entries in it were seen (0 = off)")
+	flags.Float64Var(&optCollapseR, "collapse-ratio", 0, "sandbox output: fold a directory into (subpath ...) once this fraction of its entries was seen (0 = off)")
+	flags.BoolVar(&optRegexRules, "regex-rules", false, "sandbox output: replace siblings with versioned/hashed/random names by (regex ...)")
+	flags.BoolVar(&optRuleReport, "rule-report", false, "sandbox output: print which literals each subpath/regex rule absorbed to stderr")
+	flags.BoolVar(&optParams, "sandbox-params", false, "sandbox output: replace yourcmd's HOME, TMPDIR and working directory with (param \"HOME\"), (param \"TMPDIR\"), (param \"PROJECT_DIR\")")
+	flags.StringArrayVar(&optParamDefs, "sandbox-param", nil, "sandbox output: extra NAME=PATH substitution (repeatable; implies --sandbox-params)")
 	flags.BoolVar(&optDirs, "dirs", false, "emit parent directories only")
 	flags.StringSliceVar(&optAllowProc, "allow-process", nil, "only include events from process name (repeatable)")
 	flags.StringSliceVar(&optIgnoreProc, "ignore-process", nil, "process name to ignore (repeatable)")
 	flags.StringSliceVar(&optIgnorePrefix, "ignore-prefix", nil, "path prefix to ignore (repeatable)")
+	flags.StringSliceVar(&optOpCategory, "op-category", nil, "only include events whose op falls in category (repeatable)")
 	flags.BoolVar(&optNoSudo, "no-sudo", false, "run fs_usage without sudo")
 	flags.BoolVar(&optRaw, "raw", false, "disable ignore filters")
 	flags.BoolVar(&optNoPIDFilter, "no-pid-filter", false, "do not restrict events to target PID")
 	flags.BoolVar(&optFollowChild, "follow-children", false, "include child processes (runs fs_usage without PID filter and filters descendants in-process)")
-	flags.BoolVar(&optIgnoreCWD, "ignore-cwd", false, "ignore events under current working directory")
+	flags.BoolVar(&optIgnoreCWD, "ignore-cwd", false, "ignore events under yourcmd's working directory (--cwd or the current one)")
 	flags.IntVar(&optMaxDepth, "max-depth", 0, "truncate paths to at most N components (0 = unlimited)")
+	flags.DurationVar(&optTimeout, "timeout", 0, "stop tracing after DURATION: SIGTERM yourcmd, SIGKILL it 5s later (0 = no limit)")
+	flags.IntVar(&optMaxEvents, "max-events", 0, "stop tracing once N events were captured (0 = no limit)")
+	flags.StringVar(&optStopOnPath, "stop-on-path", "", "stop tracing once a path matching GLOB is accessed (without \"/\", GLOB matches the base name)")
+	flags.BoolVar(&optStream, "stream", false, "write events (or newly seen paths) as they arrive instead of after yourcmd exits")
+	flags.StringVar(&optMemoryLimit, "memory-limit", "", "with --events, spill stored events to a temporary file beyond SIZE (e.g. 256M; default: keep in memory)")
+	flags.StringVarP(&optOutput, "output", "o", "", "write results to FILE instead of stdout")
+	flags.IntVar(&optOutputFD, "output-fd", 0, "write results to file descriptor N (e.g. 3 with 3>results.json)")
+	flags.StringVar(&optCmdStdout, "cmd-stdout", "", "redirect yourcmd's stdout to FILE (e.g. /dev/stderr or /dev/null)")
+	flags.StringVar(&optCmdStderr, "cmd-stderr", "", "redirect yourcmd's stderr to FILE")
+	flags.BoolVar(&optWaitAttach, "wait-attach", false, "hold yourcmd until fs_usage reports its first event (at most --attach-delay, default 5s)")
+	flags.DurationVar(&optAttachDelay, "attach-delay", 0, "hold yourcmd for DURATION before letting it run, so fs_usage can attach")
+	flags.IntVar(&optRepeat, "repeat", 1, "run yourcmd N times, merge the events and report in how many runs each path was seen")
+	flags.Float64Var(&optMinFreq, "min-frequency", 0, "with --repeat, keep only paths seen in at least this fraction of runs in profile outputs (0 = all)")
+	flags.StringArrayVar(&optEnv, "env", nil, "set KEY=VAL in yourcmd's environment (repeatable)")
+	flags.StringArrayVar(&optUnsetEnv, "unset-env", nil, "remove KEY from yourcmd's environment (repeatable)")
+	flags.BoolVar(&optCleanEnv, "clean-env", false, "start yourcmd with an empty environment plus --env")
+	flags.StringVar(&optCwd, "cwd", "", "run yourcmd in DIR")
+	flags.StringVar(&optStdin, "stdin", "", "feed FILE to yourcmd's stdin (reopened for every --repeat run)")
+	flags.StringVar(&optRunAs, "run-as", "", "run yourcmd as USER (name or uid; fs-tracer must run as root)")
 	flags.BoolVar(&optVersion, "version", false, "print version and exit")
 
 	carapace.Gen(rootCmd).Standalone()
@@ -109,6 +339,14 @@ func newRootCmd() *cobra.Command {
 		"allow-process":  carapace.ActionValues(), // no-op completion placeholder
 		"ignore-process": carapace.ActionValues(),
 		"ignore-prefix":  carapace.ActionDirectories(),
+		"op-category":    carapace.ActionValues(opCategoryNames()...),
+		"sandbox-base":   carapace.ActionValues(string(sandbox.BaseDenyDefault), string(sandbox.BaseAllowDefault)),
+		"output":         carapace.ActionFiles(),
+		"cmd-stdout":     carapace.ActionFiles(),
+		"cmd-stderr":     carapace.ActionFiles(),
+		"cwd":            carapace.ActionDirectories(),
+		"stdin":          carapace.ActionFiles(),
+		"profile-format": carapace.ActionValues(sandbox.Formats()...),
 	})
 	// Positional: suggest executables, then files/dirs.
 	carapace.Gen(rootCmd).PositionalCompletion(
@@ -119,9 +357,130 @@ func newRootCmd() *cobra.Command {
 	)
 
 	rootCmd.AddCommand(newCompletionCmd(rootCmd))
+	rootCmd.AddCommand(newSandboxCmd())
+	rootCmd.AddCommand(newEnforceCmd())
+	rootCmd.AddCommand(newGateCmd())
 	return rootCmd
 }
 
+// exclusiveOutputs rejects combining output modes that each replace the
+// default path list.
+func exclusiveOutputs(modes map[string]bool) error {
+	var set []string
+	for flag, on := range modes {
+		if on {
+			set = append(set, flag)
+		}
+	}
+	if len(set) < 2 {
+		return nil
+	}
+	sort.Strings(set)
+	return fmt.Errorf("%s cannot be combined", strings.Join(set, ", "))
+}
+
+// newGateCmd is the helper behind --wait-attach and --attach-delay; it is
+// not meant to be run by hand.
+func newGateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:                fstrace.GateCommand + " PROBE PATH ARGV...",
+		Hidden:             true,
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, positional []string) error {
+			os.Exit(fstrace.RunGate(positional, os.Stderr))
+			return nil
+		},
+	}
+}
+
+func newEnforceCmd() *cobra.Command {
+	var optPolicy string
+	enforceCmd := &cobra.Command{
+		Use:   "enforce --policy FILE -- yourcmd [ARG ...]",
+		Short: "Run a command confined by a Landlock policy (Linux)",
+		Long: "Applies a policy written by --landlock-policy with landlock_create_ruleset,\n" +
+			"landlock_add_rule and landlock_restrict_self, then execs yourcmd under it.",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return fmt.Errorf("yourcmd is required after --")
+			}
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, positional []string) error {
+			code := app.RunEnforce(app.EnforceConfig{
+				PolicyPath: optPolicy,
+				Command:    append([]string(nil), positional...),
+			})
+			os.Exit(code)
+			return nil
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	enforceCmd.Flags().StringVar(&optPolicy, "policy", "", "Landlock policy file written by --landlock-policy")
+	_ = enforceCmd.MarkFlagRequired("policy")
+	carapace.Gen(enforceCmd).FlagCompletion(carapace.ActionMap{
+		"policy": carapace.ActionFiles(".json"),
+	})
+	return enforceCmd
+}
+
+func newSandboxCmd() *cobra.Command {
+	sandboxCmd := &cobra.Command{
+		Use:   "sandbox",
+		Short: "Work with sandbox-exec profiles",
+	}
+	sandboxCmd.AddCommand(newSandboxAuditCmd())
+	return sandboxCmd
+}
+
+func newSandboxAuditCmd() *cobra.Command {
+	var (
+		optProfile string
+		optTrace   string
+		optParams  []string
+		optJSON    bool
+	)
+	auditCmd := &cobra.Command{
+		Use:   "audit --profile FILE [--trace FILE]",
+		Short: "Report traced accesses an existing profile would deny, and rules no access used",
+		Long: "Evaluates a recorded trace (fs-tracer --events --json output, or raw fs_usage lines)\n" +
+			"against a sandbox-exec profile. Exits 1 when any access would be denied.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			code := app.RunAudit(app.AuditConfig{
+				ProfilePath: optProfile,
+				TracePath:   optTrace,
+				Params:      optParams,
+				JSON:        optJSON,
+			})
+			os.Exit(code)
+			return nil
+		},
+	}
+	flags := auditCmd.Flags()
+	flags.StringVar(&optProfile, "profile", "", "sandbox-exec profile (.sb) to audit")
+	flags.StringVar(&optTrace, "trace", "-", "recorded trace file (- for stdin)")
+	flags.StringArrayVarP(&optParams, "param", "D", nil, "profile parameter NAME=VALUE, as for sandbox-exec -D (repeatable)")
+	flags.BoolVar(&optJSON, "json", false, "output JSON")
+	_ = auditCmd.MarkFlagRequired("profile")
+
+	carapace.Gen(auditCmd).FlagCompletion(carapace.ActionMap{
+		"profile": carapace.ActionFiles(".sb"),
+		"trace":   carapace.ActionFiles(),
+	})
+	return auditCmd
+}
+
+func opCategoryNames() []string {
+	cats := ops.Categories()
+	out := make([]string, 0, len(cats))
+	for _, c := range cats {
+		out = append(out, string(c))
+	}
+	return out
+}
+
 func printVersion(cmd *cobra.Command) {
 	fmt.Fprintf(cmd.OutOrStdout(), "fs-tracer %s (commit %s, built %s)\n", version, commit, date)
 }
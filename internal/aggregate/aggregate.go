@@ -0,0 +1,290 @@
+// Package aggregate accumulates filtered events into the state the output
+// modes need, so long traces do not keep every event in memory.
+package aggregate
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// eventOverhead approximates the memory an event takes beyond its strings.
+const eventOverhead = 96
+
+// Options selects what an Aggregator keeps.
+type Options struct {
+	// DirsOnly records parent directories instead of paths.
+	DirsOnly bool
+	// KeepEvents stores every event, for --events.
+	KeepEvents bool
+	// MemoryLimit is the approximate size in bytes of stored events above
+	// which they are spilled to a temporary file; 0 keeps them in memory.
+	MemoryLimit int64
+	// TempDir holds the spill file; empty uses os.TempDir.
+	TempDir string
+}
+
+// Aggregator keeps per-path op categories, the distinct op names and,
+// when asked, the events themselves. It is not safe for concurrent use.
+type Aggregator struct {
+	opts     Options
+	accesses map[string]map[ops.Category]struct{}
+	ops      map[string]struct{}
+	// fresh marks the paths that so far were only opened. fs_usage does
+	// not report open's O_CREAT flag, so a write to such a path is taken as
+	// the run creating it.
+	fresh map[string]bool
+
+	// run is the current run, from 1; seen tracks per path how many runs
+	// accessed it.
+	run  int
+	seen map[string]*pathRuns
+
+	events   []fsusage.Event
+	resident int64
+	spill    *spillFile
+}
+
+// New returns an empty Aggregator.
+func New(opts Options) *Aggregator {
+	return &Aggregator{
+		opts:     opts,
+		accesses: map[string]map[ops.Category]struct{}{},
+		ops:      map[string]struct{}{},
+		fresh:    map[string]bool{},
+		run:      1,
+		seen:     map[string]*pathRuns{},
+	}
+}
+
+// pathRuns counts the runs that accessed a path.
+type pathRuns struct {
+	count int
+	last  int
+}
+
+// NextRun starts another run of yourcmd, for --repeat. Events added from now
+// on count towards it.
+func (a *Aggregator) NextRun() {
+	a.run++
+}
+
+// Runs returns the number of runs, 1 unless NextRun was called.
+func (a *Aggregator) Runs() int {
+	return a.run
+}
+
+// Seen returns how many runs accessed path p, as returned by Paths.
+func (a *Aggregator) Seen(p string) int {
+	if r, ok := a.seen[p]; ok {
+		return r.count
+	}
+	return 0
+}
+
+// Prune forgets the paths accessed in fewer than minRuns runs, for
+// --min-frequency, and returns how many were dropped. Stored events are kept.
+func (a *Aggregator) Prune(minRuns int) int {
+	dropped := 0
+	for p := range a.accesses {
+		if a.Seen(p) < minRuns {
+			delete(a.accesses, p)
+			delete(a.seen, p)
+			delete(a.fresh, p)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// Add records a filtered event. It fails only when spilling fails.
+func (a *Aggregator) Add(ev fsusage.Event) error {
+	name := ops.Normalize(ev.Op)
+	a.ops[name] = struct{}{}
+	p := processor.NormalizePath(ev.Path, a.opts.DirsOnly)
+	opens := strings.HasPrefix(name, "open")
+	cats, ok := a.accesses[p]
+	if !ok {
+		cats = map[ops.Category]struct{}{}
+		a.accesses[p] = cats
+		a.fresh[p] = opens
+	}
+	c := ops.Classify(ev.Op)
+	cats[c] = struct{}{}
+	if a.fresh[p] {
+		if c.IsWrite() {
+			cats[ops.Create] = struct{}{}
+		}
+		a.fresh[p] = opens
+	}
+	r, ok := a.seen[p]
+	if !ok {
+		r = &pathRuns{}
+		a.seen[p] = r
+	}
+	if r.last != a.run {
+		r.count++
+		r.last = a.run
+	}
+
+	if !a.opts.KeepEvents {
+		return nil
+	}
+	a.events = append(a.events, ev)
+	a.resident += eventSize(ev)
+	if a.opts.MemoryLimit > 0 && a.resident > a.opts.MemoryLimit {
+		return a.flush()
+	}
+	return nil
+}
+
+// flush moves the resident events to the spill file.
+func (a *Aggregator) flush() error {
+	if a.spill == nil {
+		s, err := newSpillFile(a.opts.TempDir)
+		if err != nil {
+			return err
+		}
+		a.spill = s
+	}
+	for _, ev := range a.events {
+		if err := a.spill.write(ev); err != nil {
+			return err
+		}
+	}
+	a.events = a.events[:0]
+	a.resident = 0
+	return nil
+}
+
+// Spilled returns the number of events written to the spill file.
+func (a *Aggregator) Spilled() int {
+	if a.spill == nil {
+		return 0
+	}
+	return a.spill.count
+}
+
+// Events calls fn for each stored event in arrival order, reading spilled
+// events back first. It stops at the first error.
+func (a *Aggregator) Events(fn func(fsusage.Event) error) error {
+	if a.spill != nil {
+		if err := a.spill.replay(fn); err != nil {
+			return err
+		}
+	}
+	for _, ev := range a.events {
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close removes the spill file, if any.
+func (a *Aggregator) Close() error {
+	if a.spill == nil {
+		return nil
+	}
+	err := a.spill.close()
+	a.spill = nil
+	return err
+}
+
+// Paths returns the unique paths, or with DirsOnly their parent directories,
+// sorted.
+func (a *Aggregator) Paths() []string {
+	out := make([]string, 0, len(a.accesses))
+	for p := range a.accesses {
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ReadWrite splits the paths into read and write sets: a path is read when
+// any non-write op other than a descriptor op touched it and written when any
+// write op did. Ops missing from the catalogue count as reads.
+func (a *Aggregator) ReadWrite() (reads, writes []string) {
+	reads, writes = []string{}, []string{}
+	for _, p := range a.Paths() {
+		read, write := false, false
+		for c := range a.accesses[p] {
+			switch {
+			case c.IsWrite():
+				write = true
+			case c.ChecksPath():
+				read = true
+			}
+		}
+		if read {
+			reads = append(reads, p)
+		}
+		if write {
+			writes = append(writes, p)
+		}
+	}
+	return reads, writes
+}
+
+// Accesses returns the op categories seen on each path, in catalogue order,
+// sorted by path. A path that was opened and then written with nothing in
+// between also has Create, as the run most likely created it with
+// open(O_CREAT).
+func (a *Aggregator) Accesses() []processor.Access {
+	order := append(ops.Categories(), ops.Unknown)
+	out := make([]processor.Access, 0, len(a.accesses))
+	for _, p := range a.Paths() {
+		acc := processor.Access{Path: p}
+		for _, c := range order {
+			if _, ok := a.accesses[p][c]; ok {
+				acc.Categories = append(acc.Categories, c)
+			}
+		}
+		out = append(out, acc)
+	}
+	return out
+}
+
+// OpNames returns the distinct normalized op names seen, sorted.
+func (a *Aggregator) OpNames() []string {
+	out := make([]string, 0, len(a.ops))
+	for op := range a.ops {
+		out = append(out, op)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func eventSize(ev fsusage.Event) int64 {
+	return int64(eventOverhead + len(ev.RawTimestamp) + len(ev.Comm) + len(ev.Op) + len(ev.Path))
+}
+
+// ParseSize parses a byte count with an optional K, M or G suffix (powers
+// of 1024), as accepted by --memory-limit.
+func ParseSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "B")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(t, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(t, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(t, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		t = t[:len(t)-1]
+	}
+	n, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 512M or 2G)", s)
+	}
+	return n * mult, nil
+}
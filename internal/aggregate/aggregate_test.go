@@ -0,0 +1,195 @@
+package aggregate
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func sampleEvents() []fsusage.Event {
+	ts := time.Date(2025, time.November, 29, 10, 0, 0, 0, time.UTC)
+	return []fsusage.Event{
+		{Timestamp: ts, PID: 1, Comm: "mytool", Op: "open", Path: "/etc/hosts"},
+		{Timestamp: ts, PID: 1, Comm: "mytool", Op: "write", Path: "/tmp/out.log"},
+		{Timestamp: ts, PID: 1, Comm: "mytool", Op: "stat64", Path: "/tmp/out.log"},
+		{Timestamp: ts, PID: 1, Comm: "mytool", Op: "frobnicate", Path: "/etc/hosts"},
+	}
+}
+
+func addAll(t *testing.T, agg *Aggregator, evs []fsusage.Event) {
+	t.Helper()
+	for _, ev := range evs {
+		if err := agg.Add(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	agg := New(Options{})
+	addAll(t, agg, sampleEvents())
+	if got := agg.Paths(); !reflect.DeepEqual(got, []string{"/etc/hosts", "/tmp/out.log"}) {
+		t.Fatalf("Paths = %v", got)
+	}
+	dirs := New(Options{DirsOnly: true})
+	addAll(t, dirs, sampleEvents())
+	if got := dirs.Paths(); !reflect.DeepEqual(got, []string{"/etc", "/tmp"}) {
+		t.Fatalf("Paths with DirsOnly = %v", got)
+	}
+	if got := agg.OpNames(); !reflect.DeepEqual(got, []string{"frobnicate", "open", "stat", "write"}) {
+		t.Fatalf("OpNames = %v", got)
+	}
+}
+
+func TestReadWriteUsesCatalogue(t *testing.T) {
+	agg := New(Options{})
+	addAll(t, agg, []fsusage.Event{
+		{Op: "getattrlist", Path: "/a"},
+		{Op: "setxattr", Path: "/b"},
+		{Op: "mmap", Path: "/c"},
+		{Op: "exchangedata", Path: "/d"},
+		{Op: "WrData[A]", Path: "/e"},
+		{Op: "close", Path: "/f"},
+		{Op: "lseek", Path: "/e"},
+		{Op: "frob", Path: "/g"},
+	})
+	reads, writes := agg.ReadWrite()
+	if !reflect.DeepEqual(reads, []string{"/a", "/c", "/g"}) {
+		t.Fatalf("reads = %v", reads)
+	}
+	if !reflect.DeepEqual(writes, []string{"/b", "/d", "/e"}) {
+		t.Fatalf("writes = %v", writes)
+	}
+}
+
+func TestAccesses(t *testing.T) {
+	agg := New(Options{})
+	addAll(t, agg, []fsusage.Event{
+		{Op: "stat64", Path: "/a"},
+		{Op: "open", Path: "/a"},
+		{Op: "mkdir", Path: "/b"},
+		{Op: "frob", Path: "/c"},
+	})
+	want := []processor.Access{
+		{Path: "/a", Categories: []ops.Category{ops.DataRead, ops.MetadataRead}},
+		{Path: "/b", Categories: []ops.Category{ops.Create}},
+		{Path: "/c", Categories: []ops.Category{ops.Unknown}},
+	}
+	if got := agg.Accesses(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Accesses mismatch:\n%+v\nwant:\n%+v", got, want)
+	}
+}
+
+func TestAccessesInferCreate(t *testing.T) {
+	agg := New(Options{})
+	addAll(t, agg, []fsusage.Event{
+		{Op: "open", Path: "/new"},
+		{Op: "WrData[A]", Path: "/new"},
+		{Op: "stat64", Path: "/old"},
+		{Op: "open", Path: "/old"},
+		{Op: "write", Path: "/old"},
+		{Op: "open", Path: "/read"},
+		{Op: "read", Path: "/read"},
+		{Op: "write", Path: "/read"},
+		{Op: "write", Path: "/stdout"},
+	})
+	got := map[string]bool{}
+	for _, acc := range agg.Accesses() {
+		got[acc.Path] = acc.Has(ops.Create)
+	}
+	if want := map[string]bool{"/new": true, "/old": false, "/read": false, "/stdout": false}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("created = %v, want %v", got, want)
+	}
+}
+
+func TestEventsOnlyKeptWhenAsked(t *testing.T) {
+	agg := New(Options{})
+	for _, ev := range sampleEvents() {
+		_ = agg.Add(ev)
+	}
+	n := 0
+	_ = agg.Events(func(fsusage.Event) error { n++; return nil })
+	if n != 0 {
+		t.Fatalf("stored %d events", n)
+	}
+}
+
+func TestRunFrequency(t *testing.T) {
+	agg := New(Options{})
+	evs := sampleEvents()
+	for _, ev := range evs {
+		_ = agg.Add(ev)
+	}
+	agg.NextRun()
+	_ = agg.Add(evs[0])
+	_ = agg.Add(evs[0])
+	if agg.Runs() != 2 || agg.Seen("/etc/hosts") != 2 || agg.Seen("/tmp/out.log") != 1 {
+		t.Fatalf("runs %d, seen %d/%d", agg.Runs(), agg.Seen("/etc/hosts"), agg.Seen("/tmp/out.log"))
+	}
+	if dropped := agg.Prune(2); dropped != 1 {
+		t.Fatalf("Prune dropped %d paths", dropped)
+	}
+	if got := agg.Paths(); !reflect.DeepEqual(got, []string{"/etc/hosts"}) {
+		t.Fatalf("Paths after Prune = %v", got)
+	}
+}
+
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	agg := New(Options{KeepEvents: true, MemoryLimit: 1, TempDir: dir})
+	evs := sampleEvents()
+	for _, ev := range evs[:3] {
+		if err := agg.Add(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if agg.Spilled() != 3 {
+		t.Fatalf("Spilled = %d, want 3", agg.Spilled())
+	}
+	// Replaying must not stop later spills from working.
+	var got []fsusage.Event
+	collect := func(ev fsusage.Event) error { got = append(got, ev); return nil }
+	if err := agg.Events(collect); err != nil {
+		t.Fatal(err)
+	}
+	if err := agg.Add(evs[3]); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := agg.Events(collect); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(evs) {
+		t.Fatalf("replayed %d events, want %d", len(got), len(evs))
+	}
+	for i := range evs {
+		if !got[i].Timestamp.Equal(evs[i].Timestamp) || got[i].Path != evs[i].Path || got[i].Op != evs[i].Op {
+			t.Fatalf("event %d = %+v, want %+v", i, got[i], evs[i])
+		}
+	}
+	if err := agg.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("spill file left behind: %v", entries)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"0": 0, "4096": 4096, "64K": 64 << 10, "512M": 512 << 20, "2g": 2 << 30, "1MB": 1 << 20}
+	for in, want := range cases {
+		if got, err := ParseSize(in); err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "M", "-1", "12X"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) succeeded", in)
+		}
+	}
+}
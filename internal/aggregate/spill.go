@@ -0,0 +1,71 @@
+package aggregate
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+)
+
+// spillFile is an append-only gob stream of events in a temporary file.
+type spillFile struct {
+	f     *os.File
+	buf   *bufio.Writer
+	enc   *gob.Encoder
+	count int
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	f, err := os.CreateTemp(dir, "fs-tracer-events-*.gob")
+	if err != nil {
+		return nil, fmt.Errorf("create spill file: %w", err)
+	}
+	buf := bufio.NewWriter(f)
+	return &spillFile{f: f, buf: buf, enc: gob.NewEncoder(buf)}, nil
+}
+
+func (s *spillFile) write(ev fsusage.Event) error {
+	if err := s.enc.Encode(ev); err != nil {
+		return fmt.Errorf("spill event: %w", err)
+	}
+	s.count++
+	return nil
+}
+
+// replay decodes every spilled event in order. Writing may continue
+// afterwards.
+func (s *spillFile) replay(fn func(fsusage.Event) error) error {
+	if err := s.buf.Flush(); err != nil {
+		return fmt.Errorf("spill event: %w", err)
+	}
+	r, err := os.Open(s.f.Name())
+	if err != nil {
+		return fmt.Errorf("read spill file: %w", err)
+	}
+	defer r.Close()
+	dec := gob.NewDecoder(bufio.NewReader(r))
+	for {
+		var ev fsusage.Event
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("read spill file: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *spillFile) close() error {
+	err := s.f.Close()
+	if rmErr := os.Remove(s.f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
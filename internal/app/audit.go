@@ -0,0 +1,132 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/sandbox"
+)
+
+// exitAuditDenied signals that the profile would deny at least one traced access.
+const exitAuditDenied = 1
+
+// AuditConfig controls RunAudit; zero values pick sensible defaults.
+type AuditConfig struct {
+	ProfilePath string
+	// TracePath is a recorded trace (--events --json output or raw fs_usage
+	// lines); empty or "-" reads Stdin.
+	TracePath string
+	// Params holds NAME=VALUE definitions, as passed to sandbox-exec -D.
+	Params []string
+	JSON   bool
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// RunAudit evaluates a recorded trace against an SBPL profile and reports
+// denied accesses and unused allow rules. It returns 0 when nothing is denied,
+// 1 when something is, and 90–99 on internal errors.
+func RunAudit(cfg AuditConfig) int {
+	stdin := cfg.Stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	stdout := cfg.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	stderr := cfg.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	params := map[string]string{}
+	for _, def := range cfg.Params {
+		name, value, ok := strings.Cut(def, "=")
+		if !ok || name == "" {
+			fmt.Fprintf(stderr, "invalid param %q (want NAME=VALUE)\n", def)
+			return exitInvalidArgs
+		}
+		params[name] = value
+	}
+
+	src, err := os.ReadFile(cfg.ProfilePath)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to read profile:", err)
+		return exitInvalidArgs
+	}
+	policy, err := sandbox.LoadPolicy(string(src), params)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to parse profile:", err)
+		return exitInvalidArgs
+	}
+
+	trace := stdin
+	if cfg.TracePath != "" && cfg.TracePath != "-" {
+		f, err := os.Open(cfg.TracePath)
+		if err != nil {
+			fmt.Fprintln(stderr, "failed to open trace:", err)
+			return exitInvalidArgs
+		}
+		defer f.Close()
+		trace = f
+	}
+	events, err := output.DecodeEvents(trace, time.Now())
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to read trace:", err)
+		return exitScanErr
+	}
+
+	report := sandbox.Audit(policy, events)
+	if cfg.JSON {
+		b, err := json.Marshal(auditJSON(report))
+		if err != nil {
+			fmt.Fprintln(stderr, "output error:", err)
+			return exitScanErr
+		}
+		fmt.Fprintln(stdout, string(b))
+	} else {
+		fmt.Fprintln(stdout, sandbox.FormatAudit(report))
+	}
+	if len(report.Denied) > 0 {
+		return exitAuditDenied
+	}
+	return 0
+}
+
+type auditDenialJSON struct {
+	Path      string `json:"path"`
+	Operation string `json:"operation"`
+	Op        string `json:"op"`
+	Line      int    `json:"line,omitempty"`
+	Rule      string `json:"rule,omitempty"`
+}
+
+type auditRuleJSON struct {
+	Line int    `json:"line"`
+	Rule string `json:"rule"`
+}
+
+func auditJSON(r sandbox.AuditReport) map[string]interface{} {
+	denied := make([]auditDenialJSON, 0, len(r.Denied))
+	for _, d := range r.Denied {
+		entry := auditDenialJSON{Path: d.Path, Operation: d.Operation, Op: d.Op}
+		if d.Rule != nil {
+			entry.Line = d.Rule.Line
+			entry.Rule = d.Rule.Text
+		}
+		denied = append(denied, entry)
+	}
+	unused := make([]auditRuleJSON, 0, len(r.Unused))
+	for _, u := range r.Unused {
+		unused = append(unused, auditRuleJSON{Line: u.Line, Rule: u.Text})
+	}
+	warnings := append([]string{}, r.Warnings...)
+	return map[string]interface{}{"denied": denied, "unused": unused, "warnings": warnings}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProfile(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "profile.sb")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("write profile: %v", err)
+	}
+	return path
+}
+
+func TestRunAuditReportsDenials(t *testing.T) {
+	profile := writeProfile(t, "(version 1)\n(deny default)\n(allow file-read* (subpath (param \"HOME\")))\n(allow file-write* (literal \"/unused\"))\n")
+	trace := `{"timestamp":"2025-11-29T10:00:00.000","pid":1,"comm":"mytool","op":"open","path":"/Users/a/.zshrc"}` + "\n" +
+		"10:00:00.050 write /tmp/out 0.0001 mytool.1\n"
+	var out, errBuf bytes.Buffer
+	code := RunAudit(AuditConfig{
+		ProfilePath: profile,
+		Params:      []string{"HOME=/Users/a"},
+		Stdin:       strings.NewReader(trace),
+		Stdout:      &out,
+		Stderr:      &errBuf,
+	})
+	if code != exitAuditDenied {
+		t.Fatalf("exit code = %d, stderr=%s", code, errBuf.String())
+	}
+	if !strings.Contains(out.String(), "file-write-data /tmp/out (op=write, line 2: (deny default))") {
+		t.Fatalf("denial missing:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "line 4: (allow file-write* (literal \"/unused\"))") {
+		t.Fatalf("unused rule missing:\n%s", out.String())
+	}
+}
+
+func TestRunAuditJSONClean(t *testing.T) {
+	profile := writeProfile(t, "(version 1)\n(allow default)\n")
+	var out bytes.Buffer
+	code := RunAudit(AuditConfig{
+		ProfilePath: profile,
+		JSON:        true,
+		Stdin:       strings.NewReader("10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"),
+		Stdout:      &out,
+		Stderr:      &bytes.Buffer{},
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	var obj map[string][]interface{}
+	if err := json.Unmarshal(bytes.TrimSpace(out.Bytes()), &obj); err != nil {
+		t.Fatalf("json parse error: %v", err)
+	}
+	if len(obj["denied"]) != 0 || len(obj["unused"]) != 0 {
+		t.Fatalf("expected clean audit, got %v", obj)
+	}
+}
+
+func TestRunAuditMissingProfile(t *testing.T) {
+	code := RunAudit(AuditConfig{ProfilePath: filepath.Join(t.TempDir(), "missing.sb"), Stdin: strings.NewReader(""), Stdout: &bytes.Buffer{}, Stderr: &bytes.Buffer{}})
+	if code != exitInvalidArgs {
+		t.Fatalf("exit code = %d, want %d", code, exitInvalidArgs)
+	}
+}
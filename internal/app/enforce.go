@@ -0,0 +1,83 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/hokupod/fs-tracer/internal/landlock"
+)
+
+// EnforceConfig controls RunEnforce; zero values pick sensible defaults.
+type EnforceConfig struct {
+	PolicyPath string
+	Command    []string
+	Stderr     io.Writer
+	// Restrict applies the policy to the calling thread; defaults to landlock.Restrict.
+	Restrict func(landlock.Policy) ([]string, error)
+	// Exec replaces the process image; defaults to syscall.Exec.
+	Exec     func(argv0 string, argv, envv []string) error
+	LookPath func(file string) (string, error)
+}
+
+// RunEnforce confines the current thread with a Landlock policy and execs the
+// command from it, so the command runs under the same ruleset that was traced.
+// It only returns when the policy or command cannot be applied or started.
+func RunEnforce(cfg EnforceConfig) int {
+	stderr := cfg.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	restrict := cfg.Restrict
+	if restrict == nil {
+		restrict = landlock.Restrict
+	}
+	execFn := cfg.Exec
+	if execFn == nil {
+		execFn = syscall.Exec
+	}
+	lookPath := cfg.LookPath
+	if lookPath == nil {
+		lookPath = exec.LookPath
+	}
+
+	if len(cfg.Command) == 0 {
+		fmt.Fprintln(stderr, "yourcmd is required after --")
+		return exitInvalidArgs
+	}
+	f, err := os.Open(cfg.PolicyPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to read policy:", err)
+		return exitInvalidArgs
+	}
+	policy, err := landlock.LoadPolicy(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to parse policy:", err)
+		return exitInvalidArgs
+	}
+	path, err := lookPath(cfg.Command[0])
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to start command:", err)
+		return exitCmdStartErr
+	}
+
+	// The Landlock domain belongs to this thread; exec must happen from it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	skipped, err := restrict(policy)
+	if err != nil {
+		fmt.Fprintln(stderr, "failed to apply policy:", err)
+		return exitCmdStartErr
+	}
+	for _, p := range skipped {
+		fmt.Fprintln(stderr, "landlock: skipped missing path", p)
+	}
+	if err := execFn(path, cfg.Command, os.Environ()); err != nil {
+		fmt.Fprintln(stderr, "failed to start command:", err)
+	}
+	return exitCmdStartErr
+}
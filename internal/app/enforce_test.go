@@ -0,0 +1,90 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/landlock"
+)
+
+func writePolicy(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatalf("write policy: %v", err)
+	}
+	return path
+}
+
+func TestRunEnforceRestrictsThenExecs(t *testing.T) {
+	policy := writePolicy(t, `{"version":1,"rules":[{"path":"/etc","access":["read_file","read_dir"]},{"path":"/gone","access":["read_file"]}]}`)
+	var calls []string
+	var gotArgv []string
+	var errBuf bytes.Buffer
+	code := RunEnforce(EnforceConfig{
+		PolicyPath: policy,
+		Command:    []string{"mytool", "--build"},
+		Stderr:     &errBuf,
+		LookPath:   func(string) (string, error) { return "/usr/bin/mytool", nil },
+		Restrict: func(p landlock.Policy) ([]string, error) {
+			calls = append(calls, "restrict")
+			if len(p.Rules) != 2 {
+				t.Fatalf("unexpected rules: %+v", p.Rules)
+			}
+			return []string{"/gone"}, nil
+		},
+		Exec: func(argv0 string, argv, _ []string) error {
+			calls = append(calls, "exec "+argv0)
+			gotArgv = argv
+			return errors.New("exec stub")
+		},
+	})
+	if code != exitCmdStartErr {
+		t.Fatalf("exit code = %d", code)
+	}
+	if !reflect.DeepEqual(calls, []string{"restrict", "exec /usr/bin/mytool"}) {
+		t.Fatalf("calls = %v", calls)
+	}
+	if !reflect.DeepEqual(gotArgv, []string{"mytool", "--build"}) {
+		t.Fatalf("argv = %v", gotArgv)
+	}
+	if !strings.Contains(errBuf.String(), "skipped missing path /gone") {
+		t.Fatalf("missing path warning absent: %s", errBuf.String())
+	}
+}
+
+func TestRunEnforceRejectsBadPolicy(t *testing.T) {
+	policy := writePolicy(t, `{"version":1,"rules":[{"path":"/etc","access":["fly"]}]}`)
+	restricted := false
+	code := RunEnforce(EnforceConfig{
+		PolicyPath: policy,
+		Command:    []string{"true"},
+		Stderr:     &bytes.Buffer{},
+		Restrict:   func(landlock.Policy) ([]string, error) { restricted = true; return nil, nil },
+		Exec:       func(string, []string, []string) error { return nil },
+	})
+	if code != exitInvalidArgs || restricted {
+		t.Fatalf("exit code = %d, restricted = %v", code, restricted)
+	}
+}
+
+func TestRunEnforceRestrictFailureDoesNotExec(t *testing.T) {
+	policy := writePolicy(t, `{"version":1,"rules":[]}`)
+	executed := false
+	code := RunEnforce(EnforceConfig{
+		PolicyPath: policy,
+		Command:    []string{"true"},
+		Stderr:     &bytes.Buffer{},
+		LookPath:   func(string) (string, error) { return "/bin/true", nil },
+		Restrict:   func(landlock.Policy) ([]string, error) { return nil, landlock.ErrUnsupported },
+		Exec:       func(string, []string, []string) error { executed = true; return nil },
+	})
+	if code != exitCmdStartErr || executed {
+		t.Fatalf("exit code = %d, executed = %v", code, executed)
+	}
+}
@@ -0,0 +1,11 @@
+package app
+
+// Profile formats register themselves with the sandbox generator registry.
+import (
+	_ "github.com/hokupod/fs-tracer/internal/apparmor"
+	_ "github.com/hokupod/fs-tracer/internal/bwrap"
+	_ "github.com/hokupod/fs-tracer/internal/entitlements"
+	_ "github.com/hokupod/fs-tracer/internal/landlock"
+	_ "github.com/hokupod/fs-tracer/internal/oci"
+	_ "github.com/hokupod/fs-tracer/internal/systemd"
+)
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/args"
+	"github.com/hokupod/fs-tracer/pkg/fstrace"
+)
+
+// TestMain lets the test binary act as the gate helper, as fs-tracer does.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == fstrace.GateCommand {
+		os.Exit(fstrace.RunGate(os.Args[2:], os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+// pidRunner serves r and records the PID it was asked to trace.
+type pidRunner struct {
+	r   io.ReadCloser
+	pid chan int
+}
+
+func (p pidRunner) Run(pid int, comm string) (io.ReadCloser, error) {
+	p.pid <- pid
+	return p.r, nil
+}
+
+func gatedConfig(opts args.Options, runner pidRunner, stdout, stderr io.Writer, script string) Config {
+	return Config{
+		Options:          opts,
+		Runner:           runner,
+		Stdout:           stdout,
+		Stderr:           stderr,
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder: func([]string) (*exec.Cmd, error) {
+			return exec.Command("sh", "-c", script), nil
+		},
+		Executable: os.Args[0],
+	}
+}
+
+func TestRunWaitAttachHoldsCommand(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "started")
+	pr, pw := io.Pipe()
+	runner := pidRunner{r: pr, pid: make(chan int, 1)}
+	var out, errBuf syncBuffer
+	done := make(chan int, 1)
+	opts := args.Options{Command: commandArgs(), WaitAttach: true, AttachDelay: time.Minute}
+	go func() {
+		done <- Run(gatedConfig(opts, runner, &out, &errBuf, "echo $$; touch "+marker))
+	}()
+	var pid int
+	select {
+	case pid = <-runner.pid:
+	case code := <-done:
+		t.Fatalf("Run returned %d before starting the tracer: %q", code, errBuf.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("the tracer was never started")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("yourcmd ran before the tracer reported anything")
+	}
+	go fmt.Fprintln(pw, "10:00:00.000 open /etc/hosts 0.0001 sh.1")
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Fatalf("exit code = %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("yourcmd was not released after the first trace line")
+	}
+	// exec keeps the PID, so fs_usage was pointed at yourcmd itself.
+	if got := strings.SplitN(out.String(), "\n", 2)[0]; got != strconv.Itoa(pid) {
+		t.Fatalf("yourcmd PID = %q, runner traced %d", got, pid)
+	}
+	if !strings.Contains(out.String(), "/etc/hosts") {
+		t.Fatalf("trace missing: %q", out.String())
+	}
+}
+
+func TestRunWaitAttachGivesUp(t *testing.T) {
+	pr, _ := io.Pipe()
+	runner := pidRunner{r: pr, pid: make(chan int, 1)}
+	var out, errBuf syncBuffer
+	opts := args.Options{Command: commandArgs(), WaitAttach: true, AttachDelay: 100 * time.Millisecond}
+	code := Run(gatedConfig(opts, runner, &out, &errBuf, "echo ran"))
+	if code != 0 || !strings.Contains(out.String(), "ran") {
+		t.Fatalf("exit code %d, stdout %q", code, out.String())
+	}
+	if !strings.Contains(errBuf.String(), "no trace output after 100ms; starting yourcmd anyway") {
+		t.Fatalf("give-up notice missing: %q", errBuf.String())
+	}
+}
+
+func TestRunAttachDelay(t *testing.T) {
+	runner := pidRunner{r: io.NopCloser(strings.NewReader("")), pid: make(chan int, 1)}
+	var out syncBuffer
+	opts := args.Options{Command: commandArgs(), AttachDelay: 150 * time.Millisecond}
+	start := time.Now()
+	code := Run(gatedConfig(opts, runner, &out, &bytes.Buffer{}, "echo ran"))
+	if code != 0 || !strings.Contains(out.String(), "ran") {
+		t.Fatalf("exit code %d, stdout %q", code, out.String())
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("yourcmd released after %s", elapsed)
+	}
+}
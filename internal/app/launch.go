@@ -0,0 +1,51 @@
+package app
+
+import (
+	"path/filepath"
+
+	"github.com/hokupod/fs-tracer/internal/args"
+	"github.com/hokupod/fs-tracer/internal/output"
+)
+
+// launchFlags returns the fs-tracer flags that recreate yourcmd's execution
+// environment, for trace metadata. Paths are made absolute so the flags work
+// from any directory.
+func launchFlags(opts args.Options) []string {
+	var out []string
+	if opts.CleanEnv {
+		out = append(out, "--clean-env")
+	}
+	for _, key := range opts.UnsetEnv {
+		out = append(out, "--unset-env", key)
+	}
+	for _, kv := range opts.Env {
+		out = append(out, "--env", kv)
+	}
+	if opts.Cwd != "" {
+		out = append(out, "--cwd", absPath(opts.Cwd))
+	}
+	if opts.Stdin != "" {
+		out = append(out, "--stdin", absPath(opts.Stdin))
+	}
+	if opts.RunAs != "" {
+		out = append(out, "--run-as", opts.RunAs)
+	}
+	return out
+}
+
+// launchSettings returns the same settings as launchFlags, for the JSON
+// envelope.
+func launchSettings(opts args.Options) output.Launch {
+	l := output.Launch{CleanEnv: opts.CleanEnv, UnsetEnv: opts.UnsetEnv, Env: opts.Env, RunAs: opts.RunAs}
+	if opts.Stdin != "" {
+		l.Stdin = absPath(opts.Stdin)
+	}
+	return l
+}
+
+func absPath(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/args"
+)
+
+func TestLaunchFlags(t *testing.T) {
+	got := launchFlags(args.Options{
+		CleanEnv: true,
+		UnsetEnv: []string{"TERM"},
+		Env:      []string{"LANG=C"},
+		Cwd:      "/srv",
+		Stdin:    "/dev/null",
+		RunAs:    "nobody",
+	})
+	want := []string{"--clean-env", "--unset-env", "TERM", "--env", "LANG=C", "--cwd", "/srv", "--stdin", "/dev/null", "--run-as", "nobody"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("launchFlags = %v, want %v", got, want)
+	}
+	if got := launchFlags(args.Options{}); got != nil {
+		t.Fatalf("launchFlags without options = %v", got)
+	}
+}
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+
+	"github.com/hokupod/fs-tracer/internal/args"
+)
+
+// outputs are the destinations of fs-tracer results and of yourcmd's own
+// stdout and stderr.
+type outputs struct {
+	results   io.Writer
+	cmdStdout io.Writer
+	cmdStderr io.Writer
+	closers   []io.Closer
+}
+
+// openOutputs applies -o/--output-fd and --cmd-stdout/--cmd-stderr. By
+// default results and yourcmd's stdout share stdout.
+func openOutputs(opts args.Options, stdout, stderr io.Writer) (*outputs, error) {
+	o := &outputs{results: stdout, cmdStdout: stdout, cmdStderr: stderr}
+	open := func(path string) (io.Writer, error) {
+		f, err := createOutput(path)
+		if err != nil {
+			o.Close()
+			return nil, err
+		}
+		o.closers = append(o.closers, f)
+		return f, nil
+	}
+	var err error
+	switch {
+	case opts.Output != "":
+		if o.results, err = open(opts.Output); err != nil {
+			return nil, err
+		}
+	case opts.OutputFD > 0:
+		// Write to a duplicate: closing it, or its finalizer, must not close
+		// the caller's descriptor.
+		fd, err := syscall.Dup(opts.OutputFD)
+		if err != nil {
+			o.Close()
+			return nil, fmt.Errorf("--output-fd %d is not open", opts.OutputFD)
+		}
+		syscall.CloseOnExec(fd)
+		f := os.NewFile(uintptr(fd), "fd "+strconv.Itoa(opts.OutputFD))
+		o.closers = append(o.closers, f)
+		o.results = f
+	}
+	if opts.CmdStdout != "" {
+		if o.cmdStdout, err = open(opts.CmdStdout); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CmdStderr != "" {
+		if o.cmdStderr, err = open(opts.CmdStderr); err != nil {
+			return nil, err
+		}
+	}
+	// Files are shared with yourcmd directly; other writers get a copier
+	// goroutine that --stream output must not race with.
+	if _, isFile := o.results.(*os.File); opts.Stream && !isFile && o.results == o.cmdStdout {
+		shared := &lockedWriter{w: o.results}
+		o.results, o.cmdStdout = shared, shared
+	}
+	return o, nil
+}
+
+// Close closes the files opened for the outputs.
+func (o *outputs) Close() error {
+	var first error
+	for _, c := range o.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	o.closers = nil
+	return first
+}
+
+// createOutput creates or truncates path. Under sudo the file is handed to
+// the invoking user, who also owns yourcmd's files.
+func createOutput(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if uid, gid, ok := sudoOwner(); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() {
+			if st, ok := fi.Sys().(*syscall.Stat_t); ok && st.Uid == 0 {
+				_ = f.Chown(uid, gid)
+			}
+		}
+	}
+	return f, nil
+}
+
+// sudoOwner returns the invoking user's ids when running as root via sudo.
+func sudoOwner() (uid, gid int, ok bool) {
+	if os.Geteuid() != 0 {
+		return 0, 0, false
+	}
+	uid, errU := strconv.Atoi(os.Getenv("SUDO_UID"))
+	gid, errG := strconv.Atoi(os.Getenv("SUDO_GID"))
+	if errU != nil || errG != nil {
+		return 0, 0, false
+	}
+	return uid, gid, true
+}
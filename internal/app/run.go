@@ -1,27 +1,26 @@
 package app
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"strconv"
+	"os/signal"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
 	"github.com/hokupod/fs-tracer/internal/args"
 	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
 	"github.com/hokupod/fs-tracer/internal/output"
 	"github.com/hokupod/fs-tracer/internal/processor"
-	"github.com/hokupod/fs-tracer/internal/procinfo"
 	"github.com/hokupod/fs-tracer/internal/sandbox"
+	"github.com/hokupod/fs-tracer/pkg/fstrace"
 )
 
 const (
@@ -31,59 +30,6 @@ const (
 	exitScanErr     = 93
 )
 
-type pidTracker struct {
-	mu     sync.RWMutex
-	allow  map[uint64]struct{}
-	bypass bool
-}
-
-const zeroMatchBypassThreshold = 50
-
-func newPIDTracker(rootPID int) *pidTracker {
-	return &pidTracker{allow: map[uint64]struct{}{uint64(rootPID): {}}}
-}
-
-func (t *pidTracker) allowID(id int) bool {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	if t.bypass {
-		return true
-	}
-	_, ok := t.allow[uint64(id)]
-	return ok
-}
-
-func (t *pidTracker) addPID(pid int) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	t.allow[uint64(pid)] = struct{}{}
-}
-
-func (t *pidTracker) addThreads(tids []uint64) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	for _, tid := range tids {
-		t.allow[tid] = struct{}{}
-	}
-}
-
-// setBypass enables allow-all mode; returns true when state changed.
-func (t *pidTracker) setBypass() bool {
-	t.mu.Lock()
-	defer t.mu.Unlock()
-	if t.bypass {
-		return false
-	}
-	t.bypass = true
-	return true
-}
-
-func (t *pidTracker) isBypass() bool {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	return t.bypass
-}
-
 // Config controls Run behavior; zero values pick sensible defaults.
 type Config struct {
 	Options          args.Options
@@ -97,10 +43,28 @@ type Config struct {
 	ChildFinder      func(rootPID int) ([]int, error)
 	ThreadLister     func(pid int) ([]uint64, error)
 	CommFinder       func(pid int) (string, error)
+	// KillGrace is how long yourcmd gets to exit after SIGTERM when a stop
+	// condition fires; defaults to 5s.
+	KillGrace time.Duration
+	// Signals delivers signals to forward to yourcmd; nil subscribes to
+	// SIGINT, SIGTERM, SIGHUP and SIGQUIT.
+	Signals <-chan os.Signal
+	// Exit ends the process on a second signal; defaults to os.Exit.
+	Exit func(code int)
+	// Executable is the fs-tracer binary re-executed as the gate helper for
+	// --wait-attach and --attach-delay; defaults to os.Executable.
+	Executable string
+	// Version is recorded in the JSON envelope; defaults to "dev".
+	Version string
 }
 
+// forwardedSignals are relayed to yourcmd instead of terminating fs-tracer.
+var forwardedSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
 // Run executes yourcmd, collects fs_usage events, and writes output. It returns
 // the intended process exit code (yourcmd or internal error in 90–99 range).
+// The trace itself is fstrace.Trace; Run adds the CLI's outputs and signal
+// handling.
 func Run(cfg Config) int {
 	opts := cfg.Options
 
@@ -114,20 +78,6 @@ func Run(cfg Config) int {
 	if stderr == nil {
 		stderr = os.Stderr
 	}
-	runner := cfg.Runner
-	if runner == nil {
-		runner = fsusage.SudoFsUsageRunner{NoSudo: opts.NoSudo, All: opts.FollowChildren}
-	}
-	builder := cfg.CmdBuilder
-	if builder == nil {
-		builder = defaultCmdBuilder
-	}
-	baseDate := time.Now
-	if cfg.BaseDate != nil {
-		baseDate = cfg.BaseDate
-	}
-	baseDateValue := baseDate()
-
 	ensureSudo := cfg.EnsureSudo
 	if ensureSudo == nil {
 		ensureSudo = defaultEnsureSudo
@@ -138,284 +88,231 @@ func Run(cfg Config) int {
 		return exitCmdStartErr
 	}
 
-	cmd, err := builder(opts.Command)
+	outs, err := openOutputs(opts, stdout, stderr)
 	if err != nil {
-		fmt.Fprintln(stderr, "failed to build command:", err)
+		fmt.Fprintln(stderr, "failed to open output:", err)
 		return exitInvalidArgs
 	}
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Env = os.Environ()
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	cmd.Stdin = os.Stdin
+	defer outs.Close()
+	stdout = outs.results
 
-	if err := applyCredential(cmd); err != nil {
-		fmt.Fprintln(stderr, err)
-		return exitInvalidArgs
+	// Subscribe before starting yourcmd so an early Ctrl-C is forwarded too.
+	sigs := cfg.Signals
+	if sigs == nil {
+		ch := make(chan os.Signal, 2)
+		signal.Notify(ch, forwardedSignals...)
+		defer signal.Stop(ch)
+		sigs = ch
 	}
-
-	if err := cmd.Start(); err != nil {
-		fmt.Fprintln(stderr, "failed to start yourcmd:", err)
-		return exitCmdStartErr
+	exit := cfg.Exit
+	if exit == nil {
+		exit = os.Exit
 	}
 
-	targetPID := cmd.Process.Pid
-
-	// Apply Go-side PID filtering only when we intentionally broaden fs_usage to all PIDs
-	// (i.e., --follow-children). When fs_usage is already invoked with the target PID,
-	// kernel-side filtering is sufficient and thread-id vs pid formatting differences
-	// in fs_usage output would otherwise drop valid events.
-	filterPID := opts.FollowChildren && !cfg.DisablePIDFilter && !opts.NoPIDFilter
-
-	var (
-		allowPID    func(int) bool
-		stopFollow  chan struct{}
-		tracker     *pidTracker
-		allowedComm map[string]struct{}
-	)
-
-	addComm := func(c string) {
-		if c == "" {
-			return
-		}
-		if allowedComm == nil {
-			allowedComm = make(map[string]struct{})
-		}
-		allowedComm[c] = struct{}{}
+	var stream *streamer
+	if opts.Stream {
+		stream = newStreamer(stdout, opts)
 	}
-
-	if !filterPID {
-		allowPID = func(int) bool { return true }
-	} else if opts.FollowChildren {
-		rootPID := targetPID
-		tracker = newPIDTracker(rootPID)
-		threadLister := cfg.ThreadLister
-		if threadLister == nil {
-			threadLister = procinfo.ListThreads
-		}
-		commFinder := cfg.CommFinder
-		if commFinder == nil {
-			commFinder = defaultCommFinder
-		}
-
-		knownPIDs := map[int]struct{}{rootPID: {}}
-
-		addPIDWithThreads := func(pid int) {
-			tracker.addPID(pid)
-			tids, err := threadLister(pid)
-			if err != nil {
-				if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || strings.Contains(err.Error(), "protection") {
-					// Mach protection failure etc. → すぐに comm-only に寄せる
-					if tracker.setBypass() {
-						fmt.Fprintln(stderr, "pid filter switched to comm-only: thread lookup blocked (permission/protection)")
-					}
-					return
-				}
-				if !errors.Is(err, syscall.ESRCH) && !errors.Is(err, syscall.EINVAL) {
-					if tracker.setBypass() {
-						fmt.Fprintln(stderr, "pid filter disabled after thread lookup failure:", err)
-					}
-				}
-				return
-			}
-			if c, err := commFinder(pid); err == nil {
-				cBase := filepath.Base(c)
-				addComm(cBase)
-				// thread handles are only trusted when their comm is known/allowed to reduce accidental PID collisions.
-				if len(tids) > 0 {
-					if _, ok := allowedComm[cBase]; ok {
-						tracker.addThreads(tids)
-					}
-				}
-			}
+	topts := traceOptions(cfg, outs, sigs, stderr, debug)
+	if stream != nil {
+		topts.OnEvent = func(ev fstrace.Event) { stream.emit(fsusage.Event(ev)) }
+	}
+	res, err := fstrace.Trace(context.Background(), topts)
+	if err != nil {
+		var abort *fstrace.AbortError
+		if errors.As(err, &abort) {
+			fmt.Fprintf(stderr, "%v; exiting without output\n", abort)
+			code := 128 + int(abort.Signal)
+			exit(code)
+			return code
 		}
+		fmt.Fprintln(stderr, err)
+		return exitCodeForError(err)
+	}
+	defer res.Close()
 
-		addPIDWithThreads(rootPID)
+	if unknown := unknownOps(res.Ops); len(unknown) > 0 {
+		fmt.Fprintln(stderr, "unclassified ops (treated as writes when named like one, reads otherwise):", strings.Join(unknown, ", "))
+	}
 
-		finder := cfg.ChildFinder
-		if finder == nil {
-			finder = defaultChildFinder
+	meta := traceMeta{command: opts.Command, executable: res.Executable, tracedAt: res.Start, dir: res.Dir, launch: launchFlags(opts), settings: launchSettings(opts), version: cfg.Version}
+	if stream != nil {
+		if opts.JSON {
+			stream.summary(envelope(meta, res))
 		}
-
-		updateChildren := func() {
-			children, err := finder(rootPID)
-			if err != nil {
-				if debug {
-					fmt.Fprintln(stderr, "child finder error:", err)
-				}
-				return
-			}
-			for _, c := range children {
-				if _, ok := knownPIDs[c]; ok {
-					continue
-				}
-				knownPIDs[c] = struct{}{}
-				addPIDWithThreads(c)
-			}
+		if err := stream.Err(); err != nil {
+			fmt.Fprintln(stderr, "output error:", err)
+			return exitScanErr
 		}
-
-		refreshThreads := func() {
-			for pid := range knownPIDs {
-				addPIDWithThreads(pid)
-			}
-		}
-
-		updateChildren()
-
-		childTicker := time.NewTicker(500 * time.Millisecond)
-		tidTicker := time.NewTicker(2 * time.Second)
-		stopFollow = make(chan struct{})
-		go func() {
-			defer childTicker.Stop()
-			defer tidTicker.Stop()
-			for {
-				select {
-				case <-childTicker.C:
-					updateChildren()
-				case <-tidTicker.C:
-					refreshThreads()
-				case <-stopFollow:
-					return
-				}
-			}
-		}()
-
-		allowPID = tracker.allowID
-	} else {
-		rootPID := targetPID
-		allowPID = func(pid int) bool { return pid == rootPID }
+		return res.ExitCode
 	}
-
-	defer func() {
-		if stopFollow != nil {
-			close(stopFollow)
+	if opts.MinFrequency > 0 && profileFormat(opts) != "" {
+		minRuns := int(math.Ceil(opts.MinFrequency * float64(res.Runs)))
+		if dropped := res.Prune(minRuns); dropped > 0 {
+			fmt.Fprintf(stderr, "omitted %d paths seen in fewer than %d of %d runs\n", dropped, minRuns, res.Runs)
 		}
-	}()
-
-	comm := cmd.Path
-	if comm == "" && len(cmd.Args) > 0 {
-		comm = cmd.Args[0]
-	}
-	if filterPID && opts.FollowChildren {
-		addComm(filepath.Base(comm))
 	}
-	runnerPID := targetPID
-	reader, err := runner.Run(runnerPID, filepath.Base(comm))
-	if err != nil {
-		_ = cmd.Process.Kill()
-		fmt.Fprintln(stderr, "failed to start fs_usage:", err)
-		return exitFsUsageErr
+	if err := render(stdout, stderr, opts, meta, res); err != nil {
+		fmt.Fprintln(stderr, "output error:", err)
+		return exitScanErr
 	}
 
-	eventsCh := make(chan fsusage.Event)
-	scanErrCh := make(chan error, 1)
-
-	// Collector drains events concurrently to avoid blocking fs_usage scanner.
-	var (
-		events        []fsusage.Event
-		collectDoneCh = make(chan struct{})
-	)
-	go func() {
-		for ev := range eventsCh {
-			events = append(events, ev)
-		}
-		close(collectDoneCh)
-	}()
-
-	go func(r io.ReadCloser) {
-		defer close(eventsCh)
-		scanner := bufio.NewScanner(r)
-		scanner.Buffer(make([]byte, 0, 128*1024), 512*1024)
-		parsedCount := 0
-		passedCount := 0
-		zeroMatchNotified := false
-		for scanner.Scan() {
-			line := scanner.Text()
-			if debug {
-				fmt.Fprintln(stderr, "fs_usage:", line)
-			}
-			ev, err := fsusage.ParseLine(line, baseDateValue)
-			if err != nil {
-				if debug {
-					fmt.Fprintln(stderr, "parse error:", err, "line:", line)
-				}
-				continue
-			}
-			// Always skip fs-tracer itself to avoid self-noise even in bypass/fallback paths.
-			if ev.PID == os.Getpid() || ev.Comm == filepath.Base(os.Args[0]) {
-				continue
-			}
-			if filterPID {
-				parsedCount++
-				allowed := allowPID(ev.PID)
-				// Always permit events whose comm is already known, to reduce reliance on TID/PID formatting.
-				if !allowed {
-					if _, ok := allowedComm[ev.Comm]; ok {
-						allowed = true
-					}
-				}
-				if !allowed && passedCount == 0 && parsedCount >= zeroMatchBypassThreshold && tracker != nil && !tracker.isBypass() {
-					if !zeroMatchNotified {
-						fmt.Fprintln(stderr, "pid filter switched to comm-only after zero-match streak")
-						zeroMatchNotified = true
-					}
-					// Fall back to comm-based allowlist for the current and future events of this comm.
-					addComm(ev.Comm)
-					if _, ok := allowedComm[ev.Comm]; ok {
-						allowed = true
-					}
-				}
-				if !allowed {
-					continue
-				}
-				passedCount++
-			}
-			eventsCh <- ev
-		}
-		if err := scanner.Err(); err != nil {
-			scanErrCh <- err
-		}
-	}(reader)
-
-	errCmd := cmd.Wait()
-	_ = reader.Close()
-
-	// Wait for collector to finish draining events.
-	<-collectDoneCh
-
-	select {
-	case scanErr := <-scanErrCh:
-		if scanErr != nil {
-			if !isBenignClose(scanErr) {
-				fmt.Fprintln(stderr, "fs_usage read error:", scanErr)
-				return exitScanErr
-			}
-		}
-	default:
+	if debug && res.Stats.Kept == 0 {
+		fmt.Fprintln(stderr, "debug: no events after filtering")
 	}
 
-	filters := processor.Filters{
+	return res.ExitCode
+}
+
+// traceOptions maps the CLI options onto the library's.
+func traceOptions(cfg Config, outs *outputs, sigs <-chan os.Signal, stderr io.Writer, debug bool) fstrace.Options {
+	opts := cfg.Options
+	topts := fstrace.Options{
+		Command:         opts.Command,
+		Dir:             opts.Cwd,
+		Env:             opts.Env,
+		UnsetEnv:        opts.UnsetEnv,
+		CleanEnv:        opts.CleanEnv,
+		Stdin:           os.Stdin,
+		StdinFile:       opts.Stdin,
+		Stdout:          outs.cmdStdout,
+		Stderr:          outs.cmdStderr,
+		RunAs:           opts.RunAs,
+		NoSudo:          opts.NoSudo,
+		FollowChildren:  opts.FollowChildren,
+		NoPIDFilter:     opts.NoPIDFilter,
 		AllowProcesses:  opts.AllowProcesses,
 		IgnoreProcesses: opts.IgnoreProcesses,
-		IgnorePrefixes:  expandPrefixes(opts.IgnorePrefixes, opts.IgnoreCWD),
-		MaxDepth:        opts.MaxDepth,
+		IgnorePrefixes:  opts.IgnorePrefixes,
+		IgnoreCWD:       opts.IgnoreCWD,
 		Raw:             opts.Raw,
+		Categories:      opts.OpCategories,
+		MaxDepth:        opts.MaxDepth,
+		DirsOnly:        opts.DirsOnly,
+		Timeout:         opts.Timeout,
+		MaxEvents:       opts.MaxEvents,
+		StopOnPath:      opts.StopOnPath,
+		KillGrace:       cfg.KillGrace,
+		Repeat:          opts.Repeat,
+		// Only --events needs the events themselves; other modes aggregate them.
+		KeepEvents:     opts.Events && !opts.Stream,
+		MemoryLimit:    opts.MemoryLimit,
+		WaitAttach:     opts.WaitAttach,
+		AttachDelay:    opts.AttachDelay,
+		GateExecutable: cfg.Executable,
+		Signals:        sigs,
+		Log:            stderr,
+		Debug:          debug,
+		Hooks: fstrace.Hooks{
+			NewCmd:           cfg.CmdBuilder,
+			BaseDate:         cfg.BaseDate,
+			ChildFinder:      cfg.ChildFinder,
+			ThreadLister:     cfg.ThreadLister,
+			CommFinder:       cfg.CommFinder,
+			DisablePIDFilter: cfg.DisablePIDFilter,
+		},
 	}
-	filtered := processor.ApplyFilters(events, filters)
+	if cfg.Runner != nil {
+		topts.Runner = cfg.Runner
+	}
+	return topts
+}
 
-	if err := render(stdout, opts, filtered); err != nil {
-		fmt.Fprintln(stderr, "output error:", err)
+// exitCodeForError maps a failed trace to fs-tracer's internal exit codes.
+func exitCodeForError(err error) int {
+	var terr *fstrace.Error
+	if !errors.As(err, &terr) {
 		return exitScanErr
 	}
+	switch terr.Stage {
+	case fstrace.StageSetup:
+		return exitInvalidArgs
+	case fstrace.StageStart:
+		return exitCmdStartErr
+	case fstrace.StageTracer:
+		return exitFsUsageErr
+	}
+	return exitScanErr
+}
 
-	if debug && len(filtered) == 0 {
-		fmt.Fprintln(stderr, "debug: no events after filtering")
+// unknownOps returns the op names missing from the catalogue.
+func unknownOps(names []string) []string {
+	var out []string
+	for _, op := range names {
+		if _, ok := ops.Lookup(op); !ok {
+			out = append(out, op)
+		}
 	}
+	return out
+}
 
-	return exitCodeFromCmd(errCmd)
+// traceMeta carries facts about the traced run that some output modes record.
+type traceMeta struct {
+	command    []string
+	executable string
+	tracedAt   time.Time
+	dir        string
+	// launch holds the fs-tracer flags that recreate yourcmd's environment.
+	launch []string
+	// settings holds the same launch settings for the JSON envelope.
+	settings output.Launch
+	// version is fs-tracer's, for the JSON envelope.
+	version string
 }
 
-func render(w io.Writer, opts args.Options, events []fsusage.Event) error {
+// backend names the event source in the JSON envelope.
+const backend = "fs_usage"
+
+// envelope describes the trace for JSON output.
+func envelope(meta traceMeta, res *fstrace.Result) output.Envelope {
+	version := meta.version
+	if version == "" {
+		version = "dev"
+	}
+	st := res.Stats
+	parsed := st.Lines - st.Unparseable
+	return output.Envelope{
+		SchemaVersion: output.SchemaVersion,
+		Version:       version,
+		Backend:       backend,
+		Command:       meta.executable,
+		Argv:          meta.command,
+		Cwd:           meta.dir,
+		Launch:        meta.settings,
+		Start:         res.Start,
+		End:           res.End,
+		ExitCode:      res.ExitCode,
+		Runs:          res.Runs,
+		Truncated:     res.Truncated,
+		Interrupted:   res.Interrupted,
+		Events: output.EventCounts{
+			Lines:       st.Lines,
+			Parsed:      parsed,
+			Unparseable: st.Unparseable,
+			Captured:    st.Captured,
+			Filtered:    st.Captured - st.Kept,
+			Kept:        st.Kept,
+			Spilled:     st.Spilled,
+		},
+		PIDFilter: output.PIDFilter{
+			Enabled:            st.PIDFilter,
+			ThreadLookupBypass: st.ThreadLookupBypass,
+			ZeroMatchBypass:    st.ZeroMatchBypass,
+		},
+	}
+}
+
+// writeJSON writes v as one line.
+func writeJSON(w io.Writer, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+func render(w, errw io.Writer, opts args.Options, meta traceMeta, res *fstrace.Result) error {
 	headerPrinted := false
 	printHeader := func() {}
 	if !opts.JSON {
@@ -429,190 +326,234 @@ func render(w io.Writer, opts args.Options, events []fsusage.Event) error {
 	}
 
 	if opts.Events {
-		if opts.JSON {
-			lines, err := output.EventsJSONLines(events)
-			if err != nil {
-				return err
-			}
-			for _, line := range lines {
-				fmt.Fprintln(w, line)
-			}
-			return nil
-		}
 		printHeader()
-		for _, ev := range events {
-			fmt.Fprintln(w, output.EventLine(ev))
+		err := res.Events(func(e fstrace.Event) error {
+			ev := fsusage.Event(e)
+			line := output.EventLine(ev)
+			if opts.JSON {
+				var err error
+				if line, err = output.EventJSON(ev); err != nil {
+					return err
+				}
+			}
+			_, err := fmt.Fprintln(w, line)
+			return err
+		})
+		if err != nil || !opts.JSON {
+			return err
 		}
-		return nil
+		return writeJSON(w, envelope(meta, res))
 	}
 
 	// Non-events output
-	if opts.SandboxSnippet {
-		printHeader()
-		read, write := processor.ClassifyPaths(events, opts.DirsOnly)
-		snippet := sandbox.BuildSnippets(read, write)
-		fmt.Fprintln(w, snippet)
+	if format := profileFormat(opts); format != "" {
+		g, err := sandbox.ParseFormat(format)
+		if err != nil {
+			return err
+		}
+		in, err := generatorInput(opts, meta, res)
+		if err != nil {
+			return err
+		}
+		out, err := g.Generate(in)
+		if err != nil {
+			return err
+		}
+		if out.Banner {
+			printHeader()
+		}
+		if _, err := w.Write(out.Data); err != nil {
+			return err
+		}
+		for _, warning := range out.Warnings {
+			fmt.Fprintln(errw, warning)
+		}
+		printRuleReport(errw, opts, out.Rules)
 		return nil
 	}
 
+	if res.Runs > 1 {
+		return renderFrequencies(w, opts, meta, res, printHeader)
+	}
+
 	if opts.SplitAccess {
-		read, write := processor.ClassifyPaths(events, opts.DirsOnly)
+		read, write := res.Reads, res.Writes
 		if opts.JSON {
-			obj := map[string][]string{"read": read, "write": write}
-			b, err := json.Marshal(obj)
-			if err != nil {
-				return err
-			}
-			fmt.Fprintln(w, string(b))
-			return nil
+			return writeJSON(w, struct {
+				output.Envelope
+				Read  []string `json:"read"`
+				Write []string `json:"write"`
+			}{envelope(meta, res), read, write})
 		}
 		printHeader()
-		fmt.Fprintln(w, output.SplitAccessText(read, write))
-		return nil
+		_, err := fmt.Fprintln(w, output.SplitAccessText(read, write))
+		return err
 	}
 
-	paths := processor.UniqueSortedPaths(events, opts.DirsOnly)
+	paths := res.Paths
 	if opts.JSON {
-		b, err := output.PathsJSON(paths)
-		if err != nil {
-			return err
-		}
-		fmt.Fprintln(w, string(b))
-		return nil
+		return writeJSON(w, struct {
+			output.Envelope
+			Paths []string `json:"paths"`
+		}{envelope(meta, res), paths})
 	}
 	printHeader()
-	fmt.Fprintln(w, output.PathsText(paths))
-	return nil
+	_, err := fmt.Fprintln(w, output.PathsText(paths))
+	return err
 }
 
-func exitCodeFromCmd(err error) int {
-	if err == nil {
-		return 0
-	}
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
-			if status.Signaled() {
-				return 128 + int(status.Signal())
-			}
-			return status.ExitStatus()
+// renderFrequencies writes the path list or read/write sets of a --repeat
+// trace, each path with the number of runs that accessed it.
+func renderFrequencies(w io.Writer, opts args.Options, meta traceMeta, res *fstrace.Result, printHeader func()) error {
+	runs := res.Runs
+	seen := make(map[string]int, len(res.Accesses))
+	for _, acc := range res.Accesses {
+		seen[acc.Path] = acc.Runs
+	}
+	withRuns := func(paths []string) []output.PathRuns {
+		out := make([]output.PathRuns, 0, len(paths))
+		for _, p := range paths {
+			out = append(out, output.PathRuns{Path: p, Seen: seen[p]})
 		}
+		return out
 	}
-	return exitCmdStartErr
-}
-
-func isBenignClose(err error) bool {
-	msg := err.Error()
-	return strings.Contains(msg, "file already closed") || strings.Contains(msg, "use of closed file") || errors.Is(err, io.ErrClosedPipe)
-}
-
-func expandPrefixes(prefixes []string, ignoreCwd bool) []string {
-	out := make([]string, 0, len(prefixes)+1)
-	cwd := ""
-	if ignoreCwd {
-		if wd, err := os.Getwd(); err == nil {
-			cwd = wd
+	lines := func(paths []output.PathRuns) []string {
+		out := make([]string, 0, len(paths))
+		for _, p := range paths {
+			out = append(out, output.FrequencyLine(p, runs))
 		}
+		return out
 	}
-	for _, p := range prefixes {
-		if p == "." && cwd != "" {
-			out = append(out, cwd)
-			continue
+
+	if opts.SplitAccess {
+		reads, writes := withRuns(res.Reads), withRuns(res.Writes)
+		if opts.JSON {
+			return writeJSON(w, struct {
+				output.Envelope
+				Read  []output.PathRuns `json:"read"`
+				Write []output.PathRuns `json:"write"`
+			}{envelope(meta, res), reads, writes})
 		}
-		out = append(out, p)
-	}
-	if ignoreCwd && cwd != "" {
-		out = append(out, cwd)
+		printHeader()
+		_, err := fmt.Fprintln(w, output.SplitAccessText(lines(reads), lines(writes)))
+		return err
 	}
-	return out
-}
 
-func defaultChildFinder(rootPID int) ([]int, error) {
-	cmd := exec.Command("ps", "-Ao", "pid,ppid")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+	paths := withRuns(res.Paths)
+	if opts.JSON {
+		return writeJSON(w, struct {
+			output.Envelope
+			Paths []output.PathRuns `json:"paths"`
+		}{envelope(meta, res), paths})
 	}
-	return parseDescendants(rootPID, output)
+	printHeader()
+	_, err := fmt.Fprintln(w, output.PathsText(lines(paths)))
+	return err
 }
 
-func defaultCommFinder(pid int) (string, error) {
-	cmd := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=")
-	out, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(out)), nil
+// profileFormat returns the generator selected by --profile-format or one of
+// its shorthand flags, or "" for the path list.
+func profileFormat(opts args.Options) string {
+	switch {
+	case opts.ProfileFormat != "":
+		return opts.ProfileFormat
+	case opts.SandboxSnippet:
+		return sandbox.FormatSnippet
+	case opts.SandboxProfile:
+		return sandbox.FormatProfile
+	case opts.LandlockPolicy:
+		return "landlock"
+	case opts.BwrapScript:
+		return "bwrap"
+	case opts.SystemdDropIn:
+		return "systemd"
+	case opts.AppArmorProfile:
+		return "apparmor"
+	case opts.OCIMounts:
+		return "oci"
+	case opts.DockerVolumes:
+		return "docker"
+	case opts.Entitlements:
+		return "entitlements"
+	}
+	return ""
 }
 
-func parseDescendants(rootPID int, psOutput []byte) ([]int, error) {
-	scanner := bufio.NewScanner(bytes.NewReader(psOutput))
-	parents := make(map[int]int)
-	for scanner.Scan() {
-		fields := strings.Fields(scanner.Text())
-		if len(fields) < 2 {
-			continue
-		}
-		pid, err1 := strconv.Atoi(fields[0])
-		ppid, err2 := strconv.Atoi(fields[1])
-		if err1 != nil || err2 != nil {
-			continue
-		}
-		parents[pid] = ppid
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+func generatorInput(opts args.Options, meta traceMeta, res *fstrace.Result) (sandbox.Input, error) {
+	base, err := sandbox.ParseBase(opts.SandboxBase)
+	if err != nil {
+		return sandbox.Input{}, err
 	}
-	return collectDescendants(rootPID, parents), nil
-}
-
-func collectDescendants(rootPID int, parents map[int]int) []int {
-	out := []int{}
-	queue := []int{rootPID}
-	for len(queue) > 0 {
-		current := queue[0]
-		queue = queue[1:]
-		for pid, ppid := range parents {
-			if ppid == current {
-				out = append(out, pid)
-				queue = append(queue, pid)
-			}
+	accesses := make([]processor.Access, 0, len(res.Accesses))
+	for _, acc := range res.Accesses {
+		cats := make([]ops.Category, 0, len(acc.Categories))
+		for _, c := range acc.Categories {
+			cats = append(cats, ops.Category(c))
 		}
-	}
-	return out
+		accesses = append(accesses, processor.Access{Path: acc.Path, Categories: cats})
+	}
+	return sandbox.Input{
+		Command:        meta.command,
+		Executable:     meta.executable,
+		Dir:            meta.dir,
+		Launch:         meta.launch,
+		TracedAt:       meta.tracedAt,
+		Home:           res.Home,
+		Accesses:       accesses,
+		Reads:          res.Reads,
+		Writes:         res.Writes,
+		Execs:          sandbox.Execs(accesses),
+		Network:        sandbox.NetworkOps(res.Ops),
+		Base:           base,
+		LeastPrivilege: opts.LeastPrivilege,
+		Rules:          ruleOptions(opts, res),
+	}, nil
 }
 
-func defaultCmdBuilder(argv []string) (*exec.Cmd, error) {
-	if len(argv) == 0 {
-		return nil, fmt.Errorf("no command specified")
+func ruleOptions(opts args.Options, res *fstrace.Result) sandbox.RuleOptions {
+	return sandbox.RuleOptions{
+		Collapse: processor.CollapseOptions{
+			MinCount: opts.CollapseCount,
+			MinRatio: opts.CollapseRatio,
+		},
+		Regex:  opts.RegexRules,
+		Params: sandboxParams(opts, res.Home, res.Dir),
 	}
-	cmd := exec.Command(argv[0], argv[1:]...)
-	return cmd, nil
 }
 
-func applyCredential(cmd *exec.Cmd) error {
-	if os.Geteuid() != 0 {
+// sandboxParams returns the default roots (when enabled) followed by user
+// substitutions; user entries override defaults of the same name. home and
+// cwd are yourcmd's, which --run-as, --env, --clean-env and --cwd change.
+func sandboxParams(opts args.Options, home, cwd string) []sandbox.Param {
+	if !opts.SandboxParams && len(opts.ParamDefs) == 0 {
 		return nil
 	}
-	sudoUID := os.Getenv("SUDO_UID")
-	sudoGID := os.Getenv("SUDO_GID")
-	if sudoUID == "" || sudoGID == "" {
-		return fmt.Errorf("running as root is unsupported without SUDO_UID/GID; yourcmd must run as original user")
+	tmpdir := os.Getenv("TMPDIR")
+	if tmpdir == "" {
+		tmpdir = os.TempDir()
 	}
-	uid, err := strconv.ParseUint(sudoUID, 10, 32)
-	if err != nil {
-		return fmt.Errorf("invalid SUDO_UID: %w", err)
+	var user []sandbox.Param
+	names := map[string]struct{}{}
+	for _, def := range opts.ParamDefs {
+		if p, err := sandbox.ParseParam(def); err == nil {
+			user = append(user, p)
+			names[p.Name] = struct{}{}
+		}
 	}
-	gid, err := strconv.ParseUint(sudoGID, 10, 32)
-	if err != nil {
-		return fmt.Errorf("invalid SUDO_GID: %w", err)
+	var out []sandbox.Param
+	for _, p := range sandbox.DefaultParams(home, tmpdir, cwd) {
+		if _, ok := names[p.Name]; !ok {
+			out = append(out, p)
+		}
 	}
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Credential: &syscall.Credential{
-			Uid: uint32(uid),
-			Gid: uint32(gid),
-		},
+	return append(out, user...)
+}
+
+func printRuleReport(w io.Writer, opts args.Options, report []sandbox.Rule) {
+	if !opts.RuleReport || len(report) == 0 {
+		return
 	}
-	return nil
+	fmt.Fprintln(w, sandbox.FormatReport(report))
 }
 
 func defaultEnsureSudo(noSudo bool) error {
@@ -8,14 +8,17 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sort"
+	"regexp"
 	"strings"
+	"sync"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/hokupod/fs-tracer/internal/args"
+	"github.com/hokupod/fs-tracer/internal/landlock"
 	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/sandbox"
 )
 
 type fakeRunner struct {
@@ -26,6 +29,18 @@ func (f fakeRunner) Run(pid int, comm string) (io.ReadCloser, error) {
 	return io.NopCloser(strings.NewReader(f.data)), nil
 }
 
+// seqRunner returns the next log on each call, for --repeat.
+type seqRunner struct {
+	logs  []string
+	calls *int
+}
+
+func (s seqRunner) Run(pid int, comm string) (io.ReadCloser, error) {
+	data := s.logs[*s.calls%len(s.logs)]
+	*s.calls++
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
 type templRunner struct {
 	template string
 }
@@ -110,15 +125,120 @@ func TestRunSplitAccessJSON(t *testing.T) {
 	if code != 0 {
 		t.Fatalf("exit code = %d", code)
 	}
-	var obj map[string][]string
+	var obj struct {
+		Read  []string `json:"read"`
+		Write []string `json:"write"`
+	}
 	if err := json.Unmarshal(bytes.TrimSpace(out.Bytes()), &obj); err != nil {
 		t.Fatalf("json parse error: %v", err)
 	}
-	if len(obj["read"]) != 1 || obj["read"][0] != "/etc/hosts" {
-		t.Fatalf("read set mismatch: %v", obj["read"])
+	if len(obj.Read) != 1 || obj.Read[0] != "/etc/hosts" {
+		t.Fatalf("read set mismatch: %v", obj.Read)
+	}
+	if len(obj.Write) != 1 || obj.Write[0] != "/tmp/out" {
+		t.Fatalf("write set mismatch: %v", obj.Write)
+	}
+}
+
+// withoutSummary strips the envelope that ends line-oriented JSON output.
+func withoutSummary(t *testing.T, out string) (string, output.Envelope) {
+	t.Helper()
+	body, last := "", strings.TrimSuffix(out, "\n")
+	if i := strings.LastIndex(last, "\n"); i >= 0 {
+		body, last = last[:i+1], last[i+1:]
+	}
+	var env output.Envelope
+	if err := json.Unmarshal([]byte(last), &env); err != nil || env.SchemaVersion != output.SchemaVersion {
+		t.Fatalf("output does not end with a summary line (%v):\n%s", err, out)
+	}
+	return body, env
+}
+
+// pathsOf decodes the path list of a JSON document.
+func pathsOf(t *testing.T, data []byte) []string {
+	t.Helper()
+	var doc struct {
+		Paths []string `json:"paths"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("json parse error: %v in %s", err, data)
+	}
+	return doc.Paths
+}
+
+func TestRunJSONEnvelope(t *testing.T) {
+	opts := args.Options{Command: []string{"mytool", "--build"}, JSON: true, IgnorePrefixes: []string{"/tmp"}}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n" +
+		"10:00:00.001 write /tmp/out 0.0001 mytool.1\n" +
+		"garbage\n"
+	var out bytes.Buffer
+	code := Run(Config{
+		Options:          opts,
+		Runner:           fakeRunner{data: log},
+		Stdout:           &out,
+		Stderr:           &bytes.Buffer{},
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder:       func([]string) (*exec.Cmd, error) { return exec.Command("sh", "-c", "exit 3"), nil },
+		Version:          "1.2.3",
+	})
+	if code != 3 {
+		t.Fatalf("exit code = %d", code)
+	}
+	var doc struct {
+		output.Envelope
+		Paths []string `json:"paths"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("json parse error: %v", err)
+	}
+	env := doc.Envelope
+	cwd, _ := os.Getwd()
+	if env.SchemaVersion != output.SchemaVersion || env.Version != "1.2.3" || env.Backend != "fs_usage" ||
+		filepath.Base(env.Command) != "sh" || strings.Join(env.Argv, " ") != "mytool --build" || env.Cwd != cwd {
+		t.Fatalf("envelope = %+v", env)
+	}
+	if env.ExitCode != 3 || env.Runs != 1 || env.Interrupted || env.Start.IsZero() || env.End.Before(env.Start) {
+		t.Fatalf("envelope = %+v", env)
+	}
+	want := output.EventCounts{Lines: 3, Parsed: 2, Unparseable: 1, Captured: 2, Filtered: 1, Kept: 1}
+	if l := env.Launch; l.CleanEnv || l.UnsetEnv != nil || l.Env != nil || l.Stdin != "" || l.RunAs != "" {
+		t.Fatalf("launch = %+v", l)
+	}
+	if env.Events != want || env.PIDFilter != (output.PIDFilter{}) {
+		t.Fatalf("counts = %+v, pid filter = %+v", env.Events, env.PIDFilter)
+	}
+	if len(doc.Paths) != 1 || doc.Paths[0] != "/etc/hosts" {
+		t.Fatalf("paths = %v", doc.Paths)
+	}
+}
+
+func TestRunJSONEnvelopeLaunch(t *testing.T) {
+	stdin := filepath.Join(t.TempDir(), "input.txt")
+	if err := os.WriteFile(stdin, []byte("data\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := args.Options{
+		Command:  commandArgs(),
+		JSON:     true,
+		CleanEnv: true,
+		UnsetEnv: []string{"LANG"},
+		Env:      []string{"HOME=/tmp/home", "TOKEN=x"},
+		Stdin:    stdin,
+	}
+	code, out, errOut := runBounded(t, opts, "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n", noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d, stderr = %s", code, errOut)
+	}
+	var env output.Envelope
+	if err := json.Unmarshal([]byte(out), &env); err != nil {
+		t.Fatalf("json parse error: %v", err)
 	}
-	if len(obj["write"]) != 1 || obj["write"][0] != "/tmp/out" {
-		t.Fatalf("write set mismatch: %v", obj["write"])
+	l := env.Launch
+	if !l.CleanEnv || strings.Join(l.UnsetEnv, ",") != "LANG" || strings.Join(l.Env, ",") != "HOME=/tmp/home,TOKEN=x" ||
+		l.Stdin != stdin || l.RunAs != "" {
+		t.Fatalf("launch = %+v", l)
 	}
 }
 
@@ -139,7 +259,8 @@ func TestRunEventsJSON(t *testing.T) {
 	if code != 0 {
 		t.Fatalf("exit code = %d", code)
 	}
-	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	body, _ := withoutSummary(t, out.String())
+	lines := strings.Split(strings.TrimSpace(body), "\n")
 	if len(lines) != 1 {
 		t.Fatalf("expected 1 line, got %d", len(lines))
 	}
@@ -273,7 +394,7 @@ func TestRunFollowChildrenAllowsThreadIDs(t *testing.T) {
 }
 
 func TestRunFollowChildrenThreadLookupFailureDisablesFilter(t *testing.T) {
-	opts := args.Options{Command: commandArgs(), FollowChildren: true}
+	opts := args.Options{Command: commandArgs(), FollowChildren: true, JSON: true}
 	logTemplate := "10:00:00.000 open /tmp/thread 0.0001 root.%d\n" +
 		"10:00:00.010 open /tmp/tid 0.0001 root.%d\n"
 	var out bytes.Buffer
@@ -298,9 +419,13 @@ func TestRunFollowChildrenThreadLookupFailureDisablesFilter(t *testing.T) {
 	if code != 0 {
 		t.Fatalf("exit code = %d", code)
 	}
-	if !strings.Contains(out.String(), "/tmp/tid") {
+	if !contains(pathsOf(t, out.Bytes()), "/tmp/tid") {
 		t.Fatalf("expected output despite thread lookup failure, got: %q", out.String())
 	}
+	var env output.Envelope
+	if err := json.Unmarshal(out.Bytes(), &env); err != nil || env.PIDFilter != (output.PIDFilter{Enabled: true, ThreadLookupBypass: true}) {
+		t.Fatalf("pid filter = %+v (%v)", env.PIDFilter, err)
+	}
 }
 
 func TestRunFollowChildrenZeroMatchBypass(t *testing.T) {
@@ -437,34 +562,719 @@ func TestRunPIDFilterDisabledWhenNotFollowingChildren(t *testing.T) {
 	}
 }
 
-func TestParseDescendants(t *testing.T) {
-	ps := "  PID  PPID\n  10   1\n  11   10\n  12   1\n  13   12\n"
-	desc, err := parseDescendants(1, []byte(ps))
+func contains(list []string, target string) bool {
+	for _, s := range list {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRunSandboxSnippetLeastPrivilege(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), SandboxSnippet: true, LeastPrivilege: true}
+	log := "10:00:00.000 stat64 /etc/hosts 0.0001 mytool.1\n10:00:00.050 mkdir /tmp/dir 0.0001 mytool.1\n"
+	var out bytes.Buffer
+	code := Run(Config{
+		Options:          opts,
+		Runner:           fakeRunner{data: log},
+		Stdout:           &out,
+		Stderr:           &bytes.Buffer{},
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder:       noopBuilder,
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	s := out.String()
+	if !strings.Contains(s, "(allow file-read-metadata\n  (literal \"/etc/hosts\")") || !strings.Contains(s, "(allow file-write-create\n  (literal \"/tmp/dir\")") {
+		t.Fatalf("least-privilege snippet mismatch: %s", s)
+	}
+	if strings.Contains(s, "file-read*") || strings.Contains(s, "file-write*") {
+		t.Fatalf("wildcard operations should not appear: %s", s)
+	}
+}
+
+func TestRunLeastPrivilegeOpenThenWrite(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), SandboxSnippet: true, LeastPrivilege: true}
+	log := "10:00:00.000 open /tmp/new 0.0001 mytool.1\n10:00:00.001 write /tmp/new 0.0001 mytool.1\n"
+	_, out, _ := runBounded(t, opts, log, noopBuilder)
+	if !strings.Contains(out, "(allow file-write-create\n  (literal \"/tmp/new\")") {
+		t.Fatalf("created file not allowed: %s", out)
+	}
+}
+
+func TestRunSandboxParamsFollowYourcmd(t *testing.T) {
+	dir := t.TempDir()
+	opts := args.Options{Command: commandArgs(), SandboxProfile: true, SandboxParams: true, Env: []string{"HOME=/home/tracee"}, Cwd: dir}
+	log := "10:00:00.000 open /home/tracee/.netrc 0.0001 mytool.1\n10:00:00.001 open " + dir + "/go.mod 0.0001 mytool.1\n"
+	_, out, errOut := runBounded(t, opts, log, noopBuilder)
+	for _, want := range []string{"-D HOME=/home/tracee", dir + " -f profile.sb", `(param "HOME") "/.netrc"`, `(param "PROJECT_DIR") "/go.mod"`} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("profile missing %q:\n%s%s", want, out, errOut)
+		}
+	}
+}
+
+func TestRunSandboxProfile(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), SandboxProfile: true}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.050 write /tmp/out 0.0001 mytool.1\n"
+	var out bytes.Buffer
+	code := Run(Config{
+		Options:          opts,
+		Runner:           fakeRunner{data: log},
+		Stdout:           &out,
+		Stderr:           &bytes.Buffer{},
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder:       noopBuilder,
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	s := out.String()
+	if strings.Contains(s, output.HeaderLine()) {
+		t.Fatalf("profile must not include the banner: %q", s)
+	}
+	for _, want := range []string{";; command: sh -c true", "(version 1)", "(deny default)", "(literal \"/etc/hosts\")", "(literal \"/tmp/out\")"} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("profile missing %q:\n%s", want, s)
+		}
+	}
+}
+
+func TestRunSandboxSnippetCollapseReport(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), SandboxSnippet: true, CollapseCount: 3, RuleReport: true}
+	var b strings.Builder
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&b, "10:00:00.%03d open /cache/foo/f%d 0.0001 mytool.1\n", i, i)
+	}
+	var out, errBuf bytes.Buffer
+	code := Run(Config{
+		Options:          opts,
+		Runner:           fakeRunner{data: b.String()},
+		Stdout:           &out,
+		Stderr:           &errBuf,
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder:       noopBuilder,
+	})
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	if !strings.Contains(out.String(), "(subpath \"/cache/foo\")") || strings.Contains(out.String(), "(literal") {
+		t.Fatalf("expected collapsed subpath only: %s", out.String())
+	}
+	if !strings.Contains(errBuf.String(), "subpath \"/cache/foo\" absorbed 3 literals") {
+		t.Fatalf("rule report missing: %s", errBuf.String())
+	}
+}
+
+func TestRunLandlockPolicy(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), LandlockPolicy: true}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.050 write /tmp/out 0.0001 mytool.1\n"
+	code, out, _ := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	policy, err := landlock.LoadPolicy(strings.NewReader(out))
 	if err != nil {
-		t.Fatalf("parseDescendants error: %v", err)
+		t.Fatalf("policy does not load: %v", err)
 	}
-	got := strings.Join(toStrings(desc), ",")
-	want := "10,11,12,13"
-	if got != want {
-		t.Fatalf("unexpected descendants: %s", got)
+	paths := map[string]bool{}
+	for _, r := range policy.Rules {
+		paths[r.Path] = true
+	}
+	if !paths["/etc/hosts"] || !paths["/tmp/out"] || paths["/tmp"] {
+		t.Fatalf("unexpected rules: %+v", policy.Rules)
 	}
 }
 
-func toStrings(nums []int) []string {
-	out := make([]string, 0, len(nums))
-	sorted := append([]int(nil), nums...)
-	sort.Ints(sorted)
-	for _, n := range sorted {
-		out = append(out, fmt.Sprintf("%d", n))
+func TestRunBwrapScript(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), BwrapScript: true}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.050 write /tmp/out 0.0001 mytool.1\n"
+	code, out, _ := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	for _, want := range []string{"#!/bin/sh\n", "--ro-bind /etc/hosts /etc/hosts", "--bind /tmp /tmp", "-- sh -c true\n"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("script missing %q:\n%s", want, out)
+		}
 	}
-	return out
 }
 
-func contains(list []string, target string) bool {
-	for _, s := range list {
-		if s == target {
-			return true
+func TestRunSystemdDropIn(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), SystemdDropIn: true}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.050 write /var/lib/app/state 0.0001 mytool.1\n"
+	code, out, _ := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	for _, want := range []string{"[Service]\n", "ProtectSystem=strict\n", "ReadWritePaths=-/var/lib/app\n", "ReadOnlyPaths=-/etc/hosts\n"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("drop-in missing %q:\n%s", want, out)
 		}
 	}
-	return false
+}
+
+func TestRunAppArmorProfile(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), AppArmorProfile: true}
+	log := "10:00:00.000 open /etc/mytool.conf 0.0001 mytool.1\n10:00:00.050 write /tmp/out 0.0001 mytool.1\n"
+	code, out, _ := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	for _, want := range []string{"profile fs-tracer-", "include <abstractions/base>", "/etc/mytool.conf r,", "/tmp/out w,"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("profile missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunDockerVolumes(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := args.Options{Command: commandArgs(), DockerVolumes: true}
+	log := "10:00:00.000 open " + in + " 0.0001 mytool.1\n10:00:00.050 write " + dir + "/out/a.o 0.0001 mytool.1\n"
+	code, out, errOut := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	if !strings.Contains(out, "-v "+in+":"+in+":ro") {
+		t.Fatalf("read-only volume missing:\n%s", out)
+	}
+	if !strings.Contains(errOut, "skipped missing path: "+dir+"/out") {
+		t.Fatalf("missing write dir not reported: %s", errOut)
+	}
+}
+
+func TestRunEntitlements(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), Entitlements: true}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.050 write /private/tmp/out 0.0001 mytool.1\n"
+	code, out, _ := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	for _, want := range []string{"<plist version=\"1.0\">", "<string>/etc/hosts</string>", "<string>/private/tmp/out</string>"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("plist missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRunProfileFormatMatchesShorthand(t *testing.T) {
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.050 write /tmp/out 0.0001 mytool.1\n"
+	run := func(opts args.Options) string {
+		t.Helper()
+		opts.Command = commandArgs()
+		var out bytes.Buffer
+		code := Run(Config{
+			Options:          opts,
+			Runner:           fakeRunner{data: log},
+			Stdout:           &out,
+			Stderr:           &bytes.Buffer{},
+			BaseDate:         baseDate,
+			EnsureSudo:       func(bool) error { return nil },
+			DisablePIDFilter: true,
+			CmdBuilder:       noopBuilder,
+		})
+		if code != 0 {
+			t.Fatalf("exit code = %d for %+v", code, opts)
+		}
+		return out.String()
+	}
+	// Profiles record the trace time, which differs between runs.
+	stripTraced := regexp.MustCompile(`(?m)^.*traced: .*$`)
+	for format, opts := range map[string]args.Options{
+		"sandbox-snippet": {SandboxSnippet: true},
+		"sandbox-profile": {SandboxProfile: true},
+		"landlock":        {LandlockPolicy: true},
+		"bwrap":           {BwrapScript: true},
+		"systemd":         {SystemdDropIn: true},
+		"apparmor":        {AppArmorProfile: true},
+		"oci":             {OCIMounts: true},
+		"docker":          {DockerVolumes: true},
+		"entitlements":    {Entitlements: true},
+	} {
+		got := run(args.Options{ProfileFormat: format})
+		want := run(opts)
+		if stripTraced.ReplaceAllString(got, "") != stripTraced.ReplaceAllString(want, "") {
+			t.Fatalf("--profile-format %s differs from its shorthand:\n%s\nwant\n%s", format, got, want)
+		}
+	}
+	if len(sandbox.Formats()) != 9 {
+		t.Fatalf("registered formats = %v", sandbox.Formats())
+	}
+}
+
+func runBounded(t *testing.T, opts args.Options, log string, builder func([]string) (*exec.Cmd, error)) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errBuf bytes.Buffer
+	code = Run(Config{
+		Options:          opts,
+		Runner:           fakeRunner{data: log},
+		Stdout:           &out,
+		Stderr:           &errBuf,
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder:       builder,
+		KillGrace:        200 * time.Millisecond,
+	})
+	return code, out.String(), errBuf.String()
+}
+
+func sleepBuilder(argv []string) (*exec.Cmd, error) {
+	return exec.Command("sleep", "10"), nil
+}
+
+func TestRunMaxEvents(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), MaxEvents: 2}
+	log := "10:00:00.000 open /a 0.0001 mytool.1\n10:00:00.001 open /b 0.0001 mytool.1\n10:00:00.002 open /c 0.0001 mytool.1\n"
+	_, out, errOut := runBounded(t, opts, log, sleepBuilder)
+	if !strings.Contains(out, "/b") || strings.Contains(out, "/c") {
+		t.Fatalf("expected the first two paths only:\n%s", out)
+	}
+	if !strings.Contains(errOut, "trace truncated (--max-events 2 reached)") {
+		t.Fatalf("truncation marker missing: %s", errOut)
+	}
+}
+
+func TestRunStopOnPath(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), StopOnPath: "*.ready"}
+	log := "10:00:00.000 open /a 0.0001 mytool.1\n10:00:00.001 open /run/app.ready 0.0001 mytool.1\n10:00:00.002 open /c 0.0001 mytool.1\n"
+	code, out, errOut := runBounded(t, opts, log, sleepBuilder)
+	if !strings.Contains(out, "/run/app.ready") || strings.Contains(out, "/c") {
+		t.Fatalf("expected paths up to the match:\n%s", out)
+	}
+	if !strings.Contains(errOut, "/run/app.ready matched --stop-on-path *.ready") {
+		t.Fatalf("truncation marker missing: %s", errOut)
+	}
+	if code != 128+int(syscall.SIGTERM) {
+		t.Fatalf("exit code = %d, want SIGTERM status", code)
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), Timeout: 100 * time.Millisecond}
+	log := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"
+	start := time.Now()
+	_, out, errOut := runBounded(t, opts, log, sleepBuilder)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("timeout took %s", elapsed)
+	}
+	if !strings.Contains(out, "/etc/hosts") {
+		t.Fatalf("captured events not rendered:\n%s", out)
+	}
+	if !strings.Contains(errOut, "trace truncated (--timeout 100ms elapsed)") {
+		t.Fatalf("truncation marker missing: %s", errOut)
+	}
+}
+
+func TestRunTimeoutKillsAfterGrace(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), Timeout: 50 * time.Millisecond}
+	stubborn := func([]string) (*exec.Cmd, error) {
+		// Ignored signals are inherited, so sleep ignores SIGTERM too.
+		return exec.Command("sh", "-c", `trap "" TERM; sleep 10`), nil
+	}
+	start := time.Now()
+	code, _, _ := runBounded(t, opts, "", stubborn)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("SIGKILL escalation took %s", elapsed)
+	}
+	if code != 128+int(syscall.SIGKILL) {
+		t.Fatalf("exit code = %d, want SIGKILL status", code)
+	}
+}
+
+func TestRunWithoutStopConditionIsNotTruncated(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), MaxEvents: 10, Timeout: time.Minute}
+	_, _, errOut := runBounded(t, opts, "10:00:00.000 open /a 0.0001 mytool.1\n", noopBuilder)
+	if strings.Contains(errOut, "truncated") {
+		t.Fatalf("unexpected truncation marker: %s", errOut)
+	}
+}
+
+func TestRunForwardsInterrupt(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	var out, errBuf bytes.Buffer
+	done := make(chan int)
+	go func() {
+		done <- Run(Config{
+			Options:          args.Options{Command: commandArgs()},
+			Runner:           fakeRunner{data: "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"},
+			Stdout:           &out,
+			Stderr:           &errBuf,
+			BaseDate:         baseDate,
+			EnsureSudo:       func(bool) error { return nil },
+			DisablePIDFilter: true,
+			CmdBuilder:       sleepBuilder,
+			Signals:          sigs,
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	sigs <- syscall.SIGINT
+	select {
+	case code := <-done:
+		if code != 128+int(syscall.SIGINT) {
+			t.Fatalf("exit code = %d, want SIGINT status", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGINT was not forwarded to yourcmd")
+	}
+	if !strings.Contains(out.String(), "/etc/hosts") {
+		t.Fatalf("captured events not rendered:\n%s", out.String())
+	}
+	if !strings.Contains(errBuf.String(), "trace truncated (interrupted by SIGINT)") {
+		t.Fatalf("interrupted marker missing: %s", errBuf.String())
+	}
+}
+
+func TestRunSecondSignalForcesExit(t *testing.T) {
+	sigs := make(chan os.Signal, 2)
+	exited := make(chan int, 1)
+	var errBuf syncBuffer
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Run(Config{
+			Options:          args.Options{Command: commandArgs()},
+			Runner:           fakeRunner{},
+			Stdout:           &bytes.Buffer{},
+			Stderr:           &errBuf,
+			BaseDate:         baseDate,
+			EnsureSudo:       func(bool) error { return nil },
+			DisablePIDFilter: true,
+			CmdBuilder: func([]string) (*exec.Cmd, error) {
+				return exec.Command("sh", "-c", `trap "" INT; sleep 10`), nil
+			},
+			Signals: sigs,
+			Exit:    func(code int) { exited <- code },
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	sigs <- syscall.SIGINT
+	sigs <- syscall.SIGINT
+	select {
+	case code := <-exited:
+		if code != 128+int(syscall.SIGINT) {
+			t.Fatalf("forced exit code = %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second SIGINT did not force an exit")
+	}
+	<-done
+	if !strings.Contains(errBuf.String(), "SIGINT received again") {
+		t.Fatalf("forced exit not reported: %s", errBuf.String())
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writers.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunStreamPaths(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), Stream: true, IgnoreProcesses: []string{"trustd"}}
+	log := "10:00:00.000 open /b 0.0001 mytool.1\n10:00:00.001 open /a 0.0001 trustd.2\n10:00:00.002 write /b 0.0001 mytool.1\n10:00:00.003 open /a 0.0001 mytool.1\n"
+	_, out, _ := runBounded(t, opts, log, noopBuilder)
+	want := output.HeaderLine() + "\n/b\n/a\n"
+	if out != want {
+		t.Fatalf("stream output = %q, want %q", out, want)
+	}
+}
+
+func TestRunStreamSplitAccessJSON(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), Stream: true, SplitAccess: true, JSON: true}
+	log := "10:00:00.000 open /b 0.0001 mytool.1\n10:00:00.001 close /c 0.0001 mytool.1\n10:00:00.002 write /b 0.0001 mytool.1\n10:00:00.003 open /b 0.0001 mytool.1\n"
+	_, out, _ := runBounded(t, opts, log, noopBuilder)
+	out, _ = withoutSummary(t, out)
+	want := `{"access":"read","path":"/b"}` + "\n" + `{"access":"write","path":"/b"}` + "\n"
+	if out != want {
+		t.Fatalf("stream output = %q, want %q", out, want)
+	}
+}
+
+type pipeRunner struct{ r *io.PipeReader }
+
+func (p pipeRunner) Run(int, string) (io.ReadCloser, error) { return p.r, nil }
+
+func TestRunStreamEventsBeforeExit(t *testing.T) {
+	pr, pw := io.Pipe()
+	sigs := make(chan os.Signal, 1)
+	var out syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- Run(Config{
+			Options:          args.Options{Command: commandArgs(), Stream: true, Events: true, JSON: true},
+			Runner:           pipeRunner{r: pr},
+			Stdout:           &out,
+			Stderr:           &bytes.Buffer{},
+			BaseDate:         baseDate,
+			EnsureSudo:       func(bool) error { return nil },
+			DisablePIDFilter: true,
+			CmdBuilder:       sleepBuilder,
+			Signals:          sigs,
+		})
+	}()
+	go fmt.Fprintln(pw, "10:00:00.000 open /etc/hosts 0.0001 mytool.1")
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), `"path":"/etc/hosts"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("event not streamed while yourcmd runs: %q", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	sigs <- syscall.SIGTERM
+	<-done
+	body, env := withoutSummary(t, out.String())
+	if n := strings.Count(body, "\n"); n != 1 {
+		t.Fatalf("expected a single JSON line, got %q", body)
+	}
+	if !env.Interrupted || env.ExitCode != 128+int(syscall.SIGTERM) {
+		t.Fatalf("summary = %+v", env)
+	}
+}
+
+func TestRunEventsSpillToDisk(t *testing.T) {
+	opts := args.Options{Command: commandArgs(), Events: true, JSON: true, MemoryLimit: 1}
+	log := "10:00:00.000 open /a 0.0001 mytool.1\n10:00:00.001 open /b 0.0001 mytool.1\n10:00:00.002 open /c 0.0001 mytool.1\n"
+	code, out, errOut := runBounded(t, opts, log, noopBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d: %s", code, errOut)
+	}
+	body, env := withoutSummary(t, out)
+	if env.Events.Spilled != 3 {
+		t.Fatalf("summary = %+v", env.Events)
+	}
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"/a"`) || !strings.Contains(lines[2], `"/c"`) {
+		t.Fatalf("spilled events not replayed in order:\n%s", out)
+	}
+}
+
+func echoBuilder([]string) (*exec.Cmd, error) {
+	return exec.Command("sh", "-c", "echo from-cmd; echo cmd-err >&2"), nil
+}
+
+func TestRunOutputFileSeparatesResults(t *testing.T) {
+	res := filepath.Join(t.TempDir(), "results.json")
+	opts := args.Options{Command: commandArgs(), JSON: true, Output: res}
+	code, out, _ := runBounded(t, opts, "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n", echoBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d", code)
+	}
+	if out != "from-cmd\n" {
+		t.Fatalf("stdout should carry only yourcmd output, got %q", out)
+	}
+	b, err := os.ReadFile(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := pathsOf(t, b); len(paths) != 1 || paths[0] != "/etc/hosts" {
+		t.Fatalf("results file = %q", b)
+	}
+}
+
+func TestRunCmdStreamsRedirected(t *testing.T) {
+	dir := t.TempDir()
+	opts := args.Options{
+		Command:   commandArgs(),
+		JSON:      true,
+		CmdStdout: filepath.Join(dir, "out.log"),
+		CmdStderr: filepath.Join(dir, "err.log"),
+	}
+	_, out, errOut := runBounded(t, opts, "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n", echoBuilder)
+	if paths := pathsOf(t, []byte(out)); strings.Count(out, "\n") != 1 || len(paths) != 1 {
+		t.Fatalf("stdout should carry only results, got %q", out)
+	}
+	if strings.Contains(errOut, "cmd-err") {
+		t.Fatalf("yourcmd stderr not redirected: %q", errOut)
+	}
+	for name, want := range map[string]string{"out.log": "from-cmd\n", "err.log": "cmd-err\n"} {
+		if b, _ := os.ReadFile(filepath.Join(dir, name)); string(b) != want {
+			t.Fatalf("%s = %q, want %q", name, b, want)
+		}
+	}
+}
+
+func TestRunOutputFD(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "fd.out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	opts := args.Options{Command: commandArgs(), JSON: true, OutputFD: int(f.Fd())}
+	_, out, _ := runBounded(t, opts, "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n", noopBuilder)
+	if out != "" {
+		t.Fatalf("stdout = %q, want results on the fd", out)
+	}
+	if b, _ := os.ReadFile(f.Name()); len(pathsOf(t, b)) != 1 {
+		t.Fatalf("fd output = %q", b)
+	}
+
+	opts.OutputFD = 987
+	if code, _, errOut := runBounded(t, opts, "", noopBuilder); code != exitInvalidArgs || !strings.Contains(errOut, "--output-fd 987 is not open") {
+		t.Fatalf("closed fd: code %d, stderr %q", code, errOut)
+	}
+}
+
+func TestRunOutputWriteError(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	for _, format := range []string{"", "landlock"} {
+		opts := args.Options{Command: commandArgs(), Output: "/dev/full", ProfileFormat: format}
+		code, _, errOut := runBounded(t, opts, "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n", noopBuilder)
+		if code != exitScanErr || !strings.Contains(errOut, "output error:") {
+			t.Fatalf("format %q: code %d, stderr %q", format, code, errOut)
+		}
+	}
+}
+
+func runRepeated(t *testing.T, opts args.Options, logs ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	var out, errBuf bytes.Buffer
+	calls := 0
+	opts.Command = commandArgs()
+	code = Run(Config{
+		Options:          opts,
+		Runner:           seqRunner{logs: logs, calls: &calls},
+		Stdout:           &out,
+		Stderr:           &errBuf,
+		BaseDate:         baseDate,
+		EnsureSudo:       func(bool) error { return nil },
+		DisablePIDFilter: true,
+		CmdBuilder:       noopBuilder,
+	})
+	if calls != opts.Repeat {
+		t.Fatalf("traced %d runs, want %d", calls, opts.Repeat)
+	}
+	return code, out.String(), errBuf.String()
+}
+
+func TestRunRepeatReportsFrequency(t *testing.T) {
+	both := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.001 open /etc/flaky 0.0001 mytool.1\n"
+	hosts := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"
+
+	_, out, _ := runRepeated(t, args.Options{Repeat: 3}, both, hosts, hosts)
+	if !strings.Contains(out, "3/3 /etc/hosts\n") || !strings.Contains(out, "1/3 /etc/flaky\n") {
+		t.Fatalf("frequencies missing:\n%s", out)
+	}
+
+	_, out, _ = runRepeated(t, args.Options{Repeat: 2, JSON: true}, both, hosts)
+	want := `"runs":2,` + `"interrupted":false,`
+	if !strings.Contains(out, want) || !strings.HasSuffix(out, `"paths":[{"path":"/etc/flaky","seen":1},{"path":"/etc/hosts","seen":2}]}`+"\n") {
+		t.Fatalf("JSON = %s", out)
+	}
+
+	_, out, _ = runRepeated(t, args.Options{Repeat: 2, JSON: true, SplitAccess: true}, both, hosts)
+	if !strings.Contains(out, want) || !strings.HasSuffix(out, `"read":[{"path":"/etc/flaky","seen":1},{"path":"/etc/hosts","seen":2}],"write":[]}`+"\n") {
+		t.Fatalf("split JSON = %s", out)
+	}
+}
+
+func TestRunMinFrequencyPrunesProfile(t *testing.T) {
+	both := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n10:00:00.001 open /etc/flaky 0.0001 mytool.1\n"
+	hosts := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"
+
+	_, out, _ := runRepeated(t, args.Options{Repeat: 2, SandboxSnippet: true}, both, hosts)
+	if !strings.Contains(out, "/etc/flaky") {
+		t.Fatalf("without --min-frequency every path belongs in the profile:\n%s", out)
+	}
+
+	_, out, errOut := runRepeated(t, args.Options{Repeat: 2, SandboxSnippet: true, MinFrequency: 1}, both, hosts)
+	if strings.Contains(out, "/etc/flaky") || !strings.Contains(out, "/etc/hosts") {
+		t.Fatalf("--min-frequency 1 kept the wrong paths:\n%s", out)
+	}
+	if !strings.Contains(errOut, "omitted 1 paths seen in fewer than 2 of 2 runs") {
+		t.Fatalf("pruning not reported: %s", errOut)
+	}
+}
+
+func TestRunRepeatStopsOnInterrupt(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	var errBuf bytes.Buffer
+	calls := 0
+	done := make(chan int)
+	go func() {
+		done <- Run(Config{
+			Options:          args.Options{Command: commandArgs(), Repeat: 3},
+			Runner:           seqRunner{logs: []string{"10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"}, calls: &calls},
+			Stdout:           &bytes.Buffer{},
+			Stderr:           &errBuf,
+			BaseDate:         baseDate,
+			EnsureSudo:       func(bool) error { return nil },
+			DisablePIDFilter: true,
+			CmdBuilder:       sleepBuilder,
+			Signals:          sigs,
+		})
+	}()
+	time.Sleep(100 * time.Millisecond)
+	sigs <- syscall.SIGINT
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("interrupted series did not end")
+	}
+	if calls != 1 || !strings.Contains(errBuf.String(), "skipping the remaining 2 of 3 runs") {
+		t.Fatalf("ran %d times; stderr: %s", calls, errBuf.String())
+	}
+}
+
+func TestRunExecutionEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("from-stdin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmdOut := filepath.Join(dir, "out.log")
+	opts := args.Options{
+		Command:        []string{"sh", "-c", `pwd; echo "$FS_TRACER_VAR"; cat`},
+		SandboxProfile: true,
+		Repeat:         2,
+		CleanEnv:       true,
+		Env:            []string{"FS_TRACER_VAR=set", "PATH=" + os.Getenv("PATH")},
+		Cwd:            dir,
+		Stdin:          in,
+		CmdStdout:      cmdOut,
+	}
+	argvBuilder := func(argv []string) (*exec.Cmd, error) { return exec.Command(argv[0], argv[1:]...), nil }
+	code, out, errOut := runBounded(t, opts, "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n", argvBuilder)
+	if code != 0 {
+		t.Fatalf("exit code = %d: %s", code, errOut)
+	}
+	b, err := os.ReadFile(cmdOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved, _ := filepath.EvalSymlinks(dir)
+	once := resolved + "\nset\nfrom-stdin\n"
+	if string(b) != once+once {
+		t.Fatalf("yourcmd saw %q, want the environment twice", b)
+	}
+	if !strings.Contains(out, "launch: --clean-env --env FS_TRACER_VAR=set") || !strings.Contains(out, "--cwd "+dir+" --stdin "+in) {
+		t.Fatalf("profile header does not record the launch flags:\n%s", out)
+	}
 }
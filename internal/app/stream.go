@@ -0,0 +1,110 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/hokupod/fs-tracer/internal/args"
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// streamer writes --stream output: each filtered event with --events, or
+// each path the first time it is seen (per access kind with --split-access).
+type streamer struct {
+	w    io.Writer
+	opts args.Options
+	seen map[string]struct{}
+	err  error
+}
+
+func newStreamer(w io.Writer, opts args.Options) *streamer {
+	s := &streamer{w: w, opts: opts, seen: map[string]struct{}{}}
+	if !opts.JSON {
+		fmt.Fprintln(w, output.HeaderLine())
+	}
+	return s
+}
+
+// emit writes ev if it adds to the output. After a write error the rest of
+// the stream is dropped and the error is reported by Err.
+func (s *streamer) emit(ev fsusage.Event) {
+	if s.err != nil {
+		return
+	}
+	line, err := s.line(ev)
+	if err != nil || line == "" {
+		s.err = err
+		return
+	}
+	_, s.err = fmt.Fprintln(s.w, line)
+}
+
+func (s *streamer) line(ev fsusage.Event) (string, error) {
+	if s.opts.Events {
+		if s.opts.JSON {
+			return output.EventJSON(ev)
+		}
+		return output.EventLine(ev), nil
+	}
+	p := processor.NormalizePath(ev.Path, s.opts.DirsOnly)
+	access := ""
+	if s.opts.SplitAccess {
+		switch c := ops.Classify(ev.Op); {
+		case c.IsWrite():
+			access = "write"
+		case c.ChecksPath():
+			access = "read"
+		default:
+			// Descriptor ops are neither, as in the final read/write sets.
+			return "", nil
+		}
+	}
+	key := access + "\x00" + p
+	if _, ok := s.seen[key]; ok {
+		return "", nil
+	}
+	s.seen[key] = struct{}{}
+	switch {
+	case s.opts.JSON:
+		return output.PathJSON(p, access)
+	case access != "":
+		return access + " " + p, nil
+	default:
+		return p, nil
+	}
+}
+
+// summary ends --json streams with the trace envelope.
+func (s *streamer) summary(env output.Envelope) {
+	if s.err != nil {
+		return
+	}
+	line, err := output.EnvelopeJSON(env)
+	if err != nil {
+		s.err = err
+		return
+	}
+	_, s.err = fmt.Fprintln(s.w, line)
+}
+
+// Err returns the first write error.
+func (s *streamer) Err() error {
+	return s.err
+}
+
+// lockedWriter serializes writes from the streamer and from yourcmd's output
+// copier, which exec runs in its own goroutine when stdout is not a file.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,21 @@
+package apparmor
+
+import "github.com/hokupod/fs-tracer/internal/sandbox"
+
+func init() { sandbox.Register(generator{}) }
+
+// generator emits an AppArmor profile for the traced executable.
+type generator struct{}
+
+func (generator) Name() string { return "apparmor" }
+
+func (generator) Generate(in sandbox.Input) (sandbox.Output, error) {
+	return sandbox.Output{Data: []byte(BuildProfile(Config{
+		Command:    in.Command,
+		Launch:     in.Launch,
+		Executable: in.Executable,
+		TracedAt:   in.TracedAt,
+		Accesses:   in.Accesses,
+		Collapse:   in.Rules.Collapse,
+	}))}, nil
+}
@@ -0,0 +1,294 @@
+// Package apparmor turns traced accesses into an AppArmor profile.
+package apparmor
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// Config describes the traced run a profile is generated for.
+type Config struct {
+	Command []string
+	// Executable is the traced binary; when absolute it becomes the profile's
+	// attachment path.
+	Executable string
+	TracedAt   time.Time
+	Accesses   []processor.Access
+	Collapse   processor.CollapseOptions
+	// Launch holds the fs-tracer flags that recreated the command's
+	// environment; empty omits the header line.
+	Launch []string
+}
+
+// abstraction is an include file together with the paths it already grants
+// read access to, so matching read rules can be left out.
+type abstraction struct {
+	name  string
+	files []string
+	trees []string
+	// always is included regardless of the trace.
+	always bool
+}
+
+var abstractions = []abstraction{
+	{
+		name:   "base",
+		always: true,
+		files:  []string{"/etc/ld.so.cache", "/etc/ld.so.preload", "/etc/locale.alias", "/etc/localtime", "/dev/null", "/dev/zero", "/dev/random", "/dev/urandom", "/dev/tty"},
+		trees:  []string{"/usr/lib/locale", "/usr/share/zoneinfo", "/proc/sys/kernel"},
+	},
+	{
+		name:  "nameservice",
+		files: []string{"/etc/hosts", "/etc/host.conf", "/etc/nsswitch.conf", "/etc/resolv.conf", "/etc/passwd", "/etc/group", "/etc/gai.conf", "/etc/services", "/etc/protocols"},
+	},
+	{
+		name:  "ssl_certs",
+		trees: []string{"/etc/ssl", "/etc/ca-certificates", "/usr/share/ca-certificates", "/etc/pki"},
+	},
+	{
+		name:  "fonts",
+		trees: []string{"/usr/share/fonts", "/etc/fonts", "/var/cache/fontconfig"},
+	},
+}
+
+func (a abstraction) covers(p string) bool {
+	for _, f := range a.files {
+		if p == f {
+			return true
+		}
+	}
+	return processor.WithinAny(p, a.trees)
+}
+
+// Rule is a single file rule: a path (or glob) and its permission letters.
+type Rule struct {
+	Path string
+	Mode string
+	// Dir marks listed directories, which AppArmor matches with a trailing slash.
+	Dir bool
+	// Glob marks collapsed directories rendered as "dir/**".
+	Glob bool
+	// Absorbed lists the traced paths a glob rule replaces.
+	Absorbed []string
+}
+
+// Profile is the content of a generated profile before rendering.
+type Profile struct {
+	Name         string
+	Attachment   string
+	Abstractions []string
+	Rules        []Rule
+}
+
+// Derive computes abstractions and rules. Reads get r, writes w and executed
+// binaries rix, so child processes inherit this profile. Shared objects
+// additionally get m. Paths only stat'ed are left out because AppArmor does
+// not mediate stat. Paths with the same mode are collapsed per cfg.Collapse.
+func Derive(cfg Config) Profile {
+	p := Profile{Name: profileName(cfg)}
+	if filepath.IsAbs(cfg.Executable) {
+		p.Attachment = cfg.Executable
+	}
+
+	used := map[string]bool{}
+	listed := map[string]bool{}
+	byMode := map[string][]string{}
+	for _, acc := range cfg.Accesses {
+		m := mode(acc)
+		if m == "" {
+			continue
+		}
+		if m == "r" || m == "mr" {
+			if a, ok := coveredBy(acc.Path); ok {
+				used[a] = true
+				continue
+			}
+		}
+		if acc.Has(ops.DirectoryList) {
+			listed[acc.Path] = true
+		}
+		byMode[m] = append(byMode[m], acc.Path)
+	}
+	for _, a := range abstractions {
+		if a.always || used[a.name] {
+			p.Abstractions = append(p.Abstractions, a.name)
+		}
+	}
+
+	for m, paths := range byMode {
+		literals, groups := processor.CollapseDirs(paths, cfg.Collapse)
+		for _, l := range literals {
+			p.Rules = append(p.Rules, Rule{Path: l, Mode: m, Dir: listed[l]})
+		}
+		for _, g := range groups {
+			p.Rules = append(p.Rules, Rule{Path: g.Dir, Mode: m, Glob: true, Absorbed: g.Absorbed})
+		}
+	}
+	sort.Slice(p.Rules, func(i, j int) bool {
+		if p.Rules[i].Path != p.Rules[j].Path {
+			return p.Rules[i].Path < p.Rules[j].Path
+		}
+		return p.Rules[i].Mode < p.Rules[j].Mode
+	})
+	return p
+}
+
+// BuildProfile renders an AppArmor profile for the traced command.
+func BuildProfile(cfg Config) string {
+	p := Derive(cfg)
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by fs-tracer\n")
+	if len(cfg.Command) > 0 {
+		buf.WriteString(output.Comment("# ", "command: "+output.ShellJoin(cfg.Command)))
+	}
+	if len(cfg.Launch) > 0 {
+		buf.WriteString(output.Comment("# ", "launch: "+output.ShellJoin(cfg.Launch)))
+	}
+	if !cfg.TracedAt.IsZero() {
+		buf.WriteString(output.Comment("# ", "traced: "+cfg.TracedAt.Format(time.RFC3339)))
+	}
+	buf.WriteString("abi <abi/3.0>,\n")
+	buf.WriteString("include <tunables/global>\n\n")
+	buf.WriteString("profile ")
+	buf.WriteString(quotePath(p.Name, ""))
+	if p.Attachment != "" {
+		buf.WriteByte(' ')
+		buf.WriteString(quotePath(p.Attachment, ""))
+	}
+	buf.WriteString(" {\n")
+	for _, a := range p.Abstractions {
+		fmt.Fprintf(&buf, "  include <abstractions/%s>\n", a)
+	}
+	for _, section := range []struct {
+		title string
+		match func(string) bool
+	}{
+		{"exec transitions", func(m string) bool { return strings.Contains(m, "x") }},
+		{"writes", func(m string) bool { return !strings.Contains(m, "x") && strings.Contains(m, "w") }},
+		{"reads", func(m string) bool { return !strings.ContainsAny(m, "wx") }},
+	} {
+		header := false
+		for _, r := range p.Rules {
+			if !section.match(r.Mode) {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&buf, "\n  # %s\n", section.title)
+				header = true
+			}
+			fmt.Fprintf(&buf, "  %s %s,", rulePath(r), r.Mode)
+			if len(r.Absorbed) > 0 {
+				fmt.Fprintf(&buf, " # %d paths", len(r.Absorbed))
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// mode returns the permission letters for an access, in AppArmor's
+// conventional order.
+func mode(acc processor.Access) string {
+	var read, write, exec bool
+	for _, c := range acc.Categories {
+		switch {
+		case c == ops.Exec:
+			exec = true
+		case c == ops.MetadataRead, !c.ChecksPath():
+		case c.IsWrite():
+			write = true
+		default:
+			read = true
+		}
+	}
+	var b strings.Builder
+	if (read || exec) && isSharedObject(acc.Path) {
+		b.WriteByte('m')
+	}
+	if read || exec {
+		b.WriteByte('r')
+	}
+	if write {
+		b.WriteByte('w')
+	}
+	if exec {
+		b.WriteString("ix")
+	}
+	return b.String()
+}
+
+func coveredBy(p string) (string, bool) {
+	for _, a := range abstractions {
+		if a.covers(p) {
+			return a.name, true
+		}
+	}
+	return "", false
+}
+
+func isSharedObject(p string) bool {
+	base := filepath.Base(p)
+	return strings.HasSuffix(base, ".so") || strings.Contains(base, ".so.")
+}
+
+func profileName(cfg Config) string {
+	switch {
+	case cfg.Executable != "":
+		return "fs-tracer-" + filepath.Base(cfg.Executable)
+	case len(cfg.Command) > 0:
+		return "fs-tracer-" + filepath.Base(cfg.Command[0])
+	default:
+		return "fs-tracer"
+	}
+}
+
+// rulePath renders the path of a rule, adding "/" for directories and "/**"
+// for collapsed trees.
+func rulePath(r Rule) string {
+	p := strings.TrimSuffix(r.Path, "/")
+	switch {
+	case r.Glob:
+		return quotePath(p, "/**")
+	case r.Dir:
+		return quotePath(p, "/")
+	default:
+		return quotePath(r.Path, "")
+	}
+}
+
+// quotePath escapes AppArmor glob and quoting characters so p matches
+// literally, appends the unescaped suffix, and quotes the result when it
+// contains whitespace. Control characters use octal escapes.
+func quotePath(p, suffix string) string {
+	var b strings.Builder
+	quote := false
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		switch {
+		case strings.IndexByte(`\*?[]{}^"#`, c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == ' ' || c == '\t':
+			quote = true
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString(suffix)
+	if quote {
+		return `"` + b.String() + `"`
+	}
+	return b.String()
+}
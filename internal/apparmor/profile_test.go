@@ -0,0 +1,101 @@
+package apparmor
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func access(path string, cats ...ops.Category) processor.Access {
+	return processor.Access{Path: path, Categories: cats}
+}
+
+func TestDerive(t *testing.T) {
+	p := Derive(Config{
+		Executable: "/usr/bin/mytool",
+		Accesses: []processor.Access{
+			access("/etc/hosts", ops.DataRead),
+			access("/etc/mytool.conf", ops.DataRead),
+			access("/opt/plugins/a.so", ops.DataRead),
+			access("/usr/bin/git", ops.Exec),
+			access("/var/cache/mytool", ops.DirectoryList),
+			access("/var/cache/mytool/out", ops.DataWrite, ops.Create),
+			access("/nonexistent", ops.MetadataRead),
+			access("/dev/ttys001", ops.Descriptor),
+		},
+	})
+	if p.Name != "fs-tracer-mytool" || p.Attachment != "/usr/bin/mytool" {
+		t.Fatalf("name/attachment mismatch: %+v", p)
+	}
+	if strings.Join(p.Abstractions, ",") != "base,nameservice" {
+		t.Fatalf("abstractions = %v", p.Abstractions)
+	}
+	var got []string
+	for _, r := range p.Rules {
+		got = append(got, rulePath(r)+" "+r.Mode)
+	}
+	want := "/etc/mytool.conf r|/opt/plugins/a.so mr|/usr/bin/git rix|/var/cache/mytool/ r|/var/cache/mytool/out w"
+	if strings.Join(got, "|") != want {
+		t.Fatalf("rules mismatch:\n got %s\nwant %s", strings.Join(got, "|"), want)
+	}
+}
+
+func TestDeriveCollapse(t *testing.T) {
+	p := Derive(Config{
+		Accesses: []processor.Access{
+			access("/usr/share/app/a", ops.DataRead),
+			access("/usr/share/app/b", ops.DataRead),
+			access("/usr/share/app/c", ops.DataRead),
+		},
+		Collapse: processor.CollapseOptions{MinCount: 3},
+	})
+	if len(p.Rules) != 1 || rulePath(p.Rules[0]) != "/usr/share/app/**" || len(p.Rules[0].Absorbed) != 3 {
+		t.Fatalf("expected collapsed glob rule, got %+v", p.Rules)
+	}
+}
+
+func TestQuotePath(t *testing.T) {
+	cases := map[string]string{
+		"/plain":        "/plain",
+		"/with space":   `"/with space"`,
+		"/glob*[x]{y}?": `/glob\*\[x\]\{y\}\?`,
+		"/ctl\n":        `/ctl\012`,
+	}
+	for in, want := range cases {
+		if got := quotePath(in, ""); got != want {
+			t.Fatalf("quotePath(%q) = %s, want %s", in, got, want)
+		}
+	}
+	if got := quotePath("/a b", "/**"); got != `"/a b/**"` {
+		t.Fatalf("glob suffix must stay inside quotes: %s", got)
+	}
+}
+
+func TestBuildProfile(t *testing.T) {
+	out := BuildProfile(Config{
+		Command:    []string{"mytool", "--build"},
+		Executable: "/usr/bin/mytool",
+		TracedAt:   time.Date(2025, 11, 29, 10, 0, 0, 0, time.UTC),
+		Accesses: []processor.Access{
+			access("/usr/bin/cc", ops.Exec),
+			access("/src/main.c", ops.DataRead),
+			access("/src/main.o", ops.DataWrite),
+		},
+	})
+	for _, want := range []string{
+		"# command: mytool --build\n",
+		"abi <abi/3.0>,\ninclude <tunables/global>\n",
+		"profile fs-tracer-mytool /usr/bin/mytool {\n  include <abstractions/base>\n",
+		"  # exec transitions\n  /usr/bin/cc rix,\n",
+		"  # writes\n  /src/main.o w,\n",
+		"  # reads\n  /src/main.c r,\n",
+		"}\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("profile missing %q:\n%s", want, out)
+		}
+	}
+}
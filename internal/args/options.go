@@ -1,20 +1,59 @@
 package args
 
+import "time"
+
 // Options holds CLI flags parsed from arguments.
 type Options struct {
 	Events          bool
 	JSON            bool
 	SplitAccess     bool
+	ProfileFormat   string
 	SandboxSnippet  bool
+	LeastPrivilege  bool
+	SandboxProfile  bool
+	SandboxBase     string
+	LandlockPolicy  bool
+	BwrapScript     bool
+	SystemdDropIn   bool
+	AppArmorProfile bool
+	OCIMounts       bool
+	DockerVolumes   bool
+	Entitlements    bool
+	CollapseCount   int
+	CollapseRatio   float64
+	RegexRules      bool
+	RuleReport      bool
+	SandboxParams   bool
+	ParamDefs       []string
 	DirsOnly        bool
 	AllowProcesses  []string
 	IgnoreProcesses []string
 	IgnorePrefixes  []string
+	OpCategories    []string
 	NoSudo          bool
 	Raw             bool
 	NoPIDFilter     bool
 	FollowChildren  bool
 	IgnoreCWD       bool
 	MaxDepth        int
+	Timeout         time.Duration
+	MaxEvents       int
+	StopOnPath      string
+	Stream          bool
+	MemoryLimit     int64
+	Output          string
+	OutputFD        int
+	CmdStdout       string
+	CmdStderr       string
+	WaitAttach      bool
+	AttachDelay     time.Duration
+	Repeat          int
+	Env             []string
+	UnsetEnv        []string
+	CleanEnv        bool
+	Cwd             string
+	Stdin           string
+	RunAs           string
+	MinFrequency    float64
 	Command         []string
 }
@@ -0,0 +1,187 @@
+// Package bwrap turns traced read/write sets into a bubblewrap invocation.
+package bwrap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// systemMounts are provided fresh inside the sandbox. Observed paths below
+// /proc and /dev need no bind; paths below /tmp are bound on top of the tmpfs.
+var systemMounts = []Mount{
+	{Kind: "--proc", Path: "/proc"},
+	{Kind: "--dev", Path: "/dev"},
+	{Kind: "--tmpfs", Path: "/tmp"},
+}
+
+// virtualRoots hold kernel-provided files that are never bound from the host.
+var virtualRoots = []string{"/proc", "/dev"}
+
+// Mount is a single bwrap mount option. Binds mount Path onto itself.
+type Mount struct {
+	Kind string
+	Path string
+}
+
+// Args returns the command-line arguments for the mount.
+func (m Mount) Args() []string {
+	switch m.Kind {
+	case "--ro-bind", "--bind":
+		return []string{m.Kind, m.Path, m.Path}
+	default:
+		return []string{m.Kind, m.Path}
+	}
+}
+
+// Config describes the traced run a script is generated for.
+type Config struct {
+	Command  []string
+	TracedAt time.Time
+	// Dir is the working directory of the traced command; empty omits --chdir.
+	Dir      string
+	Reads    []string
+	Writes   []string
+	Collapse processor.CollapseOptions
+	// Exists reports whether a path is present on the host; defaults to os.Lstat.
+	Exists func(string) bool
+	// Launch holds the fs-tracer flags that recreated the command's
+	// environment; empty omits the header line.
+	Launch []string
+}
+
+// Mounts computes the mount list: read-only binds for read paths, writable
+// binds for the directories that received writes and the system mounts.
+// Written paths bind their parent directory so files can be created, renamed
+// into place and removed. Paths are collapsed per cfg.Collapse and nested
+// binds of the same or weaker kind are dropped. Read paths missing on the host
+// are skipped and returned, as are missing write directories, since bwrap
+// refuses to bind them. "/" is never bound, as that would expose the whole
+// host; the paths that needed it are returned in rootless.
+func Mounts(cfg Config) (mounts []Mount, missing, rootless []string) {
+	exists := cfg.Exists
+	if exists == nil {
+		exists = func(p string) bool {
+			_, err := os.Lstat(p)
+			return err == nil
+		}
+	}
+
+	var writeDirs []string
+	for _, p := range cfg.Writes {
+		dir := filepath.Dir(p)
+		switch {
+		case dir == "/":
+			rootless = append(rootless, p)
+		case processor.WithinAny(dir, virtualRoots):
+		case !exists(dir):
+			missing = append(missing, dir)
+		default:
+			writeDirs = append(writeDirs, dir)
+		}
+	}
+	writeRoots := processor.CollapsedRoots(writeDirs, cfg.Collapse)
+
+	var reads []string
+	for _, p := range cfg.Reads {
+		if p == "/" {
+			rootless = append(rootless, p)
+			continue
+		}
+		if processor.WithinAny(p, virtualRoots) {
+			continue
+		}
+		if !exists(p) {
+			missing = append(missing, p)
+			continue
+		}
+		reads = append(reads, p)
+	}
+	var readRoots []string
+	for _, p := range processor.CollapsedRoots(reads, cfg.Collapse) {
+		if !processor.WithinAny(p, writeRoots) {
+			readRoots = append(readRoots, p)
+		}
+	}
+
+	var binds []Mount
+	for _, p := range readRoots {
+		binds = append(binds, Mount{Kind: "--ro-bind", Path: p})
+	}
+	for _, p := range writeRoots {
+		binds = append(binds, Mount{Kind: "--bind", Path: p})
+	}
+	// bwrap applies mounts in order, so parents must precede their children.
+	// The system mounts follow the binds that could cover them; binds below
+	// /tmp go on top of the tmpfs.
+	sort.SliceStable(binds, func(i, j int) bool { return processor.PathLess(binds[i].Path, binds[j].Path) })
+	var inner []Mount
+	for _, b := range binds {
+		if processor.WithinAny(b.Path, systemRoots()) {
+			inner = append(inner, b)
+		} else {
+			mounts = append(mounts, b)
+		}
+	}
+	mounts = append(append(mounts, systemMounts...), inner...)
+	return mounts, processor.MinimalRoots(missing), rootless
+}
+
+func systemRoots() []string {
+	out := make([]string, 0, len(systemMounts))
+	for _, m := range systemMounts {
+		out = append(out, m.Path)
+	}
+	return out
+}
+
+// BuildScript renders a POSIX shell script that runs the traced command
+// under bwrap with the computed mounts. The warnings name the paths left out
+// because they would need a bind of "/".
+func BuildScript(cfg Config) (script string, warnings []string) {
+	mounts, missing, rootless := Mounts(cfg)
+	for _, p := range rootless {
+		warnings = append(warnings, "bwrap: not binding / for "+p+": it would expose the whole host")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("#!/bin/sh\n")
+	buf.WriteString("# Generated by fs-tracer\n")
+	if len(cfg.Command) > 0 {
+		buf.WriteString(output.Comment("# ", "command: "+output.ShellJoin(cfg.Command)))
+	}
+	if len(cfg.Launch) > 0 {
+		buf.WriteString(output.Comment("# ", "launch: "+output.ShellJoin(cfg.Launch)))
+	}
+	if !cfg.TracedAt.IsZero() {
+		buf.WriteString(output.Comment("# ", "traced: "+cfg.TracedAt.Format(time.RFC3339)))
+	}
+	for _, p := range missing {
+		buf.WriteString(output.Comment("# ", "skipped (missing on host): "+p))
+	}
+	for _, p := range rootless {
+		buf.WriteString(output.Comment("# ", "skipped (would bind /): "+p))
+	}
+
+	lines := [][]string{{"--die-with-parent"}, {"--unshare-all", "--share-net"}}
+	for _, m := range mounts {
+		lines = append(lines, m.Args())
+	}
+	if cfg.Dir != "" {
+		lines = append(lines, []string{"--chdir", cfg.Dir})
+	}
+	buf.WriteString("exec bwrap \\\n")
+	for _, l := range lines {
+		buf.WriteString("  ")
+		buf.WriteString(output.ShellJoin(l))
+		buf.WriteString(" \\\n")
+	}
+	buf.WriteString("  -- ")
+	buf.WriteString(output.ShellJoin(cfg.Command))
+	buf.WriteByte('\n')
+	return buf.String(), warnings
+}
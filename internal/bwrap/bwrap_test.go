@@ -0,0 +1,124 @@
+package bwrap
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func existsExcept(missing ...string) func(string) bool {
+	return func(p string) bool {
+		for _, m := range missing {
+			if p == m {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestMounts(t *testing.T) {
+	cfg := Config{
+		Reads:  []string{"/usr/lib/libc.so", "/etc/hosts", "/proc/self/maps", "/home/u/proj/src/main.go", "/nope"},
+		Writes: []string{"/home/u/proj/out/a.o", "/home/u/proj/out/b.o", "/dev/null"},
+		Exists: existsExcept("/nope"),
+	}
+	mounts, missing, _ := Mounts(cfg)
+	got := mountStrings(mounts)
+	want := []string{
+		"--ro-bind /etc/hosts",
+		"--bind /home/u/proj/out",
+		"--ro-bind /home/u/proj/src/main.go",
+		"--ro-bind /usr/lib/libc.so",
+		"--proc /proc",
+		"--dev /dev",
+		"--tmpfs /tmp",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("mounts mismatch:\n got %v\nwant %v", got, want)
+	}
+	if !reflect.DeepEqual(missing, []string{"/nope"}) {
+		t.Fatalf("missing = %v", missing)
+	}
+}
+
+func TestMountsCollapseAndNesting(t *testing.T) {
+	cfg := Config{
+		Reads:    []string{"/opt/x/a", "/opt/x/b", "/opt/x/c", "/srv/data/in.txt"},
+		Writes:   []string{"/srv/data/out.txt", "/opt/x/sub/cache/f"},
+		Collapse: processor.CollapseOptions{MinCount: 3},
+		Exists:   existsExcept(),
+	}
+	mounts, _, _ := Mounts(cfg)
+	binds := mountStrings(mounts[:len(mounts)-len(systemMounts)])
+	// /srv/data/in.txt is covered by the writable /srv/data bind; the
+	// collapsed read-only /opt/x precedes the writable bind beneath it.
+	want := []string{"--ro-bind /opt/x", "--bind /opt/x/sub/cache", "--bind /srv/data"}
+	if !reflect.DeepEqual(binds, want) {
+		t.Fatalf("binds mismatch:\n got %v\nwant %v", binds, want)
+	}
+}
+
+func mountStrings(mounts []Mount) []string {
+	var out []string
+	for _, m := range mounts {
+		out = append(out, m.Kind+" "+m.Path)
+	}
+	return out
+}
+
+func TestMountsNeverBindRoot(t *testing.T) {
+	cfg := Config{
+		Reads:  []string{"/", "/etc/hosts"},
+		Writes: []string{"/out", "/tmp/build/x.o"},
+		Exists: existsExcept(),
+	}
+	mounts, _, rootless := Mounts(cfg)
+	want := []string{
+		"--ro-bind /etc/hosts",
+		"--proc /proc",
+		"--dev /dev",
+		"--tmpfs /tmp",
+		"--bind /tmp/build",
+	}
+	if got := mountStrings(mounts); !reflect.DeepEqual(got, want) {
+		t.Fatalf("mounts mismatch:\n got %v\nwant %v", got, want)
+	}
+	if !reflect.DeepEqual(rootless, []string{"/out", "/"}) {
+		t.Fatalf("rootless = %v", rootless)
+	}
+	script, warnings := BuildScript(cfg)
+	if strings.Contains(script, "--bind / /") || strings.Contains(script, "--ro-bind / /") {
+		t.Fatalf("script binds the root:\n%s", script)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "not binding / for /out") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+
+}
+
+func TestBuildScript(t *testing.T) {
+	script, _ := BuildScript(Config{
+		Command:  []string{"make", "all targets"},
+		TracedAt: time.Date(2025, 11, 29, 10, 0, 0, 0, time.UTC),
+		Dir:      "/home/u/proj",
+		Reads:    []string{"/etc/hosts"},
+		Exists:   existsExcept(),
+	})
+	for _, want := range []string{
+		"#!/bin/sh\n",
+		"# command: make 'all targets'\n",
+		"# traced: 2025-11-29T10:00:00Z\n",
+		"exec bwrap \\\n",
+		"  --ro-bind /etc/hosts /etc/hosts \\\n",
+		"  --chdir /home/u/proj \\\n",
+		"  -- make 'all targets'\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Fatalf("script missing %q:\n%s", want, script)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package bwrap
+
+import "github.com/hokupod/fs-tracer/internal/sandbox"
+
+func init() { sandbox.Register(generator{}) }
+
+// generator emits a script running the traced command under bubblewrap.
+type generator struct{}
+
+func (generator) Name() string { return "bwrap" }
+
+func (generator) Generate(in sandbox.Input) (sandbox.Output, error) {
+	script, warnings := BuildScript(Config{
+		Command:  in.Command,
+		Launch:   in.Launch,
+		TracedAt: in.TracedAt,
+		Dir:      in.Dir,
+		Reads:    in.Reads,
+		Writes:   in.Writes,
+		Collapse: in.Rules.Collapse,
+	})
+	return sandbox.Output{Data: []byte(script), Warnings: warnings}, nil
+}
@@ -0,0 +1,19 @@
+package entitlements
+
+import "github.com/hokupod/fs-tracer/internal/sandbox"
+
+func init() { sandbox.Register(generator{}) }
+
+// generator emits an App Sandbox entitlements plist.
+type generator struct{}
+
+func (generator) Name() string { return "entitlements" }
+
+func (generator) Generate(in sandbox.Input) (sandbox.Output, error) {
+	return sandbox.Output{Data: []byte(BuildPlist(Config{
+		Reads:    in.Reads,
+		Writes:   in.Writes,
+		Home:     in.Home,
+		Collapse: in.Rules.Collapse,
+	}))}, nil
+}
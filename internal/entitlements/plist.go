@@ -0,0 +1,147 @@
+// Package entitlements turns traced read/write sets into an App Sandbox
+// entitlements plist with temporary file-access exceptions.
+package entitlements
+
+import (
+	"bytes"
+	"encoding/xml"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// Entitlement keys for file-access exceptions.
+const (
+	KeyAbsoluteReadOnly  = "com.apple.security.temporary-exception.files.absolute-path.read-only"
+	KeyAbsoluteReadWrite = "com.apple.security.temporary-exception.files.absolute-path.read-write"
+	KeyHomeReadOnly      = "com.apple.security.temporary-exception.files.home-relative-path.read-only"
+	KeyHomeReadWrite     = "com.apple.security.temporary-exception.files.home-relative-path.read-write"
+)
+
+// keyOrder fixes the order of exception arrays in the plist.
+var keyOrder = []string{KeyAbsoluteReadOnly, KeyAbsoluteReadWrite, KeyHomeReadOnly, KeyHomeReadWrite}
+
+// Config selects the traced paths and how they are rewritten.
+type Config struct {
+	Reads  []string
+	Writes []string
+	// Home is the user's home directory; paths beneath it become
+	// home-relative. Empty keeps every path absolute.
+	Home     string
+	Collapse processor.CollapseOptions
+}
+
+// Exceptions maps each entitlement key to its sorted path list. Collapsed
+// directories end in "/", which grants access to everything beneath them.
+// Reads inside a read-write entry are dropped, and paths under Home are
+// rewritten relative to it ("/Library/Caches/app/").
+func Exceptions(cfg Config) map[string][]string {
+	writes := entries(cfg.Writes, cfg.Collapse)
+	var reads []string
+	for _, e := range entries(cfg.Reads, cfg.Collapse) {
+		if !coveredBy(e, writes) {
+			reads = append(reads, e)
+		}
+	}
+	out := map[string][]string{}
+	place := func(list []string, absKey, homeKey string) {
+		for _, e := range list {
+			if rel, ok := homeRelative(e, cfg.Home); ok {
+				out[homeKey] = append(out[homeKey], rel)
+				continue
+			}
+			out[absKey] = append(out[absKey], e)
+		}
+	}
+	place(reads, KeyAbsoluteReadOnly, KeyHomeReadOnly)
+	place(writes, KeyAbsoluteReadWrite, KeyHomeReadWrite)
+	for _, list := range out {
+		sort.Strings(list)
+	}
+	return out
+}
+
+// BuildPlist renders an entitlements plist enabling the App Sandbox with the
+// file-access exceptions from the trace.
+func BuildPlist(cfg Config) string {
+	exceptions := Exceptions(cfg)
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString("<!-- Generated by fs-tracer -->\n")
+	buf.WriteString(`<plist version="1.0">` + "\n<dict>\n")
+	buf.WriteString("\t<key>com.apple.security.app-sandbox</key>\n\t<true/>\n")
+	for _, key := range keyOrder {
+		list := exceptions[key]
+		if len(list) == 0 {
+			continue
+		}
+		writeElement(&buf, "\t", "key", key)
+		buf.WriteString("\t<array>\n")
+		for _, p := range list {
+			writeElement(&buf, "\t\t", "string", p)
+		}
+		buf.WriteString("\t</array>\n")
+	}
+	buf.WriteString("</dict>\n</plist>\n")
+	return buf.String()
+}
+
+// entries collapses paths; collapsed directories carry a trailing slash. An
+// entry without one grants only that item, so only paths beneath a collapsed
+// directory are dropped.
+func entries(paths []string, opts processor.CollapseOptions) []string {
+	literals, groups := processor.CollapseDirs(paths, opts)
+	groupDirs := processor.MinimalRoots(dirs(groups))
+	var out []string
+	for _, p := range literals {
+		if !processor.WithinAny(p, groupDirs) {
+			out = append(out, p)
+		}
+	}
+	for _, d := range groupDirs {
+		out = append(out, d+"/")
+	}
+	sort.Strings(out)
+	return out
+}
+
+func dirs(groups []processor.Group) []string {
+	out := make([]string, 0, len(groups))
+	for _, g := range groups {
+		out = append(out, g.Dir)
+	}
+	return out
+}
+
+// coveredBy reports whether entry lies inside a directory entry of list, or
+// equals any entry.
+func coveredBy(entry string, list []string) bool {
+	for _, e := range list {
+		if e == entry || (strings.HasSuffix(e, "/") && processor.Within(strings.TrimSuffix(entry, "/"), strings.TrimSuffix(e, "/"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// homeRelative rewrites entries strictly beneath home as "/rest".
+func homeRelative(entry, home string) (string, bool) {
+	if home == "" {
+		return "", false
+	}
+	home = filepath.Clean(home)
+	if !strings.HasPrefix(entry, home+"/") || len(entry) == len(home)+1 {
+		return "", false
+	}
+	return entry[len(home):], true
+}
+
+func writeElement(buf *bytes.Buffer, indent, name, text string) {
+	buf.WriteString(indent)
+	buf.WriteString("<" + name + ">")
+	_ = xml.EscapeText(buf, []byte(text))
+	buf.WriteString("</" + name + ">\n")
+}
@@ -0,0 +1,79 @@
+package entitlements
+
+import (
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func TestExceptions(t *testing.T) {
+	got := Exceptions(Config{
+		Reads:  []string{"/etc/hosts", "/Users/u/.gitconfig", "/Users/u/Library/Caches/app/db", "/usr/share/a/1", "/usr/share/a/2"},
+		Writes: []string{"/Users/u/Library/Caches/app/db", "/Users/u/Library/Caches/app/tmp", "/private/tmp/out"},
+		Home:   "/Users/u",
+		Collapse: processor.CollapseOptions{
+			MinCount: 2,
+		},
+	})
+	want := map[string][]string{
+		KeyAbsoluteReadOnly:  {"/etc/hosts", "/usr/share/a/"},
+		KeyAbsoluteReadWrite: {"/private/tmp/out"},
+		KeyHomeReadOnly:      {"/.gitconfig"},
+		KeyHomeReadWrite:     {"/Library/Caches/app/"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("exceptions mismatch:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestExceptionsKeepNestedLiterals(t *testing.T) {
+	// A literal entry grants only that item, so /etc does not cover
+	// /etc/hosts; only a collapsed "dir/" entry covers what lies beneath it.
+	got := Exceptions(Config{
+		Reads: []string{"/etc", "/etc/hosts", "/Users/a/Library/Prefs", "/Users/a/Library/Prefs/x.plist"},
+		Home:  "/Users/a",
+	})
+	want := map[string][]string{
+		KeyAbsoluteReadOnly: {"/etc", "/etc/hosts"},
+		KeyHomeReadOnly:     {"/Library/Prefs", "/Library/Prefs/x.plist"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("exceptions mismatch:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestExceptionsWithoutHome(t *testing.T) {
+	got := Exceptions(Config{Reads: []string{"/Users/u/a"}})
+	if !reflect.DeepEqual(got, map[string][]string{KeyAbsoluteReadOnly: {"/Users/u/a"}}) {
+		t.Fatalf("unexpected exceptions: %v", got)
+	}
+}
+
+func TestBuildPlist(t *testing.T) {
+	out := BuildPlist(Config{Reads: []string{"/etc/a&b"}, Writes: []string{"/Users/u/out"}, Home: "/Users/u"})
+	for _, want := range []string{
+		"<key>com.apple.security.app-sandbox</key>\n\t<true/>\n",
+		"\t<key>" + KeyAbsoluteReadOnly + "</key>\n\t<array>\n\t\t<string>/etc/a&amp;b</string>\n\t</array>\n",
+		"\t<key>" + KeyHomeReadWrite + "</key>\n\t<array>\n\t\t<string>/out</string>\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("plist missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, KeyHomeReadOnly) {
+		t.Fatalf("empty arrays must be omitted:\n%s", out)
+	}
+	dec := xml.NewDecoder(strings.NewReader(out))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if err == io.EOF {
+				break
+			}
+			t.Fatalf("plist is not well-formed XML: %v", err)
+		}
+	}
+}
@@ -15,6 +15,11 @@ type FsUsageRunner interface {
 }
 
 // SudoFsUsageRunner runs fs_usage via sudo (default) or directly (--no-sudo).
+//
+// fs_usage runs in its own process group, where reading the terminal stops
+// it (SIGTTIN) or fails (EIO), so sudo cannot prompt from there. Run
+// therefore validates the sudo credentials in the foreground first and then
+// starts fs_usage with sudo -n, which fails instead of prompting.
 type SudoFsUsageRunner struct {
 	NoSudo bool
 	All    bool
@@ -26,12 +31,17 @@ func (r SudoFsUsageRunner) Run(pid int, comm string) (io.ReadCloser, error) {
 	if !r.All && pid > 0 {
 		cmdArgs = append(cmdArgs, fmt.Sprintf("%d", pid))
 	}
-	if !r.NoSudo {
-		cmdArgs = append([]string{"sudo"}, cmdArgs...)
+	if !r.NoSudo && os.Geteuid() != 0 {
+		if err := validateSudo(); err != nil {
+			return nil, fmt.Errorf("sudo: %w", err)
+		}
+		cmdArgs = append([]string{"sudo", "-n"}, cmdArgs...)
 	}
 	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-	// Allow sudo to prompt for password when needed.
-	cmd.Stdin = os.Stdin
+	// Keep fs_usage out of the terminal's process group so Ctrl-C reaches
+	// yourcmd (via fs-tracer) while the trace is still being read; Close
+	// stops it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -46,6 +56,16 @@ func (r SudoFsUsageRunner) Run(pid int, comm string) (io.ReadCloser, error) {
 	return &cmdReadCloser{rc: stdout, cmd: cmd}, nil
 }
 
+// validateSudo runs sudo -v in the foreground, where it may prompt for a
+// password, so the following sudo -n finds cached credentials.
+func validateSudo() error {
+	cmd := exec.Command("sudo", "-v")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
 type cmdReadCloser struct {
 	rc  io.ReadCloser
 	cmd *exec.Cmd
@@ -0,0 +1,19 @@
+package landlock
+
+import "github.com/hokupod/fs-tracer/internal/sandbox"
+
+func init() { sandbox.Register(generator{}) }
+
+// generator emits the JSON policy read by fs-tracer enforce.
+type generator struct{}
+
+func (generator) Name() string { return "landlock" }
+
+func (generator) Generate(in sandbox.Input) (sandbox.Output, error) {
+	policy, warnings := BuildPolicy(in.Accesses, in.Rules.Collapse)
+	b, err := policy.Marshal()
+	if err != nil {
+		return sandbox.Output{}, err
+	}
+	return sandbox.Output{Data: append(b, '\n'), Warnings: warnings}, nil
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package landlock
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Syscall numbers are shared by all architectures that define them.
+const (
+	sysCreateRuleset = 444
+	sysAddRule       = 445
+	sysRestrictSelf  = 446
+
+	createRulesetVersion = 1 << 0
+	rulePathBeneath      = 1
+
+	prSetNoNewPrivs = 38
+	oPath           = 0x200000
+)
+
+type rulesetAttr struct {
+	handledAccessFS uint64
+}
+
+// pathBeneathAttr mirrors the packed struct landlock_path_beneath_attr; the
+// kernel reads only the first 12 bytes.
+type pathBeneathAttr struct {
+	allowedAccess uint64
+	parentFd      int32
+	_             [4]byte
+}
+
+// ABI returns the Landlock ABI version supported by the running kernel.
+func ABI() (int, error) {
+	v, _, errno := syscall.RawSyscall(sysCreateRuleset, 0, 0, createRulesetVersion)
+	if errno != 0 {
+		return 0, fmt.Errorf("landlock unavailable: %w", errno)
+	}
+	return int(v), nil
+}
+
+// Restrict confines the calling thread to p. Rights on non-directories are
+// narrowed to those the kernel accepts for files. Paths that do not exist are
+// skipped and returned. The domain only covers the calling thread and what it
+// executes, so callers lock the OS thread and exec from it.
+func Restrict(p Policy) (skipped []string, err error) {
+	abi, err := ABI()
+	if err != nil {
+		return nil, err
+	}
+	handled := HandledAccess(abi)
+	attr := rulesetAttr{handledAccessFS: uint64(handled)}
+	fd, _, errno := syscall.RawSyscall(sysCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("landlock_create_ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	for _, r := range p.Rules {
+		access, err := ParseAccess(r.Access)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := addRule(int(fd), r.Path, access&handled)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			skipped = append(skipped, r.Path)
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return nil, fmt.Errorf("prctl(PR_SET_NO_NEW_PRIVS): %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysRestrictSelf, fd, 0, 0); errno != 0 {
+		return nil, fmt.Errorf("landlock_restrict_self: %w", errno)
+	}
+	return skipped, nil
+}
+
+// addRule grants access beneath path. It reports false when path is missing.
+func addRule(rulesetFd int, path string, access Access) (bool, error) {
+	pathFd, err := syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			return false, nil
+		}
+		return false, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer syscall.Close(pathFd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(pathFd, &st); err != nil {
+		return false, fmt.Errorf("stat %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= fileAccess
+	}
+	if access == 0 {
+		return true, nil
+	}
+	attr := pathBeneathAttr{allowedAccess: uint64(access), parentFd: int32(pathFd)}
+	if _, _, errno := syscall.RawSyscall6(sysAddRule, uintptr(rulesetFd), rulePathBeneath, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return false, fmt.Errorf("landlock_add_rule %s: %w", path, errno)
+	}
+	return true, nil
+}
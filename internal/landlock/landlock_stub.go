@@ -0,0 +1,13 @@
+//go:build !linux
+
+package landlock
+
+// ABI always fails outside Linux.
+func ABI() (int, error) {
+	return 0, ErrUnsupported
+}
+
+// Restrict always fails outside Linux.
+func Restrict(Policy) ([]string, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,246 @@
+// Package landlock generates Landlock rulesets from traced read/write sets and
+// applies them to the current process on Linux.
+package landlock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// ErrUnsupported is returned by Restrict on platforms without Landlock.
+var ErrUnsupported = errors.New("landlock is only available on Linux")
+
+// Access is a Landlock filesystem right (LANDLOCK_ACCESS_FS_*).
+type Access uint64
+
+const (
+	AccessExecute Access = 1 << iota
+	AccessWriteFile
+	AccessReadFile
+	AccessReadDir
+	AccessRemoveDir
+	AccessRemoveFile
+	AccessMakeChar
+	AccessMakeDir
+	AccessMakeReg
+	AccessMakeSock
+	AccessMakeFifo
+	AccessMakeBlock
+	AccessMakeSym
+	AccessRefer    // ABI 2
+	AccessTruncate // ABI 3
+	AccessIoctlDev // ABI 5
+)
+
+// accessNames lists rights in bit order; the names appear in policy files.
+var accessNames = []struct {
+	name   string
+	access Access
+}{
+	{"execute", AccessExecute},
+	{"write_file", AccessWriteFile},
+	{"read_file", AccessReadFile},
+	{"read_dir", AccessReadDir},
+	{"remove_dir", AccessRemoveDir},
+	{"remove_file", AccessRemoveFile},
+	{"make_char", AccessMakeChar},
+	{"make_dir", AccessMakeDir},
+	{"make_reg", AccessMakeReg},
+	{"make_sock", AccessMakeSock},
+	{"make_fifo", AccessMakeFifo},
+	{"make_block", AccessMakeBlock},
+	{"make_sym", AccessMakeSym},
+	{"refer", AccessRefer},
+	{"truncate", AccessTruncate},
+	{"ioctl_dev", AccessIoctlDev},
+}
+
+const (
+	// fileAccess holds the only rights the kernel accepts on a non-directory.
+	fileAccess = AccessExecute | AccessWriteFile | AccessReadFile | AccessTruncate | AccessIoctlDev
+
+	readAccess = AccessReadFile | AccessReadDir | AccessExecute
+	// writeAccess is granted on written paths themselves.
+	writeAccess = readAccess | AccessWriteFile | AccessTruncate
+	// parentAccess is granted on the directory of a written path so files can
+	// be created, replaced by rename, and removed there.
+	parentAccess = AccessMakeReg | AccessMakeDir | AccessMakeSym | AccessRemoveFile | AccessRemoveDir | AccessRefer
+)
+
+// HandledAccess returns the rights a ruleset handles for a kernel ABI version.
+// Rights the ABI does not know about are left unrestricted.
+func HandledAccess(abi int) Access {
+	var a Access = AccessMakeSym<<1 - 1
+	if abi >= 2 {
+		a |= AccessRefer
+	}
+	if abi >= 3 {
+		a |= AccessTruncate
+	}
+	if abi >= 5 {
+		a |= AccessIoctlDev
+	}
+	return a
+}
+
+// Names returns the policy names of the rights in a, in bit order.
+func (a Access) Names() []string {
+	var out []string
+	for _, n := range accessNames {
+		if a&n.access != 0 {
+			out = append(out, n.name)
+		}
+	}
+	return out
+}
+
+// ParseAccess converts policy names back into rights.
+func ParseAccess(names []string) (Access, error) {
+	var a Access
+	for _, name := range names {
+		found := false
+		for _, n := range accessNames {
+			if n.name == name {
+				a |= n.access
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown landlock access %q", name)
+		}
+	}
+	return a, nil
+}
+
+// PolicyVersion is the policy file format version.
+const PolicyVersion = 1
+
+// Policy is a serializable Landlock ruleset: each rule grants rights beneath
+// a path (or on the file itself). Everything else handled by the kernel ABI
+// is denied once the policy is enforced.
+type Policy struct {
+	Version int    `json:"version"`
+	Rules   []Rule `json:"rules"`
+}
+
+// Rule grants Access on Path and, for directories, everything below it.
+type Rule struct {
+	Path   string   `json:"path"`
+	Access []string `json:"access"`
+}
+
+// BuildPolicy turns traced accesses into a policy. Read paths get read and
+// execute rights, listed directories only read_dir, written paths
+// additionally get write and truncate rights, and the parent directory of
+// each created or removed path may create and remove entries. Paths only
+// stat'ed or touched through a descriptor need no rule and are skipped.
+// Paths are folded into their directories according to collapse, which suits
+// Landlock's hierarchical rules. No rule is ever placed on "/", since it would
+// cover the whole filesystem; the skipped grants are returned as warnings.
+func BuildPolicy(accesses []processor.Access, collapse processor.CollapseOptions) (Policy, []string) {
+	var warnings, parents []string
+	byAccess := map[Access][]string{}
+	for _, acc := range accesses {
+		a, parent := needs(acc)
+		if a == 0 && !parent {
+			continue
+		}
+		if acc.Path == "/" {
+			warnings = append(warnings, "landlock: no rule for /: it would cover the whole filesystem")
+			continue
+		}
+		if a != 0 {
+			byAccess[a] = append(byAccess[a], acc.Path)
+		}
+		if parent {
+			parents = append(parents, acc.Path)
+		}
+	}
+
+	rights := map[string]Access{}
+	for a, paths := range byAccess {
+		literals, groups := processor.CollapseDirs(paths, collapse)
+		for _, p := range literals {
+			rights[p] |= a
+		}
+		for _, g := range groups {
+			rights[g.Dir] |= a | AccessReadDir
+		}
+	}
+	for _, p := range parents {
+		if dir := filepath.Dir(p); dir == "/" {
+			warnings = append(warnings, fmt.Sprintf("landlock: %s cannot be created or removed: that needs a rule on /, which would cover the whole filesystem", p))
+		} else {
+			rights[dir] |= parentAccess
+		}
+	}
+
+	paths := make([]string, 0, len(rights))
+	for p := range rights {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	policy := Policy{Version: PolicyVersion, Rules: []Rule{}}
+	for _, p := range paths {
+		policy.Rules = append(policy.Rules, Rule{Path: p, Access: rights[p].Names()})
+	}
+	return policy, warnings
+}
+
+// needs returns the rights an access needs on its path, and whether its
+// parent directory must allow creating and removing entries. Metadata reads
+// are allowed by Landlock without a rule, and descriptor ops check no path.
+func needs(acc processor.Access) (a Access, parent bool) {
+	for _, c := range acc.Categories {
+		switch {
+		case c == ops.Create:
+			a |= writeAccess
+			parent = true
+		case c == ops.Delete:
+			parent = true
+		case c.IsWrite():
+			a |= writeAccess
+		case c == ops.DirectoryList:
+			a |= AccessReadDir
+		case c == ops.MetadataRead, !c.ChecksPath():
+		default:
+			a |= readAccess
+		}
+	}
+	return a, parent
+}
+
+// Marshal renders the policy as indented JSON.
+func (p Policy) Marshal() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// LoadPolicy reads and validates a policy file.
+func LoadPolicy(r io.Reader) (Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Policy{}, fmt.Errorf("parse landlock policy: %w", err)
+	}
+	if p.Version != PolicyVersion {
+		return Policy{}, fmt.Errorf("unsupported landlock policy version %d (want %d)", p.Version, PolicyVersion)
+	}
+	for _, r := range p.Rules {
+		if !filepath.IsAbs(r.Path) {
+			return Policy{}, fmt.Errorf("landlock rule path must be absolute: %q", r.Path)
+		}
+		if _, err := ParseAccess(r.Access); err != nil {
+			return Policy{}, err
+		}
+	}
+	return p, nil
+}
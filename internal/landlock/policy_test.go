@@ -0,0 +1,147 @@
+package landlock
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func access(path string, cats ...ops.Category) processor.Access {
+	return processor.Access{Path: path, Categories: cats}
+}
+
+func reads(paths ...string) []processor.Access {
+	var out []processor.Access
+	for _, p := range paths {
+		out = append(out, access(p, ops.DataRead))
+	}
+	return out
+}
+
+func rulesByPath(p Policy) map[string][]string {
+	got := map[string][]string{}
+	for _, r := range p.Rules {
+		got[r.Path] = r.Access
+	}
+	return got
+}
+
+func TestBuildPolicy(t *testing.T) {
+	p, warnings := BuildPolicy([]processor.Access{
+		access("/etc/hosts", ops.DataRead, ops.MetadataRead),
+		access("/tmp/out/log.txt", ops.Create, ops.DataWrite),
+		access("/usr/bin/tool", ops.Exec),
+	}, processor.CollapseOptions{})
+	got := rulesByPath(p)
+	want := map[string][]string{
+		"/etc/hosts":       {"execute", "read_file", "read_dir"},
+		"/usr/bin/tool":    {"execute", "read_file", "read_dir"},
+		"/tmp/out/log.txt": {"execute", "write_file", "read_file", "read_dir", "truncate"},
+		"/tmp/out":         {"remove_dir", "remove_file", "make_dir", "make_reg", "make_sym", "refer"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("policy mismatch:\n got %v\nwant %v", got, want)
+	}
+	if p.Version != PolicyVersion || p.Rules[0].Path != "/etc/hosts" {
+		t.Fatalf("policy not versioned/sorted: %+v", p)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings = %q", warnings)
+	}
+}
+
+func TestBuildPolicySkipsMetadataAndRoot(t *testing.T) {
+	p, warnings := BuildPolicy([]processor.Access{
+		access("/", ops.MetadataRead, ops.DirectoryList),
+		access("/etc", ops.MetadataRead),
+		access("/dev/tty", ops.Descriptor),
+		access("/out", ops.Create, ops.DataWrite),
+	}, processor.CollapseOptions{})
+	want := map[string][]string{
+		"/out": {"execute", "write_file", "read_file", "read_dir", "truncate"},
+	}
+	if got := rulesByPath(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("policy mismatch:\n got %v\nwant %v", got, want)
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "no rule for /") || !strings.Contains(warnings[1], "/out cannot be created") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+}
+
+func TestBuildPolicyParentRightsOnlyForCreateAndDelete(t *testing.T) {
+	p, _ := BuildPolicy([]processor.Access{
+		access("/home/me/.zsh_history", ops.DataWrite),
+		access("/work/old.o", ops.Delete),
+	}, processor.CollapseOptions{})
+	want := map[string][]string{
+		"/home/me/.zsh_history": {"execute", "write_file", "read_file", "read_dir", "truncate"},
+		"/work":                 {"remove_dir", "remove_file", "make_dir", "make_reg", "make_sym", "refer"},
+	}
+	if got := rulesByPath(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("policy mismatch:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestBuildPolicyListingOnly(t *testing.T) {
+	p, _ := BuildPolicy([]processor.Access{
+		access("/usr", ops.DirectoryList, ops.MetadataRead),
+		access("/usr/bin/tool", ops.Exec),
+	}, processor.CollapseOptions{})
+	want := map[string][]string{
+		"/usr":          {"read_dir"},
+		"/usr/bin/tool": {"execute", "read_file", "read_dir"},
+	}
+	if got := rulesByPath(p); !reflect.DeepEqual(got, want) {
+		t.Fatalf("policy mismatch:\n got %v\nwant %v", got, want)
+	}
+}
+
+func TestBuildPolicyCollapse(t *testing.T) {
+	p, _ := BuildPolicy(reads("/lib/a.so", "/lib/b.so", "/lib/c.so"), processor.CollapseOptions{MinCount: 3})
+	if len(p.Rules) != 1 || p.Rules[0].Path != "/lib" {
+		t.Fatalf("expected collapsed /lib rule, got %+v", p.Rules)
+	}
+}
+
+func TestLoadPolicyRoundTrip(t *testing.T) {
+	p, _ := BuildPolicy(reads("/etc/hosts"), processor.CollapseOptions{})
+	b, err := p.Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	loaded, err := LoadPolicy(strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(loaded, p) {
+		t.Fatalf("round trip mismatch: %+v", loaded)
+	}
+}
+
+func TestLoadPolicyErrors(t *testing.T) {
+	for _, src := range []string{
+		`{"version":2,"rules":[]}`,
+		`{"version":1,"rules":[{"path":"rel","access":["read_file"]}]}`,
+		`{"version":1,"rules":[{"path":"/a","access":["fly"]}]}`,
+		`{"version":1,"extra":true}`,
+	} {
+		if _, err := LoadPolicy(strings.NewReader(src)); err == nil {
+			t.Fatalf("expected error for %s", src)
+		}
+	}
+}
+
+func TestHandledAccess(t *testing.T) {
+	if HandledAccess(1)&(AccessRefer|AccessTruncate) != 0 {
+		t.Fatal("ABI 1 must not handle refer or truncate")
+	}
+	if HandledAccess(3)&AccessTruncate == 0 || HandledAccess(3)&AccessIoctlDev != 0 {
+		t.Fatal("ABI 3 handles truncate but not ioctl_dev")
+	}
+	if HandledAccess(5)&AccessIoctlDev == 0 {
+		t.Fatal("ABI 5 handles ioctl_dev")
+	}
+}
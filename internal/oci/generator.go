@@ -0,0 +1,38 @@
+package oci
+
+import "github.com/hokupod/fs-tracer/internal/sandbox"
+
+func init() {
+	sandbox.Register(generator{name: "oci"})
+	sandbox.Register(generator{name: "docker", docker: true})
+}
+
+// generator emits the bind mounts as a config.json fragment or, for docker,
+// as docker run arguments.
+type generator struct {
+	name   string
+	docker bool
+}
+
+func (g generator) Name() string { return g.name }
+
+func (g generator) Generate(in sandbox.Input) (sandbox.Output, error) {
+	mounts, missing, rootless := Mounts(Config{Reads: in.Reads, Writes: in.Writes, Collapse: in.Rules.Collapse})
+	var out sandbox.Output
+	for _, p := range missing {
+		out.Warnings = append(out.Warnings, "skipped missing path: "+p)
+	}
+	for _, p := range rootless {
+		out.Warnings = append(out.Warnings, "not binding / for "+p+": it would expose the whole host")
+	}
+	if g.docker {
+		out.Data = []byte(DockerArgs(mounts))
+		return out, nil
+	}
+	b, err := MountsJSON(mounts)
+	if err != nil {
+		return sandbox.Output{}, err
+	}
+	out.Data = append(b, '\n')
+	return out, nil
+}
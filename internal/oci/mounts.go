@@ -0,0 +1,153 @@
+// Package oci turns traced read/write sets into container bind mounts: an OCI
+// runtime config.json "mounts" fragment or docker run arguments.
+package oci
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// runtimeRoots are provided by the container runtime and never bound.
+var runtimeRoots = []string{"/proc", "/sys", "/dev"}
+
+// Config selects the traced paths to mount.
+type Config struct {
+	Reads    []string
+	Writes   []string
+	Collapse processor.CollapseOptions
+	// Exists reports whether a path is present on the host; defaults to os.Lstat.
+	Exists func(string) bool
+}
+
+// Mount is a bind mount of Path onto the same path in the container.
+type Mount struct {
+	Path     string
+	ReadOnly bool
+}
+
+// Mounts computes the bind mounts: read-only for read paths, read-write for
+// the directories that received writes (so files there can be created and
+// replaced). Paths sharing a parent are grouped into it only per cfg.Collapse.
+// Nested mounts already covered are dropped, and parents precede children.
+// Paths missing on the host are skipped and returned, because runtimes would
+// create them as empty directories. "/" is never bound, as that would expose
+// the whole host; the paths that needed it are returned in rootless.
+func Mounts(cfg Config) (mounts []Mount, missing, rootless []string) {
+	exists := cfg.Exists
+	if exists == nil {
+		exists = func(p string) bool {
+			_, err := os.Lstat(p)
+			return err == nil
+		}
+	}
+	present := func(paths []string) []string {
+		var out []string
+		for _, p := range paths {
+			switch {
+			case processor.WithinAny(p, runtimeRoots):
+			case !exists(p):
+				missing = append(missing, p)
+			default:
+				out = append(out, p)
+			}
+		}
+		return out
+	}
+
+	var writeDirs, reads []string
+	for _, p := range cfg.Writes {
+		if dir := filepath.Dir(p); dir == "/" {
+			rootless = append(rootless, p)
+		} else {
+			writeDirs = append(writeDirs, dir)
+		}
+	}
+	for _, p := range cfg.Reads {
+		if p == "/" {
+			rootless = append(rootless, p)
+		} else {
+			reads = append(reads, p)
+		}
+	}
+	writeRoots := processor.CollapsedRoots(present(writeDirs), cfg.Collapse)
+	for _, p := range writeRoots {
+		mounts = append(mounts, Mount{Path: p})
+	}
+	for _, p := range processor.CollapsedRoots(present(reads), cfg.Collapse) {
+		if !processor.WithinAny(p, writeRoots) {
+			mounts = append(mounts, Mount{Path: p, ReadOnly: true})
+		}
+	}
+	// Runtimes mount in order, so parents must precede their children.
+	sort.SliceStable(mounts, func(i, j int) bool { return processor.PathLess(mounts[i].Path, mounts[j].Path) })
+	return mounts, processor.MinimalRoots(missing), rootless
+}
+
+// specMount is an entry of the OCI runtime spec "mounts" array.
+type specMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options"`
+}
+
+// MountsJSON renders the mounts as a config.json fragment: {"mounts": [...]}.
+func MountsJSON(mounts []Mount) ([]byte, error) {
+	entries := make([]specMount, 0, len(mounts))
+	for _, m := range mounts {
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		entries = append(entries, specMount{
+			Destination: m.Path,
+			Type:        "bind",
+			Source:      m.Path,
+			Options:     []string{"rbind", mode},
+		})
+	}
+	return json.MarshalIndent(map[string][]specMount{"mounts": entries}, "", "  ")
+}
+
+// DockerArgs renders docker run arguments, one mount per line. Paths that
+// cannot be expressed with -v (they contain ':' or ',') use --mount instead.
+func DockerArgs(mounts []Mount) string {
+	var b strings.Builder
+	for i, m := range mounts {
+		var args []string
+		switch {
+		case !strings.ContainsAny(m.Path, ":,"):
+			spec := m.Path + ":" + m.Path
+			if m.ReadOnly {
+				spec += ":ro"
+			}
+			args = []string{"-v", spec}
+		default:
+			spec := "type=bind," + csvField("source="+m.Path) + "," + csvField("target="+m.Path)
+			if m.ReadOnly {
+				spec += ",readonly"
+			}
+			args = []string{"--mount", spec}
+		}
+		b.WriteString(output.ShellJoin(args))
+		if i < len(mounts)-1 {
+			b.WriteString(" \\")
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// csvField quotes a --mount field the way docker's CSV parser expects.
+func csvField(s string) string {
+	if !strings.ContainsAny(s, ",\"") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
@@ -0,0 +1,119 @@
+package oci
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+	"github.com/hokupod/fs-tracer/internal/sandbox"
+)
+
+func existsExcept(missing ...string) func(string) bool {
+	return func(p string) bool {
+		for _, m := range missing {
+			if p == m {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestMounts(t *testing.T) {
+	mounts, missing, rootless := Mounts(Config{
+		Reads:  []string{"/etc/hosts", "/usr/lib/x/a.so", "/usr/lib/x/b.so", "/proc/self/maps", "/work/src/in.txt", "/gone"},
+		Writes: []string{"/work/out/a.o", "/work/src/in.txt.tmp"},
+		Exists: existsExcept("/gone"),
+	})
+	want := []Mount{
+		{Path: "/etc/hosts", ReadOnly: true},
+		{Path: "/usr/lib/x/a.so", ReadOnly: true},
+		{Path: "/usr/lib/x/b.so", ReadOnly: true},
+		{Path: "/work/out"},
+		{Path: "/work/src"},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Fatalf("mounts mismatch:\n got %+v\nwant %+v", mounts, want)
+	}
+	if !reflect.DeepEqual(missing, []string{"/gone"}) || len(rootless) != 0 {
+		t.Fatalf("missing = %v, rootless = %v", missing, rootless)
+	}
+}
+
+func TestMountsNeverBindRoot(t *testing.T) {
+	mounts, _, rootless := Mounts(Config{
+		Reads:  []string{"/", "/etc/hosts"},
+		Writes: []string{"/out", "/work/out/a.o"},
+		Exists: existsExcept(),
+	})
+	want := []Mount{{Path: "/etc/hosts", ReadOnly: true}, {Path: "/work/out"}}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Fatalf("mounts mismatch: %+v", mounts)
+	}
+	if !reflect.DeepEqual(rootless, []string{"/out", "/"}) {
+		t.Fatalf("rootless = %v", rootless)
+	}
+}
+
+func TestMountsParentFirst(t *testing.T) {
+	mounts, _, _ := Mounts(Config{
+		Reads:    []string{"/opt/tool/a", "/opt/tool/b", "/opt/tool/c"},
+		Writes:   []string{"/opt/tool/cache/x"},
+		Collapse: processor.CollapseOptions{MinCount: 3},
+		Exists:   existsExcept(),
+	})
+	want := []Mount{{Path: "/opt/tool", ReadOnly: true}, {Path: "/opt/tool/cache"}}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Fatalf("mounts mismatch: %+v", mounts)
+	}
+}
+
+func TestMountsJSON(t *testing.T) {
+	b, err := MountsJSON([]Mount{{Path: "/etc/hosts", ReadOnly: true}, {Path: "/work"}})
+	if err != nil {
+		t.Fatalf("MountsJSON: %v", err)
+	}
+	var doc struct {
+		Mounts []struct {
+			Destination string   `json:"destination"`
+			Type        string   `json:"type"`
+			Source      string   `json:"source"`
+			Options     []string `json:"options"`
+		} `json:"mounts"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("json parse: %v", err)
+	}
+	if len(doc.Mounts) != 2 || doc.Mounts[0].Type != "bind" || !reflect.DeepEqual(doc.Mounts[0].Options, []string{"rbind", "ro"}) || !reflect.DeepEqual(doc.Mounts[1].Options, []string{"rbind", "rw"}) {
+		t.Fatalf("unexpected mounts: %+v", doc.Mounts)
+	}
+}
+
+func TestDockerArgs(t *testing.T) {
+	got := DockerArgs([]Mount{{Path: "/etc/hosts", ReadOnly: true}, {Path: "/data/a:b,c"}, {Path: "/my work"}})
+	want := strings.Join([]string{
+		"-v /etc/hosts:/etc/hosts:ro \\",
+		`--mount 'type=bind,"source=/data/a:b,c","target=/data/a:b,c"' \`,
+		"-v '/my work:/my work'",
+		"",
+	}, "\n")
+	if got != want {
+		t.Fatalf("docker args mismatch:\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGeneratorWarnsMissing(t *testing.T) {
+	g, ok := sandbox.Lookup("docker")
+	if !ok {
+		t.Fatal("docker format not registered")
+	}
+	out, err := g.Generate(sandbox.Input{Writes: []string{"/nonexistent-fs-tracer/out"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Data) != 0 || !reflect.DeepEqual(out.Warnings, []string{"skipped missing path: /nonexistent-fs-tracer"}) {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+}
@@ -0,0 +1,273 @@
+package ops
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Category groups syscall names by the kind of filesystem access they perform.
+type Category string
+
+const (
+	DataRead      Category = "data-read"
+	MetadataRead  Category = "metadata-read"
+	DataWrite     Category = "data-write"
+	MetadataWrite Category = "metadata-write"
+	Create        Category = "create"
+	Delete        Category = "delete"
+	Exec          Category = "exec"
+	DirectoryList Category = "directory-list"
+	XattrRead     Category = "xattr-read"
+	XattrWrite    Category = "xattr-write"
+	Ioctl         Category = "ioctl"
+	// Descriptor covers ops on an already open file descriptor. The kernel
+	// checks no path access for them, so sandbox rules and audits skip it.
+	Descriptor Category = "descriptor"
+	// Unknown is returned for ops missing from the catalogue whose name does
+	// not mention a write. Callers treat it as a read.
+	Unknown Category = "unknown"
+)
+
+// Categories lists every known category in a stable order.
+func Categories() []Category {
+	return []Category{
+		DataRead, MetadataRead, DataWrite, MetadataWrite, Create, Delete,
+		Exec, DirectoryList, XattrRead, XattrWrite, Ioctl, Descriptor,
+	}
+}
+
+// IsWrite reports whether the category modifies the filesystem.
+func (c Category) IsWrite() bool {
+	switch c {
+	case DataWrite, MetadataWrite, Create, Delete, XattrWrite:
+		return true
+	default:
+		return false
+	}
+}
+
+// ChecksPath reports whether the kernel checks access to the path for the
+// category, i.e. whether a sandbox rule can allow or deny it.
+func (c Category) ChecksPath() bool {
+	return c != Descriptor
+}
+
+// ParseCategory validates a category name given on the command line.
+func ParseCategory(s string) (Category, error) {
+	c := Category(strings.ToLower(strings.TrimSpace(s)))
+	for _, known := range Categories() {
+		if c == known {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unknown op category %q (want one of %s)", s, strings.Join(categoryNames(), ", "))
+}
+
+// catalogue maps normalized fs_usage and strace op names to categories.
+var catalogue = map[string]Category{
+	// fs_usage data transfer and descriptor-level ops.
+	"rddata":                     DataRead,
+	"pgin":                       DataRead,
+	"read":                       DataRead,
+	"pread":                      DataRead,
+	"readv":                      DataRead,
+	"preadv":                     DataRead,
+	"open":                       DataRead,
+	"openat":                     DataRead,
+	"open_dprotected_np":         DataRead,
+	"openat_dprotected_np":       DataRead,
+	"openbyid_np":                DataRead,
+	"guarded_open_np":            DataRead,
+	"guarded_open_dprotected_np": DataRead,
+	"mmap":                       DataRead,
+	"sendfile":                   DataRead,
+	"close":                      Descriptor,
+	"fcntl":                      Descriptor,
+	"lseek":                      Descriptor,
+	"flock":                      Descriptor,
+	"dup":                        Descriptor,
+	"guarded_close_np":           Descriptor,
+
+	// Metadata reads.
+	"rdmeta":        MetadataRead,
+	"stat":          MetadataRead,
+	"lstat":         MetadataRead,
+	"fstat":         MetadataRead,
+	"fstatat":       MetadataRead,
+	"newfstatat":    MetadataRead,
+	"statx":         MetadataRead,
+	"statfs":        MetadataRead,
+	"fstatfs":       MetadataRead,
+	"getfsstat":     MetadataRead,
+	"getattrlist":   MetadataRead,
+	"fgetattrlist":  MetadataRead,
+	"getattrlistat": MetadataRead,
+	"access":        MetadataRead,
+	"faccessat":     MetadataRead,
+	"faccessat2":    MetadataRead,
+	"readlink":      MetadataRead,
+	"readlinkat":    MetadataRead,
+	"pathconf":      MetadataRead,
+	"fpathconf":     MetadataRead,
+	"fsgetpath":     MetadataRead,
+	"lookup":        MetadataRead,
+	"chdir":         MetadataRead,
+	"fchdir":        MetadataRead,
+
+	// Data writes.
+	"wrdata":            DataWrite,
+	"pgout":             DataWrite,
+	"write":             DataWrite,
+	"pwrite":            DataWrite,
+	"writev":            DataWrite,
+	"pwritev":           DataWrite,
+	"guarded_write_np":  DataWrite,
+	"guarded_pwrite_np": DataWrite,
+	"guarded_writev_np": DataWrite,
+	"msync":             DataWrite,
+	"fcopyfile":         DataWrite,
+	"truncate":          DataWrite,
+	"ftruncate":         DataWrite,
+	"fallocate":         DataWrite,
+	"fsync":             DataWrite,
+	"fdatasync":         DataWrite,
+	"exchangedata":      DataWrite,
+	"copy_file_range":   DataWrite,
+
+	// Metadata writes.
+	"wrmeta":        MetadataWrite,
+	"hfs_update":    MetadataWrite,
+	"chmod":         MetadataWrite,
+	"fchmod":        MetadataWrite,
+	"fchmodat":      MetadataWrite,
+	"chown":         MetadataWrite,
+	"fchown":        MetadataWrite,
+	"lchown":        MetadataWrite,
+	"fchownat":      MetadataWrite,
+	"chflags":       MetadataWrite,
+	"fchflags":      MetadataWrite,
+	"utime":         MetadataWrite,
+	"utimes":        MetadataWrite,
+	"futimes":       MetadataWrite,
+	"lutimes":       MetadataWrite,
+	"utimensat":     MetadataWrite,
+	"futimens":      MetadataWrite,
+	"setattrlist":   MetadataWrite,
+	"fsetattrlist":  MetadataWrite,
+	"setattrlistat": MetadataWrite,
+
+	// Entry creation (including rename targets and links).
+	"create":       Create,
+	"creat":        Create,
+	"mkdir":        Create,
+	"mkdirat":      Create,
+	"mkfifo":       Create,
+	"mkfifoat":     Create,
+	"mknod":        Create,
+	"mknodat":      Create,
+	"link":         Create,
+	"linkat":       Create,
+	"symlink":      Create,
+	"symlinkat":    Create,
+	"rename":       Create,
+	"renameat":     Create,
+	"renameat2":    Create,
+	"renamex_np":   Create,
+	"renameatx_np": Create,
+	"clonefile":    Create,
+	"clonefileat":  Create,
+	"fclonefileat": Create,
+	"copyfile":     Create,
+
+	// Entry removal.
+	"unlink":     Delete,
+	"unlinkat":   Delete,
+	"rmdir":      Delete,
+	"removefile": Delete,
+	"delete":     Delete,
+
+	// Program execution.
+	"exec":         Exec,
+	"execve":       Exec,
+	"execveat":     Exec,
+	"posix_spawn":  Exec,
+	"__mac_execve": Exec,
+
+	// Directory enumeration.
+	"getdirentries":     DirectoryList,
+	"getdirentriesattr": DirectoryList,
+	"getattrlistbulk":   DirectoryList,
+	"getdents":          DirectoryList,
+	"readdir":           DirectoryList,
+	"searchfs":          DirectoryList,
+
+	// Extended attributes.
+	"getxattr":     XattrRead,
+	"fgetxattr":    XattrRead,
+	"lgetxattr":    XattrRead,
+	"listxattr":    XattrRead,
+	"flistxattr":   XattrRead,
+	"llistxattr":   XattrRead,
+	"setxattr":     XattrWrite,
+	"fsetxattr":    XattrWrite,
+	"lsetxattr":    XattrWrite,
+	"removexattr":  XattrWrite,
+	"fremovexattr": XattrWrite,
+	"lremovexattr": XattrWrite,
+
+	// Device and filesystem control.
+	"ioctl":  Ioctl,
+	"fsctl":  Ioctl,
+	"ffsctl": Ioctl,
+}
+
+// Normalize canonicalizes an op name as emitted by fs_usage or strace:
+// lower-cased, with fs_usage flag suffixes ("RdData[S]"), cancellation and
+// extended variants ("_nocancel", "_extended") and 64-bit suffixes removed.
+func Normalize(op string) string {
+	name := strings.ToLower(strings.TrimSpace(op))
+	if i := strings.IndexByte(name, '['); i > 0 {
+		name = name[:i]
+	}
+	name = strings.TrimSuffix(name, "_nocancel")
+	name = strings.TrimSuffix(name, "_extended")
+	if _, ok := catalogue[name]; !ok {
+		if trimmed := strings.TrimSuffix(name, "64"); trimmed != name {
+			if _, ok := catalogue[trimmed]; ok {
+				name = trimmed
+			}
+		}
+	}
+	return name
+}
+
+// Lookup returns the category for op and whether the op is in the catalogue.
+// An op missing from the catalogue is a DataWrite when its name contains
+// "write" and Unknown otherwise.
+func Lookup(op string) (Category, bool) {
+	name := Normalize(op)
+	c, ok := catalogue[name]
+	if !ok {
+		if strings.Contains(name, "write") {
+			return DataWrite, false
+		}
+		return Unknown, false
+	}
+	return c, true
+}
+
+// Classify returns the category for op, falling back as Lookup does when it
+// is not catalogued.
+func Classify(op string) Category {
+	c, _ := Lookup(op)
+	return c
+}
+
+func categoryNames() []string {
+	cats := Categories()
+	out := make([]string, 0, len(cats))
+	for _, c := range cats {
+		out = append(out, string(c))
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package ops
+
+import "testing"
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		op   string
+		want Category
+		ok   bool
+	}{
+		{"open", DataRead, true},
+		{"RdData[S]", DataRead, true},
+		{"WrData[AT1]", DataWrite, true},
+		{"write_nocancel", DataWrite, true},
+		{"stat64", MetadataRead, true},
+		{"getattrlist", MetadataRead, true},
+		{"getdirentries64", DirectoryList, true},
+		{"getdents64", DirectoryList, true},
+		{"setxattr", XattrWrite, true},
+		{"getxattr", XattrRead, true},
+		{"mmap", DataRead, true},
+		{"exchangedata", DataWrite, true},
+		{"open_extended", DataRead, true},
+		{"mkdir", Create, true},
+		{"unlinkat", Delete, true},
+		{"execve", Exec, true},
+		{"ioctl", Ioctl, true},
+		{"close_nocancel", Descriptor, true},
+		{"fcntl", Descriptor, true},
+		{"guarded_pwrite_np", DataWrite, true},
+		{"guarded_writev_np", DataWrite, true},
+		{"guarded_close_np", Descriptor, true},
+		{"renamex_np", Create, true},
+		{"frobnicate", Unknown, false},
+		{"frob_write_np", DataWrite, false},
+		{"WriteBack[A]", DataWrite, false},
+	}
+	for _, tt := range tests {
+		got, ok := Lookup(tt.op)
+		if got != tt.want || ok != tt.ok {
+			t.Fatalf("Lookup(%q) = %q,%v want %q,%v", tt.op, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIsWrite(t *testing.T) {
+	for _, c := range []Category{DataWrite, MetadataWrite, Create, Delete, XattrWrite} {
+		if !c.IsWrite() {
+			t.Fatalf("%s should be a write", c)
+		}
+	}
+	for _, c := range []Category{DataRead, MetadataRead, Exec, DirectoryList, XattrRead, Ioctl, Unknown} {
+		if c.IsWrite() {
+			t.Fatalf("%s should not be a write", c)
+		}
+	}
+}
+
+func TestChecksPath(t *testing.T) {
+	for _, c := range append(Categories(), Unknown) {
+		if got, want := c.ChecksPath(), c != Descriptor; got != want {
+			t.Fatalf("%s.ChecksPath() = %v", c, got)
+		}
+	}
+}
+
+func TestParseCategory(t *testing.T) {
+	c, err := ParseCategory("XATTR-Read")
+	if err != nil || c != XattrRead {
+		t.Fatalf("ParseCategory = %q, %v", c, err)
+	}
+	if _, err := ParseCategory("unknown"); err == nil {
+		t.Fatalf("expected error for unknown category")
+	}
+}
+
+func TestNormalizeKeeps64WhenCatalogued(t *testing.T) {
+	if got := Normalize("Stat64"); got != "stat" {
+		t.Fatalf("Normalize(Stat64) = %q", got)
+	}
+	if got := Normalize("foo64"); got != "foo64" {
+		t.Fatalf("Normalize(foo64) = %q", got)
+	}
+}
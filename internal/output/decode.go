@@ -0,0 +1,76 @@
+package output
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+)
+
+// DecodeEvents reads a recorded trace: JSON lines as written by --events --json,
+// or raw fs_usage lines. Blank lines and the summary envelope are skipped; raw
+// lines that do not parse are skipped as well, mirroring live tracing.
+func DecodeEvents(r io.Reader, baseDate time.Time) ([]fsusage.Event, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 128*1024), 512*1024)
+	var events []fsusage.Event
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			ev, summary, err := decodeEventJSON(line, baseDate.Location())
+			if err != nil {
+				return nil, fmt.Errorf("trace line %d: %w", lineNo, err)
+			}
+			if !summary {
+				events = append(events, ev)
+			}
+			continue
+		}
+		if ev, err := fsusage.ParseLine(line, baseDate); err == nil {
+			events = append(events, ev)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// decodeEventJSON decodes an event line; summary reports the envelope line
+// instead.
+func decodeEventJSON(line string, loc *time.Location) (ev fsusage.Event, summary bool, err error) {
+	var payload struct {
+		SchemaVersion int    `json:"schema_version"`
+		Timestamp     string `json:"timestamp"`
+		PID           int    `json:"pid"`
+		Comm          string `json:"comm"`
+		Op            string `json:"op"`
+		Path          string `json:"path"`
+	}
+	if err := json.Unmarshal([]byte(line), &payload); err != nil {
+		return fsusage.Event{}, false, err
+	}
+	if payload.SchemaVersion != 0 {
+		return fsusage.Event{}, true, nil
+	}
+	ev = fsusage.Event{
+		RawTimestamp: payload.Timestamp,
+		PID:          payload.PID,
+		Comm:         payload.Comm,
+		Op:           payload.Op,
+		Path:         payload.Path,
+	}
+	if ts, err := time.ParseInLocation("2006-01-02T15:04:05.000", payload.Timestamp, loc); err == nil {
+		ev.Timestamp = ts
+	}
+	return ev, false, nil
+}
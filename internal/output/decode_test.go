@@ -0,0 +1,42 @@
+package output
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+)
+
+func TestDecodeEventsRoundTrip(t *testing.T) {
+	lines, err := EventsJSONLines([]fsusage.Event{sampleEvent()})
+	if err != nil {
+		t.Fatalf("EventsJSONLines error: %v", err)
+	}
+	raw := "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n"
+	summary, err := EnvelopeJSON(Envelope{SchemaVersion: SchemaVersion, Argv: []string{"mytool"}})
+	if err != nil {
+		t.Fatalf("EnvelopeJSON error: %v", err)
+	}
+	input := lines[0] + "\n\n" + raw + "not a trace line\n" + summary + "\n"
+	events, err := DecodeEvents(strings.NewReader(input), time.Date(2025, time.November, 29, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatalf("DecodeEvents error: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	want := sampleEvent()
+	if got := events[0]; got.Path != want.Path || got.PID != want.PID || got.Op != want.Op || !got.Timestamp.Equal(want.Timestamp) {
+		t.Fatalf("json event mismatch: %+v", got)
+	}
+	if events[1].Path != "/etc/hosts" || events[1].Comm != "mytool" {
+		t.Fatalf("raw event mismatch: %+v", events[1])
+	}
+}
+
+func TestDecodeEventsInvalidJSON(t *testing.T) {
+	if _, err := DecodeEvents(strings.NewReader("{broken\n"), time.Now()); err == nil {
+		t.Fatalf("expected error for invalid JSON line")
+	}
+}
@@ -0,0 +1,75 @@
+package output
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// SchemaVersion versions the JSON envelope. It changes when a field is
+// renamed, removed or changes meaning; new fields may appear without a bump.
+const SchemaVersion = 1
+
+// Envelope describes a trace in JSON output. Document modes embed it next to
+// their payload; line-oriented modes (--events, --stream) end with it as a
+// summary line, recognizable by its schema_version.
+type Envelope struct {
+	SchemaVersion int    `json:"schema_version"`
+	Version       string `json:"fs_tracer_version"`
+	Backend       string `json:"backend"`
+	// Command is yourcmd's resolved executable and Argv its arguments as given.
+	Command     string      `json:"command"`
+	Argv        []string    `json:"argv"`
+	Cwd         string      `json:"cwd"`
+	Launch      Launch      `json:"launch"`
+	Start       time.Time   `json:"start"`
+	End         time.Time   `json:"end"`
+	ExitCode    int         `json:"exit_code"`
+	Runs        int         `json:"runs"`
+	Truncated   string      `json:"truncated,omitempty"`
+	Interrupted bool        `json:"interrupted"`
+	Events      EventCounts `json:"events"`
+	PIDFilter   PIDFilter   `json:"pid_filter"`
+}
+
+// Launch records the settings yourcmd was launched with, so consumers can
+// tell how its environment differed from fs-tracer's own. Cwd already holds
+// its working directory.
+type Launch struct {
+	CleanEnv bool     `json:"clean_env"`
+	UnsetEnv []string `json:"unset_env,omitempty"`
+	// Env holds the KEY=VALUE pairs given with --env, in order.
+	Env   []string `json:"env,omitempty"`
+	Stdin string   `json:"stdin,omitempty"`
+	RunAs string   `json:"run_as,omitempty"`
+}
+
+// EventCounts follows fs_usage lines through the pipeline: lines are parsed
+// or unparseable, parsed events are captured when they belong to yourcmd,
+// and captured events are filtered out or kept.
+type EventCounts struct {
+	Lines       int `json:"lines"`
+	Parsed      int `json:"parsed"`
+	Unparseable int `json:"unparseable"`
+	Captured    int `json:"captured"`
+	Filtered    int `json:"filtered"`
+	Kept        int `json:"kept"`
+	// Spilled counts kept events written to disk under --memory-limit.
+	Spilled int `json:"spilled"`
+}
+
+// PIDFilter reports how --follow-children attributed events. The bypass
+// flags mean the filter could not rely on the process tree.
+type PIDFilter struct {
+	Enabled            bool `json:"enabled"`
+	ThreadLookupBypass bool `json:"thread_lookup_bypass"`
+	ZeroMatchBypass    bool `json:"zero_match_bypass"`
+}
+
+// EnvelopeJSON renders env alone, as the summary line of line-oriented modes.
+func EnvelopeJSON(env Envelope) (string, error) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -26,22 +26,45 @@ func EventLine(ev fsusage.Event) string {
 func EventsJSONLines(events []fsusage.Event) ([]string, error) {
 	lines := make([]string, 0, len(events))
 	for _, ev := range events {
-		payload := map[string]interface{}{
-			"timestamp": formatTimestamp(ev),
-			"pid":       ev.PID,
-			"comm":      ev.Comm,
-			"op":        ev.Op,
-			"path":      ev.Path,
-		}
-		b, err := json.Marshal(payload)
+		line, err := EventJSON(ev)
 		if err != nil {
 			return nil, err
 		}
-		lines = append(lines, string(b))
+		lines = append(lines, line)
 	}
 	return lines, nil
 }
 
+// EventJSON renders a single event as a JSON object.
+func EventJSON(ev fsusage.Event) (string, error) {
+	payload := map[string]interface{}{
+		"timestamp": formatTimestamp(ev),
+		"pid":       ev.PID,
+		"comm":      ev.Comm,
+		"op":        ev.Op,
+		"path":      ev.Path,
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// PathJSON renders a newly seen path for streaming output. access is "read"
+// or "write" with --split-access and empty otherwise.
+func PathJSON(path, access string) (string, error) {
+	payload := map[string]string{"path": path}
+	if access != "" {
+		payload["access"] = access
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
 // PathsText joins paths with newline.
 func PathsText(paths []string) string {
 	return strings.Join(paths, "\n")
@@ -72,6 +95,17 @@ func PathsJSON(paths []string) ([]byte, error) {
 	return json.Marshal(paths)
 }
 
+// PathRuns is a path with the number of --repeat runs that accessed it.
+type PathRuns struct {
+	Path string `json:"path"`
+	Seen int    `json:"seen"`
+}
+
+// FrequencyLine renders a path of a repeated trace as "k/N path".
+func FrequencyLine(p PathRuns, runs int) string {
+	return fmt.Sprintf("%d/%d %s", p.Seen, runs, p.Path)
+}
+
 func formatTimestamp(ev fsusage.Event) string {
 	if !ev.Timestamp.IsZero() {
 		return ev.Timestamp.Format("2006-01-02T15:04:05.000")
@@ -81,3 +115,28 @@ func formatTimestamp(ev fsusage.Event) string {
 	}
 	return ""
 }
+
+// ShellJoin renders argv for display, single-quoting arguments that need it.
+func ShellJoin(argv []string) string {
+	parts := make([]string, 0, len(argv))
+	for _, a := range argv {
+		if a != "" && !strings.ContainsAny(a, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+			parts = append(parts, a)
+			continue
+		}
+		parts = append(parts, "'"+strings.ReplaceAll(a, "'", `'\''`)+"'")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Comment renders text as line comments starting with prefix, one per line, so
+// values with embedded newlines cannot escape the comment.
+func Comment(prefix, text string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		b.WriteString(prefix)
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
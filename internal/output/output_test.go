@@ -85,3 +85,60 @@ func TestPathsJSON(t *testing.T) {
 		t.Fatalf("unexpected array: %v", arr)
 	}
 }
+
+func TestPathJSON(t *testing.T) {
+	got, err := PathJSON("/tmp/out", "write")
+	if err != nil || got != `{"access":"write","path":"/tmp/out"}` {
+		t.Fatalf("PathJSON = %s, %v", got, err)
+	}
+	if got, _ := PathJSON("/a", ""); got != `{"path":"/a"}` {
+		t.Fatalf("PathJSON without access = %s", got)
+	}
+}
+
+func TestFrequencyLine(t *testing.T) {
+	if got := FrequencyLine(PathRuns{Path: "/etc/hosts", Seen: 3}, 5); got != "3/5 /etc/hosts" {
+		t.Fatalf("FrequencyLine = %q", got)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := ShellJoin([]string{"sh", "-c", "echo 'hi' $HOME", ""})
+	want := `sh -c 'echo '\''hi'\'' $HOME' ''`
+	if got != want {
+		t.Fatalf("ShellJoin = %s, want %s", got, want)
+	}
+}
+
+func TestComment(t *testing.T) {
+	if got := Comment("# ", "a\nb"); got != "# a\n# b\n" {
+		t.Fatalf("Comment = %q", got)
+	}
+}
+
+func TestEnvelopeJSON(t *testing.T) {
+	start := time.Date(2025, time.November, 29, 10, 0, 0, 0, time.UTC)
+	got, err := EnvelopeJSON(Envelope{
+		SchemaVersion: SchemaVersion,
+		Version:       "1.0.0",
+		Backend:       "fs_usage",
+		Command:       "/usr/bin/make",
+		Argv:          []string{"make"},
+		Cwd:           "/src",
+		Launch:        Launch{Env: []string{"CC=clang"}, RunAs: "builder"},
+		Start:         start,
+		End:           start.Add(time.Second),
+		ExitCode:      2,
+		Runs:          1,
+		Events:        EventCounts{Lines: 3, Parsed: 2, Unparseable: 1, Captured: 2, Filtered: 1, Kept: 1},
+		PIDFilter:     PIDFilter{Enabled: true, ZeroMatchBypass: true},
+	})
+	want := `{"schema_version":1,"fs_tracer_version":"1.0.0","backend":"fs_usage","command":"/usr/bin/make","argv":["make"],"cwd":"/src",` +
+		`"launch":{"clean_env":false,"env":["CC=clang"],"run_as":"builder"},` +
+		`"start":"2025-11-29T10:00:00Z","end":"2025-11-29T10:00:01Z","exit_code":2,"runs":1,"interrupted":false,` +
+		`"events":{"lines":3,"parsed":2,"unparseable":1,"captured":2,"filtered":1,"kept":1,"spilled":0},` +
+		`"pid_filter":{"enabled":true,"thread_lookup_bypass":false,"zero_match_bypass":true}}`
+	if err != nil || got != want {
+		t.Fatalf("EnvelopeJSON =\n%s\nwant\n%s (%v)", got, want, err)
+	}
+}
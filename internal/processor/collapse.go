@@ -0,0 +1,204 @@
+package processor
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CollapseOptions controls when sibling paths are folded into their directory.
+// A zero value disables collapsing.
+type CollapseOptions struct {
+	// MinCount collapses a directory once at least this many direct entries
+	// (paths or already collapsed subdirectories) were observed in it.
+	MinCount int
+	// MinRatio collapses a directory once this fraction of its on-disk entries
+	// was observed. Directories that cannot be listed are never collapsed by ratio.
+	MinRatio float64
+	// CountEntries returns the number of entries in dir; defaults to os.ReadDir.
+	CountEntries func(dir string) (int, error)
+}
+
+// Enabled reports whether any collapse threshold is set.
+func (o CollapseOptions) Enabled() bool {
+	return o.MinCount > 0 || o.MinRatio > 0
+}
+
+// Group is a directory that replaces the paths it absorbed.
+type Group struct {
+	Dir      string
+	Absorbed []string
+}
+
+// CollapseDirs folds sibling sets into their parent directory, bottom-up, when
+// a directory crosses the configured count or ratio. It returns the paths left
+// as-is and the collapsed groups, both sorted. The filesystem root is never
+// collapsed.
+func CollapseDirs(paths []string, opts CollapseOptions) (literals []string, groups []Group) {
+	if !opts.Enabled() {
+		return sortedCopy(paths), nil
+	}
+	countEntries := opts.CountEntries
+	if countEntries == nil {
+		countEntries = defaultCountEntries
+	}
+
+	// entries[dir] holds the direct children observed in dir; absorbed[p] holds
+	// every original path folded into p once p has been collapsed.
+	entries := map[string]map[string]struct{}{}
+	absorbed := map[string][]string{}
+	unique := map[string]struct{}{}
+	for _, p := range paths {
+		unique[p] = struct{}{}
+	}
+	for p := range unique {
+		addEntry(entries, p)
+		absorbed[p] = append(absorbed[p], p)
+	}
+
+	dirSet := map[string]struct{}{}
+	for p := range unique {
+		for d := filepath.Dir(p); ; d = filepath.Dir(d) {
+			dirSet[d] = struct{}{}
+			if d == filepath.Dir(d) {
+				break
+			}
+		}
+	}
+	dirs := make([]string, 0, len(dirSet))
+	for d := range dirSet {
+		dirs = append(dirs, d)
+	}
+	// Deepest first so collapsed children count as a single entry of their parent.
+	sort.Slice(dirs, func(i, j int) bool {
+		di, dj := depth(dirs[i]), depth(dirs[j])
+		if di != dj {
+			return di > dj
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	collapsed := map[string]struct{}{}
+	for _, dir := range dirs {
+		children := entries[dir]
+		if dir == "/" || len(children) == 0 || !shouldCollapse(dir, len(children), opts, countEntries) {
+			continue
+		}
+		members := absorbed[dir]
+		prefix := dir + "/"
+		for p, sub := range absorbed {
+			if strings.HasPrefix(p, prefix) {
+				members = append(members, sub...)
+				delete(absorbed, p)
+				delete(collapsed, p)
+			}
+		}
+		absorbed[dir] = members
+		collapsed[dir] = struct{}{}
+		addEntry(entries, dir)
+	}
+
+	for p, members := range absorbed {
+		if _, ok := collapsed[p]; ok {
+			groups = append(groups, Group{Dir: p, Absorbed: sortedCopy(members)})
+			continue
+		}
+		if _, ok := unique[p]; ok {
+			literals = append(literals, p)
+		}
+	}
+	sort.Strings(literals)
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Dir < groups[j].Dir })
+	return literals, groups
+}
+
+// MinimalRoots drops every path that lies beneath another path in the set and
+// returns the remaining roots sorted.
+func MinimalRoots(paths []string) []string {
+	var roots []string
+	for _, p := range sortedCopy(paths) {
+		if !WithinAny(p, roots) {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// WithinAny reports whether p lies within any of roots.
+func WithinAny(p string, roots []string) bool {
+	for _, root := range roots {
+		if Within(p, root) {
+			return true
+		}
+	}
+	return false
+}
+
+// CollapsedRoots collapses paths per opts and reduces the result, collapsed
+// directories included, to its minimal roots.
+func CollapsedRoots(paths []string, opts CollapseOptions) []string {
+	literals, groups := CollapseDirs(paths, opts)
+	for _, g := range groups {
+		literals = append(literals, g.Dir)
+	}
+	return MinimalRoots(literals)
+}
+
+// PathLess orders paths component-wise, so a directory sorts before everything
+// beneath it (unlike plain string order, where "/a-b" precedes "/a/c").
+func PathLess(a, b string) bool {
+	return strings.ReplaceAll(a, "/", "\x00") < strings.ReplaceAll(b, "/", "\x00")
+}
+
+// Within reports whether p is root or lies beneath it.
+func Within(p, root string) bool {
+	if root == "/" {
+		return strings.HasPrefix(p, "/")
+	}
+	return p == root || strings.HasPrefix(p, root+"/")
+}
+
+func shouldCollapse(dir string, observed int, opts CollapseOptions, countEntries func(string) (int, error)) bool {
+	if opts.MinCount > 0 && observed >= opts.MinCount {
+		return true
+	}
+	if opts.MinRatio > 0 {
+		total, err := countEntries(dir)
+		if err == nil && total > 0 && float64(observed)/float64(total) >= opts.MinRatio {
+			return true
+		}
+	}
+	return false
+}
+
+func addEntry(entries map[string]map[string]struct{}, p string) {
+	parent := filepath.Dir(p)
+	if parent == p {
+		return
+	}
+	set, ok := entries[parent]
+	if !ok {
+		set = map[string]struct{}{}
+		entries[parent] = set
+	}
+	set[p] = struct{}{}
+}
+
+func depth(p string) int {
+	return strings.Count(strings.TrimSuffix(p, "/"), "/")
+}
+
+func sortedCopy(paths []string) []string {
+	out := append([]string(nil), paths...)
+	sort.Strings(out)
+	return out
+}
+
+func defaultCountEntries(dir string) (int, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	return len(ents), nil
+}
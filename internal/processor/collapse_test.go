@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCollapseDirsByCount(t *testing.T) {
+	var paths []string
+	for i := 0; i < 5; i++ {
+		paths = append(paths, fmt.Sprintf("/cache/foo/f%d", i))
+	}
+	paths = append(paths, "/cache/foo/sub/deep", "/etc/hosts", "/cache/foo")
+	literals, groups := CollapseDirs(paths, CollapseOptions{MinCount: 5})
+	if !reflect.DeepEqual(literals, []string{"/etc/hosts"}) {
+		t.Fatalf("literals mismatch: %v", literals)
+	}
+	if len(groups) != 1 || groups[0].Dir != "/cache/foo" {
+		t.Fatalf("groups mismatch: %+v", groups)
+	}
+	if len(groups[0].Absorbed) != 7 {
+		t.Fatalf("expected 7 absorbed paths (including nested and the dir itself), got %v", groups[0].Absorbed)
+	}
+}
+
+func TestCollapseDirsBottomUp(t *testing.T) {
+	var paths []string
+	for _, d := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			paths = append(paths, fmt.Sprintf("/root/proj/%s/f%d", d, i))
+		}
+	}
+	_, groups := CollapseDirs(paths, CollapseOptions{MinCount: 3})
+	if len(groups) != 1 || groups[0].Dir != "/root/proj" || len(groups[0].Absorbed) != 9 {
+		t.Fatalf("expected nested collapse into /root/proj, got %+v", groups)
+	}
+}
+
+func TestCollapseDirsByRatio(t *testing.T) {
+	paths := []string{"/d/a", "/d/b", "/e/a"}
+	counts := map[string]int{"/d": 2, "/e": 10}
+	literals, groups := CollapseDirs(paths, CollapseOptions{
+		MinRatio: 0.5,
+		CountEntries: func(dir string) (int, error) {
+			if n, ok := counts[dir]; ok {
+				return n, nil
+			}
+			return 0, errors.New("unlistable")
+		},
+	})
+	if !reflect.DeepEqual(literals, []string{"/e/a"}) {
+		t.Fatalf("literals mismatch: %v", literals)
+	}
+	if len(groups) != 1 || groups[0].Dir != "/d" {
+		t.Fatalf("groups mismatch: %+v", groups)
+	}
+}
+
+func TestCollapseDirsNeverRoot(t *testing.T) {
+	literals, groups := CollapseDirs([]string{"/a", "/b", "/c"}, CollapseOptions{MinCount: 2})
+	if len(groups) != 0 || len(literals) != 3 {
+		t.Fatalf("root must not collapse: literals=%v groups=%+v", literals, groups)
+	}
+}
+
+func TestCollapseDirsDisabled(t *testing.T) {
+	literals, groups := CollapseDirs([]string{"/b", "/a"}, CollapseOptions{})
+	if !reflect.DeepEqual(literals, []string{"/a", "/b"}) || groups != nil {
+		t.Fatalf("disabled collapse should return sorted input: %v %+v", literals, groups)
+	}
+}
+
+func TestMinimalRoots(t *testing.T) {
+	got := MinimalRoots([]string{"/a/c", "/a-b", "/a", "/a/c/d", "/b/x", "/a-b"})
+	want := []string{"/a", "/a-b", "/b/x"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("roots = %v, want %v", got, want)
+	}
+	if !Within("/etc/hosts", "/") || Within("/etcx", "/etc") {
+		t.Fatal("Within mismatch")
+	}
+}
+
+func TestCollapsedRootsAndPathLess(t *testing.T) {
+	got := CollapsedRoots([]string{"/d/a", "/d/b", "/d/sub/c", "/e"}, CollapseOptions{MinCount: 2})
+	if !reflect.DeepEqual(got, []string{"/d", "/e"}) {
+		t.Fatalf("roots = %v", got)
+	}
+	if !PathLess("/a/c", "/a-b") || PathLess("/a-b", "/a") {
+		t.Fatal("PathLess must order parents and their children first")
+	}
+}
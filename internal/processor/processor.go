@@ -2,10 +2,10 @@ package processor
 
 import (
 	"path/filepath"
-	"sort"
 	"strings"
 
 	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
 )
 
 // Filters represents ignore rules for events.
@@ -13,65 +13,53 @@ type Filters struct {
 	AllowProcesses  []string
 	IgnoreProcesses []string
 	IgnorePrefixes  []string
+	Categories      []ops.Category
 	MaxDepth        int
 	Raw             bool
 }
 
-// ApplyFilters removes events matching ignore rules unless Raw is true.
-func ApplyFilters(events []fsusage.Event, f Filters) []fsusage.Event {
+// Apply filters a single event, so events can be processed as they arrive.
+// It reports false for dropped events and returns the event with its path
+// truncated to MaxDepth otherwise.
+func (f Filters) Apply(ev fsusage.Event) (fsusage.Event, bool) {
 	if f.Raw {
-		return append([]fsusage.Event(nil), events...)
+		return ev, true
 	}
-	var out []fsusage.Event
-	for _, ev := range events {
-		if len(f.AllowProcesses) > 0 && !contains(f.AllowProcesses, ev.Comm) {
-			continue
-		}
-		if contains(f.IgnoreProcesses, ev.Comm) {
-			continue
-		}
-		if hasPrefix(ev.Path, f.IgnorePrefixes) {
-			continue
-		}
-		path := truncateDepth(ev.Path, f.MaxDepth)
-		// If truncation removed the ignored prefix, still drop the event to honor ignore rules.
-		if hasPrefix(path, f.IgnorePrefixes) {
-			continue
-		}
-		if path != ev.Path {
-			ev.Path = path
-		}
-		out = append(out, ev)
+	if len(f.AllowProcesses) > 0 && !contains(f.AllowProcesses, ev.Comm) {
+		return ev, false
 	}
-	return out
+	if contains(f.IgnoreProcesses, ev.Comm) {
+		return ev, false
+	}
+	if hasPrefix(ev.Path, f.IgnorePrefixes) {
+		return ev, false
+	}
+	if len(f.Categories) > 0 && !containsCategory(f.Categories, ops.Classify(ev.Op)) {
+		return ev, false
+	}
+	path := truncateDepth(ev.Path, f.MaxDepth)
+	// If truncation removed the ignored prefix, still drop the event to honor ignore rules.
+	if hasPrefix(path, f.IgnorePrefixes) {
+		return ev, false
+	}
+	ev.Path = path
+	return ev, true
 }
 
-// UniqueSortedPaths collects unique paths (or their parent directories) and returns them sorted.
-func UniqueSortedPaths(events []fsusage.Event, dirsOnly bool) []string {
-	set := map[string]struct{}{}
-	for _, ev := range events {
-		p := normalizePath(ev.Path, dirsOnly)
-		set[p] = struct{}{}
-	}
-	return toSortedSlice(set)
+// Access records the op categories observed on a single path.
+type Access struct {
+	Path       string
+	Categories []ops.Category
 }
 
-// ClassifyPaths separates paths into read and write sets based on operation kind.
-func ClassifyPaths(events []fsusage.Event, dirsOnly bool) (reads []string, writes []string) {
-	readSet := map[string]struct{}{}
-	writeSet := map[string]struct{}{}
-	for _, ev := range events {
-		p := normalizePath(ev.Path, dirsOnly)
-		if isWriteOp(ev.Op) {
-			writeSet[p] = struct{}{}
-		} else {
-			readSet[p] = struct{}{}
-		}
-	}
-	return toSortedSlice(readSet), toSortedSlice(writeSet)
+// Has reports whether c was observed on the path.
+func (a Access) Has(c ops.Category) bool {
+	return containsCategory(a.Categories, c)
 }
 
-func normalizePath(p string, dirsOnly bool) string {
+// NormalizePath returns the path reported for p: its parent directory when
+// dirsOnly is set.
+func NormalizePath(p string, dirsOnly bool) string {
 	if !dirsOnly {
 		return p
 	}
@@ -100,18 +88,13 @@ func hasPrefix(path string, prefixes []string) bool {
 	return false
 }
 
-func isWriteOp(op string) bool {
-	lo := strings.ToLower(op)
-	if strings.Contains(lo, "write") || strings.HasPrefix(lo, "wr") {
-		return true
-	}
-	switch lo {
-	case "rename", "unlink", "link", "symlink", "mkdir", "rmdir", "removefile", "create",
-		"fsync", "truncate", "ftruncate", "chown", "chmod", "setattrlist":
-		return true
-	default:
-		return false
+func containsCategory(list []ops.Category, target ops.Category) bool {
+	for _, c := range list {
+		if c == target {
+			return true
+		}
 	}
+	return false
 }
 
 func truncateDepth(path string, maxDepth int) string {
@@ -133,12 +116,3 @@ func truncateDepth(path string, maxDepth int) string {
 	trimmed := "/" + strings.Join(parts[start:start+maxDepth], "/")
 	return trimmed
 }
-
-func toSortedSlice(set map[string]struct{}) []string {
-	out := make([]string, 0, len(set))
-	for p := range set {
-		out = append(out, p)
-	}
-	sort.Strings(out)
-	return out
-}
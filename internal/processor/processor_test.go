@@ -2,11 +2,11 @@ package processor
 
 import (
 	"reflect"
-	"sort"
 	"testing"
 	"time"
 
 	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
 )
 
 func sampleEvents() []fsusage.Event {
@@ -18,9 +18,20 @@ func sampleEvents() []fsusage.Event {
 	}
 }
 
+// applyAll keeps the events f.Apply keeps.
+func applyAll(events []fsusage.Event, f Filters) []fsusage.Event {
+	var out []fsusage.Event
+	for _, ev := range events {
+		if ev, ok := f.Apply(ev); ok {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
 func TestApplyFilters(t *testing.T) {
 	evs := sampleEvents()
-	filtered := ApplyFilters(evs, Filters{
+	filtered := applyAll(evs, Filters{
 		IgnoreProcesses: []string{"trustd"},
 		IgnorePrefixes:  []string{"/System"},
 	})
@@ -39,7 +50,7 @@ func TestApplyFilters(t *testing.T) {
 
 func TestApplyFiltersAllowList(t *testing.T) {
 	evs := sampleEvents()
-	filtered := ApplyFilters(evs, Filters{
+	filtered := applyAll(evs, Filters{
 		AllowProcesses: []string{"mytool"},
 	})
 	if len(filtered) != 2 {
@@ -54,7 +65,7 @@ func TestApplyFiltersAllowList(t *testing.T) {
 
 func TestApplyFiltersRawSkips(t *testing.T) {
 	evs := sampleEvents()
-	filtered := ApplyFilters(evs, Filters{Raw: true, IgnoreProcesses: []string{"trustd"}, IgnorePrefixes: []string{"/System"}})
+	filtered := applyAll(evs, Filters{Raw: true, IgnoreProcesses: []string{"trustd"}, IgnorePrefixes: []string{"/System"}})
 	if !reflect.DeepEqual(filtered, evs) {
 		t.Fatalf("raw mode should bypass filters")
 	}
@@ -66,40 +77,20 @@ func TestApplyFiltersIgnoreCWDWithMaxDepth(t *testing.T) {
 		{Path: "/tmp/out", Comm: "ruby"},
 	}
 	filters := Filters{IgnorePrefixes: []string{"/Users/alice/work/proj"}, MaxDepth: 3}
-	filtered := ApplyFilters(evs, filters)
+	filtered := applyAll(evs, filters)
 	if len(filtered) != 1 || filtered[0].Path != "/tmp/out" {
 		t.Fatalf("ignore-cwd with max-depth failed: %+v", filtered)
 	}
 }
 
-func TestUniqueSortedPaths(t *testing.T) {
-	evs := sampleEvents()
-	paths := UniqueSortedPaths(evs, false)
-	want := []string{"/System/Library/abc", "/etc/hosts", "/tmp/out.log"}
-	if !reflect.DeepEqual(paths, want) {
-		t.Fatalf("paths mismatch: got %v want %v", paths, want)
-	}
-}
-
-func TestUniqueSortedPathsDirsOnly(t *testing.T) {
-	evs := sampleEvents()
-	paths := UniqueSortedPaths(evs, true)
-	want := []string{"/System/Library", "/etc", "/tmp"}
-	if !reflect.DeepEqual(paths, want) {
-		t.Fatalf("dirs mismatch: got %v want %v", paths, want)
-	}
-}
-
-func TestClassifyPaths(t *testing.T) {
-	evs := sampleEvents()
-	read, write := ClassifyPaths(evs, false)
-	sort.Strings(read)
-	sort.Strings(write)
-	if !reflect.DeepEqual(read, []string{"/System/Library/abc", "/etc/hosts"}) {
-		t.Fatalf("read mismatch: %v", read)
+func TestFiltersApply(t *testing.T) {
+	f := Filters{IgnoreProcesses: []string{"trustd"}, MaxDepth: 2}
+	if _, ok := f.Apply(fsusage.Event{Comm: "trustd", Path: "/etc/hosts"}); ok {
+		t.Fatalf("ignored process kept")
 	}
-	if !reflect.DeepEqual(write, []string{"/tmp/out.log"}) {
-		t.Fatalf("write mismatch: %v", write)
+	ev, ok := f.Apply(fsusage.Event{Comm: "mytool", Path: "/usr/lib/libc.so"})
+	if !ok || ev.Path != "/usr/lib" {
+		t.Fatalf("Apply = %+v, %v", ev, ok)
 	}
 }
 
@@ -122,3 +113,18 @@ func TestTruncateDepth(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyFiltersCategories(t *testing.T) {
+	evs := sampleEvents()
+	filtered := applyAll(evs, Filters{Categories: []ops.Category{ops.DataWrite}})
+	if len(filtered) != 1 || filtered[0].Path != "/tmp/out.log" {
+		t.Fatalf("category filter failed: %+v", filtered)
+	}
+}
+
+func TestAccessHas(t *testing.T) {
+	acc := Access{Path: "/a", Categories: []ops.Category{ops.DataRead, ops.MetadataRead}}
+	if !acc.Has(ops.MetadataRead) || acc.Has(ops.Create) {
+		t.Fatalf("Has mismatch: %+v", acc)
+	}
+}
@@ -0,0 +1,367 @@
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
+)
+
+// Action is the verdict of an SBPL rule.
+type Action string
+
+const (
+	ActionAllow Action = "allow"
+	ActionDeny  Action = "deny"
+)
+
+// Policy is an SBPL profile loaded for evaluation.
+type Policy struct {
+	Rules    []PolicyRule
+	Warnings []string
+}
+
+// PolicyRule is one allow/deny form of a profile. A rule without filters
+// applies to every path.
+type PolicyRule struct {
+	Action     Action
+	Operations []string
+	Line       int
+	Text       string
+	filters    []pathFilter
+}
+
+// pathFilter matches a path against one SBPL filter expression.
+type pathFilter interface {
+	match(path string) bool
+}
+
+type literalFilter string
+type subpathFilter string
+type prefixFilter string
+type regexFilter struct{ re *regexp.Regexp }
+type anyFilter []pathFilter
+type allFilter []pathFilter
+type notFilter struct{ f pathFilter }
+type neverFilter struct{}
+
+func (f literalFilter) match(p string) bool { return p == string(f) }
+func (f subpathFilter) match(p string) bool {
+	root := strings.TrimSuffix(string(f), "/")
+	return root == "" || p == root || strings.HasPrefix(p, root+"/")
+}
+func (f prefixFilter) match(p string) bool { return strings.HasPrefix(p, string(f)) }
+func (f regexFilter) match(p string) bool  { return f.re.MatchString(p) }
+func (f anyFilter) match(p string) bool {
+	for _, sub := range f {
+		if sub.match(p) {
+			return true
+		}
+	}
+	return false
+}
+func (f allFilter) match(p string) bool {
+	for _, sub := range f {
+		if !sub.match(p) {
+			return false
+		}
+	}
+	return true
+}
+func (f notFilter) match(p string) bool { return !f.f.match(p) }
+func (neverFilter) match(string) bool   { return false }
+
+// LoadPolicy parses src and resolves (param "NAME") references from params.
+// Forms that cannot be evaluated (imports, unknown filters) are recorded as
+// warnings; unknown filters never match.
+func LoadPolicy(src string, params map[string]string) (*Policy, error) {
+	exprs, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	l := &policyLoader{src: src, params: params, policy: &Policy{}}
+	for _, e := range exprs {
+		l.form(e)
+	}
+	return l.policy, nil
+}
+
+type policyLoader struct {
+	src    string
+	params map[string]string
+	policy *Policy
+}
+
+func (l *policyLoader) warnf(line int, format string, args ...interface{}) {
+	l.policy.Warnings = append(l.policy.Warnings, fmt.Sprintf("line %d: %s", line, fmt.Sprintf(format, args...)))
+}
+
+func (l *policyLoader) form(e Expr) {
+	switch e.Head() {
+	case "version", "debug":
+	case "import":
+		l.warnf(e.Line, "import not resolved: %s", l.text(e))
+	case "allow", "deny":
+		l.rule(e)
+	default:
+		l.warnf(e.Line, "unsupported form ignored: %s", l.text(e))
+	}
+}
+
+func (l *policyLoader) rule(e Expr) {
+	r := PolicyRule{Action: Action(e.Head()), Line: e.Line, Text: l.text(e)}
+	for _, arg := range e.List[1:] {
+		switch {
+		case arg.Kind == ExprSymbol:
+			r.Operations = append(r.Operations, arg.Value)
+		case arg.Head() == "with":
+			// Modifiers such as (with report) do not affect the verdict.
+		default:
+			r.filters = append(r.filters, l.filter(arg))
+		}
+	}
+	l.policy.Rules = append(l.policy.Rules, r)
+}
+
+func (l *policyLoader) filter(e Expr) pathFilter {
+	switch e.Head() {
+	case "literal", "subpath", "prefix", "regex":
+		if len(e.List) != 2 {
+			l.warnf(e.Line, "%s expects one argument: %s", e.Head(), l.text(e))
+			return neverFilter{}
+		}
+		if e.Head() == "regex" {
+			if e.List[1].Kind != ExprRegex && e.List[1].Kind != ExprString {
+				l.warnf(e.Line, "regex expects a literal: %s", l.text(e))
+				return neverFilter{}
+			}
+			re, err := regexp.Compile(e.List[1].Value)
+			if err != nil {
+				l.warnf(e.Line, "invalid regex %q: %v", e.List[1].Value, err)
+				return neverFilter{}
+			}
+			return regexFilter{re: re}
+		}
+		v, ok := l.value(e.List[1])
+		if !ok {
+			return neverFilter{}
+		}
+		switch e.Head() {
+		case "literal":
+			return literalFilter(v)
+		case "subpath":
+			return subpathFilter(v)
+		default:
+			return prefixFilter(v)
+		}
+	case "require-any", "require-all":
+		var subs []pathFilter
+		for _, arg := range e.List[1:] {
+			subs = append(subs, l.filter(arg))
+		}
+		if e.Head() == "require-any" {
+			return anyFilter(subs)
+		}
+		return allFilter(subs)
+	case "require-not":
+		if len(e.List) != 2 {
+			l.warnf(e.Line, "require-not expects one argument: %s", l.text(e))
+			return neverFilter{}
+		}
+		return notFilter{f: l.filter(e.List[1])}
+	default:
+		l.warnf(e.Line, "unsupported filter never matches: %s", l.text(e))
+		return neverFilter{}
+	}
+}
+
+// value evaluates a string expression: a literal, (param "NAME") or
+// (string-append ...).
+func (l *policyLoader) value(e Expr) (string, bool) {
+	switch {
+	case e.Kind == ExprString:
+		return e.Value, true
+	case e.Head() == "param" && len(e.List) == 2 && e.List[1].Kind == ExprString:
+		v, ok := l.params[e.List[1].Value]
+		if !ok {
+			l.warnf(e.Line, "param %q is not defined", e.List[1].Value)
+		}
+		return v, ok
+	case e.Head() == "string-append":
+		var b strings.Builder
+		for _, arg := range e.List[1:] {
+			v, ok := l.value(arg)
+			if !ok {
+				return "", false
+			}
+			b.WriteString(v)
+		}
+		return b.String(), true
+	default:
+		l.warnf(e.Line, "unsupported value: %s", l.text(e))
+		return "", false
+	}
+}
+
+// text returns the source of e with whitespace runs collapsed.
+func (l *policyLoader) text(e Expr) string {
+	return strings.Join(strings.Fields(l.src[e.Start:e.End]), " ")
+}
+
+// Decide evaluates operation on path. Later rules take precedence, as in
+// sandbox-exec; when nothing matches the access is denied and rule is nil.
+func (p *Policy) Decide(operation, path string) (Action, *PolicyRule) {
+	for i := len(p.Rules) - 1; i >= 0; i-- {
+		r := &p.Rules[i]
+		if !r.matchesOperation(operation) {
+			continue
+		}
+		if len(r.filters) > 0 && !anyFilter(r.filters).match(path) {
+			continue
+		}
+		return r.Action, r
+	}
+	return ActionDeny, nil
+}
+
+func (r *PolicyRule) matchesOperation(op string) bool {
+	for _, ro := range r.Operations {
+		if operationMatches(ro, op) {
+			return true
+		}
+	}
+	return false
+}
+
+func operationMatches(ruleOp, op string) bool {
+	if ruleOp == "default" || ruleOp == op {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(ruleOp, "*"); ok {
+		return strings.HasPrefix(op, prefix)
+	}
+	return false
+}
+
+// Denial is a traced access the profile would refuse.
+type Denial struct {
+	Path      string
+	Operation string
+	Op        string
+	Rule      *PolicyRule
+}
+
+// AuditReport lists denied accesses and allow rules that no access needed.
+type AuditReport struct {
+	Denied   []Denial
+	Unused   []PolicyRule
+	Warnings []string
+}
+
+// Audit evaluates each traced event against the policy.
+func Audit(p *Policy, events []fsusage.Event) AuditReport {
+	report := AuditReport{Warnings: append([]string(nil), p.Warnings...)}
+	used := map[*PolicyRule]struct{}{}
+	seen := map[[2]string]struct{}{}
+	for _, ev := range events {
+		for _, operation := range auditOperations(ev.Op) {
+			key := [2]string{operation, ev.Path}
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			action, rule := p.Decide(operation, ev.Path)
+			if rule != nil {
+				used[rule] = struct{}{}
+			}
+			if action == ActionDeny {
+				report.Denied = append(report.Denied, Denial{Path: ev.Path, Operation: operation, Op: ev.Op, Rule: rule})
+			}
+		}
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Action != ActionAllow || !r.auditable() {
+			continue
+		}
+		if _, ok := used[r]; !ok {
+			report.Unused = append(report.Unused, *r)
+		}
+	}
+	sort.SliceStable(report.Denied, func(i, j int) bool {
+		if report.Denied[i].Path != report.Denied[j].Path {
+			return report.Denied[i].Path < report.Denied[j].Path
+		}
+		return report.Denied[i].Operation < report.Denied[j].Operation
+	})
+	return report
+}
+
+// auditable reports whether the rule covers operations a trace can exercise.
+func (r *PolicyRule) auditable() bool {
+	for _, op := range r.Operations {
+		if op == "default" || strings.HasPrefix(op, "file") || strings.HasPrefix(op, "process-exec") {
+			return true
+		}
+	}
+	return false
+}
+
+// auditOperations returns the concrete sandbox-exec operations a syscall
+// needs. Metadata writes are narrowed by syscall name, and descriptor ops
+// need none.
+func auditOperations(op string) []string {
+	c := ops.Classify(op)
+	switch c {
+	case ops.Descriptor:
+		return nil
+	case ops.MetadataWrite:
+		name := ops.Normalize(op)
+		switch {
+		case strings.Contains(name, "chown"):
+			return []string{"file-write-owner"}
+		case strings.Contains(name, "utime"), strings.Contains(name, "attrlist"):
+			return []string{"file-write-times"}
+		case strings.Contains(name, "chflags"):
+			return []string{"file-write-flags"}
+		default:
+			return []string{"file-write-mode"}
+		}
+	case ops.Unknown:
+		return []string{"file-read-data"}
+	default:
+		return OperationsFor(c)
+	}
+}
+
+// FormatAudit renders an audit report as text sections.
+func FormatAudit(r AuditReport) string {
+	var buf bytes.Buffer
+	buf.WriteString("# DENIED\n")
+	for _, d := range r.Denied {
+		fmt.Fprintf(&buf, "%s %s (op=%s, %s)\n", d.Operation, d.Path, d.Op, denialCause(d))
+	}
+	buf.WriteString("\n# UNUSED RULES\n")
+	for _, u := range r.Unused {
+		fmt.Fprintf(&buf, "line %d: %s\n", u.Line, u.Text)
+	}
+	if len(r.Warnings) > 0 {
+		buf.WriteString("\n# WARNINGS\n")
+		for _, w := range r.Warnings {
+			buf.WriteString(w)
+			buf.WriteByte('\n')
+		}
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func denialCause(d Denial) string {
+	if d.Rule == nil {
+		return "no matching rule"
+	}
+	return fmt.Sprintf("line %d: %s", d.Rule.Line, d.Rule.Text)
+}
@@ -0,0 +1,96 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+)
+
+const auditProfile = `(version 1)
+(deny default)
+(import "system.sb")
+(allow file-read* (subpath (param "HOME")))
+(allow file-read-data (require-all (prefix "/etc/") (require-not (literal "/etc/shadow"))))
+(allow file-write* (require-any (literal "/tmp/out") (regex #"^/tmp/log\.[0-9]+$")))
+(allow file-write-create (literal "/never/used"))
+(allow mach-lookup (global-name "com.apple.foo"))
+`
+
+func TestLoadPolicyDecide(t *testing.T) {
+	p, err := LoadPolicy(auditProfile, map[string]string{"HOME": "/Users/alice"})
+	if err != nil {
+		t.Fatalf("LoadPolicy error: %v", err)
+	}
+	tests := []struct {
+		op, path string
+		want     Action
+	}{
+		{"file-read-data", "/Users/alice/.zshrc", ActionAllow},
+		{"file-read-metadata", "/Users/alice", ActionAllow},
+		{"file-read-data", "/etc/hosts", ActionAllow},
+		{"file-read-data", "/etc/shadow", ActionDeny},
+		{"file-read-metadata", "/etc/hosts", ActionDeny},
+		{"file-write-data", "/tmp/log.42", ActionAllow},
+		{"file-write-data", "/tmp/log.x", ActionDeny},
+		{"file-write-data", "/tmp/out", ActionAllow},
+	}
+	for _, tt := range tests {
+		if got, _ := p.Decide(tt.op, tt.path); got != tt.want {
+			t.Fatalf("Decide(%s, %s) = %s want %s", tt.op, tt.path, got, tt.want)
+		}
+	}
+	if !containsAll(strings.Join(p.Warnings, "\n"), []string{"import not resolved", "unsupported filter never matches: (global-name"}) {
+		t.Fatalf("warnings mismatch: %v", p.Warnings)
+	}
+}
+
+func TestDecideLaterRuleWins(t *testing.T) {
+	p, err := LoadPolicy(`(allow default) (deny file-write*) (allow file-write* (subpath "/tmp"))`, nil)
+	if err != nil {
+		t.Fatalf("LoadPolicy error: %v", err)
+	}
+	if a, _ := p.Decide("file-write-data", "/tmp/a"); a != ActionAllow {
+		t.Fatalf("later allow should win")
+	}
+	if a, r := p.Decide("file-write-data", "/etc/a"); a != ActionDeny || r == nil || r.Text != "(deny file-write*)" {
+		t.Fatalf("deny rule should match, got %s %+v", a, r)
+	}
+	if a, _ := p.Decide("file-read-data", "/etc/a"); a != ActionAllow {
+		t.Fatalf("default allow should apply to reads")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	p, err := LoadPolicy(auditProfile, map[string]string{"HOME": "/Users/alice"})
+	if err != nil {
+		t.Fatalf("LoadPolicy error: %v", err)
+	}
+	events := []fsusage.Event{
+		{Op: "open", Path: "/Users/alice/.zshrc"},
+		{Op: "open", Path: "/etc/hosts"},
+		{Op: "stat64", Path: "/etc/hosts"},
+		{Op: "write", Path: "/tmp/out"},
+		{Op: "mkdir", Path: "/var/tmp/new"},
+		{Op: "mkdir", Path: "/var/tmp/new"},
+		{Op: "close", Path: "/dev/ttys001"},
+		{Op: "fcntl", Path: "/dev/ttys001"},
+	}
+	report := Audit(p, events)
+	if len(report.Denied) != 2 {
+		t.Fatalf("expected 2 denials, got %+v", report.Denied)
+	}
+	if report.Denied[0].Path != "/etc/hosts" || report.Denied[0].Operation != "file-read-metadata" {
+		t.Fatalf("first denial mismatch: %+v", report.Denied[0])
+	}
+	if report.Denied[1].Path != "/var/tmp/new" || report.Denied[1].Operation != "file-write-create" {
+		t.Fatalf("second denial mismatch: %+v", report.Denied[1])
+	}
+	if len(report.Unused) != 1 || report.Unused[0].Line != 7 {
+		t.Fatalf("unused rules mismatch: %+v", report.Unused)
+	}
+	text := FormatAudit(report)
+	if !containsAll(text, []string{"# DENIED\nfile-read-metadata /etc/hosts (op=stat64, line 2: (deny default))", "# UNUSED RULES\nline 7: (allow file-write-create (literal \"/never/used\"))", "# WARNINGS"}) {
+		t.Fatalf("formatted audit mismatch:\n%s", text)
+	}
+}
@@ -0,0 +1,208 @@
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// Input is what a Generator knows about a traced run.
+type Input struct {
+	Command    []string
+	Executable string
+	// Dir is the working directory of the traced command.
+	Dir      string
+	TracedAt time.Time
+	// Home is the user's home directory, for formats with home-relative paths.
+	Home string
+	// Accesses lists every path with the op categories exercised on it.
+	Accesses []processor.Access
+	// Reads and Writes are the classified path sets (see
+	// aggregate.Aggregator.ReadWrite).
+	Reads  []string
+	Writes []string
+	// Execs lists executed binaries, including those of followed children.
+	Execs []string
+	// Network lists the network syscalls observed. fs_usage's file system
+	// filters never report any, so it is only populated from other traces.
+	Network []string
+	// Launch holds the fs-tracer flags that recreated the traced command's
+	// environment (--env, --cwd, --stdin, ...); headers record them.
+	Launch []string
+
+	// Base and LeastPrivilege shape sandbox-exec profiles.
+	Base           Base
+	LeastPrivilege bool
+	// Rules controls path minimization; formats without literal rules use
+	// Rules.Collapse only.
+	Rules RuleOptions
+}
+
+// Output is a generated profile.
+type Output struct {
+	Data []byte
+	// Warnings are reported on stderr, one per line.
+	Warnings []string
+	// Rules lists synthesized rules for --rule-report.
+	Rules []Rule
+	// Banner asks for the fs-tracer banner before Data; runnable formats
+	// leave it off.
+	Banner bool
+}
+
+// Generator renders a trace in one profile format.
+type Generator interface {
+	Name() string
+	Generate(in Input) (Output, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Generator{}
+)
+
+// Register makes a generator available by name. It panics on duplicates, as
+// registration happens from package init functions.
+func Register(g Generator) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[g.Name()]; dup {
+		panic("sandbox: duplicate generator " + g.Name())
+	}
+	registry[g.Name()] = g
+}
+
+// Lookup returns the generator registered under name.
+func Lookup(name string) (Generator, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	g, ok := registry[name]
+	return g, ok
+}
+
+// Formats returns the registered generator names, sorted.
+func Formats() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseFormat validates a --profile-format value.
+func ParseFormat(name string) (Generator, error) {
+	g, ok := Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown profile format %q (want one of %v)", name, Formats())
+	}
+	return g, nil
+}
+
+// networkOps are the socket syscalls reported in Input.Network.
+var networkOps = map[string]struct{}{
+	"socket": {}, "connect": {}, "bind": {}, "listen": {}, "accept": {},
+	"sendto": {}, "recvfrom": {}, "sendmsg": {}, "recvmsg": {},
+}
+
+// Execs returns the paths that were executed.
+func Execs(accesses []processor.Access) []string {
+	var out []string
+	for _, acc := range accesses {
+		if acc.Has(ops.Exec) {
+			out = append(out, acc.Path)
+		}
+	}
+	return out
+}
+
+// NetworkOps returns the distinct socket syscalls among op names, sorted.
+func NetworkOps(opNames []string) []string {
+	set := map[string]struct{}{}
+	for _, op := range opNames {
+		name := ops.Normalize(op)
+		if _, ok := networkOps[name]; ok {
+			set[name] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(set))
+	for name := range set {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+const (
+	// FormatSnippet and FormatProfile are the sandbox-exec formats.
+	FormatSnippet = "sandbox-snippet"
+	FormatProfile = "sandbox-profile"
+)
+
+func init() {
+	Register(snippetGenerator{})
+	Register(profileGenerator{})
+}
+
+type snippetGenerator struct{}
+
+func (snippetGenerator) Name() string { return FormatSnippet }
+
+func (snippetGenerator) Generate(in Input) (Output, error) {
+	var (
+		snippet string
+		rules   []Rule
+		err     error
+	)
+	if in.LeastPrivilege {
+		snippet, rules, err = BuildGranularSnippetsWithRules(in.Accesses, in.Rules)
+	} else {
+		snippet, rules, err = BuildSnippetsWithRules(in.Reads, in.Writes, in.Rules)
+	}
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Data: []byte(snippet + "\n"), Warnings: ruleNotes(rules), Rules: rules, Banner: true}, nil
+}
+
+type profileGenerator struct{}
+
+func (profileGenerator) Name() string { return FormatProfile }
+
+func (profileGenerator) Generate(in Input) (Output, error) {
+	profile, rules, err := BuildProfile(ProfileConfig{
+		Base:           in.Base,
+		Command:        in.Command,
+		Launch:         in.Launch,
+		Executable:     in.Executable,
+		Execs:          in.Execs,
+		TracedAt:       in.TracedAt,
+		Reads:          in.Reads,
+		Writes:         in.Writes,
+		Accesses:       in.Accesses,
+		LeastPrivilege: in.LeastPrivilege,
+		Network:        len(in.Network) > 0,
+		Rules:          in.Rules,
+	})
+	if err != nil {
+		return Output{}, err
+	}
+	return Output{Data: []byte(profile), Warnings: ruleNotes(rules), Rules: rules}, nil
+}
+
+// ruleNotes returns the notes of rules that replaced a synthesized one.
+func ruleNotes(rules []Rule) []string {
+	var out []string
+	for _, r := range rules {
+		if r.Note != "" {
+			out = append(out, r.Note)
+		}
+	}
+	return out
+}
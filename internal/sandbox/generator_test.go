@@ -0,0 +1,108 @@
+package sandbox
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+type stubGenerator struct{ name string }
+
+func (g stubGenerator) Name() string { return g.name }
+
+func (g stubGenerator) Generate(Input) (Output, error) {
+	return Output{Data: []byte(g.name)}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register(stubGenerator{name: "test-stub"})
+	defer func() {
+		registryMu.Lock()
+		delete(registry, "test-stub")
+		registryMu.Unlock()
+	}()
+
+	g, err := ParseFormat("test-stub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := g.Generate(Input{})
+	if string(out.Data) != "test-stub" {
+		t.Fatalf("Generate = %q", out.Data)
+	}
+	formats := Formats()
+	for _, want := range []string{FormatProfile, FormatSnippet, "test-stub"} {
+		if !contains(formats, want) {
+			t.Fatalf("Formats() = %v, missing %s", formats, want)
+		}
+	}
+	if _, err := ParseFormat("nope"); err == nil || !strings.Contains(err.Error(), "test-stub") {
+		t.Fatalf("ParseFormat(nope) err = %v", err)
+	}
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate registration did not panic")
+		}
+	}()
+	Register(snippetGenerator{})
+}
+
+func TestSnippetGenerator(t *testing.T) {
+	g, _ := Lookup(FormatSnippet)
+	out, err := g.Generate(Input{Reads: []string{"/etc/hosts"}, Writes: []string{"/tmp/out"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !out.Banner || !containsAll(string(out.Data), []string{`(literal "/etc/hosts")`, `(literal "/tmp/out")`}) {
+		t.Fatalf("snippet output = %+v", out)
+	}
+
+	out, _ = g.Generate(Input{
+		LeastPrivilege: true,
+		Accesses:       []processor.Access{{Path: "/etc/hosts", Categories: []ops.Category{ops.DataRead}}},
+	})
+	if !strings.Contains(string(out.Data), "file-read-data") {
+		t.Fatalf("least-privilege snippet = %s", out.Data)
+	}
+}
+
+func TestProfileGeneratorNetwork(t *testing.T) {
+	g, _ := Lookup(FormatProfile)
+	out, _ := g.Generate(Input{Base: BaseDenyDefault, Reads: []string{"/etc/hosts"}})
+	if out.Banner || strings.Contains(string(out.Data), "network*") {
+		t.Fatalf("profile without network activity:\n%s", out.Data)
+	}
+	out, _ = g.Generate(Input{Base: BaseDenyDefault, Reads: []string{"/etc/hosts"}, Network: []string{"connect"}})
+	if !strings.Contains(string(out.Data), "(allow network*)") {
+		t.Fatalf("network rule missing:\n%s", out.Data)
+	}
+}
+
+func TestExecsAndNetworkOps(t *testing.T) {
+	accesses := []processor.Access{
+		{Path: "/bin/sh", Categories: []ops.Category{ops.Exec, ops.DataRead}},
+		{Path: "/etc/hosts", Categories: []ops.Category{ops.DataRead}},
+	}
+	if got := Execs(accesses); !reflect.DeepEqual(got, []string{"/bin/sh"}) {
+		t.Fatalf("Execs = %v", got)
+	}
+	got := NetworkOps([]string{"open", "connect", "sendto_nocancel", "connect", "read"})
+	if !reflect.DeepEqual(got, []string{"connect", "sendto"}) {
+		t.Fatalf("NetworkOps = %v", got)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
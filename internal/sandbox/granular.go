@@ -0,0 +1,110 @@
+package sandbox
+
+import (
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// operationOrder fixes the order of allow blocks in least-privilege output.
+var operationOrder = []string{
+	"file-read-metadata",
+	"file-read-data",
+	"file-read-xattr",
+	"file-write-data",
+	"file-write-mode",
+	"file-write-owner",
+	"file-write-times",
+	"file-write-flags",
+	"file-write-create",
+	"file-write-unlink",
+	"file-write-xattr",
+	"file-ioctl",
+	"process-exec",
+	"file-read*",
+}
+
+// categoryOperations maps op categories to the narrowest sandbox-exec operations
+// that cover them. Unknown ops keep the broad read grant so nothing observed is
+// denied.
+var categoryOperations = map[ops.Category][]string{
+	ops.DataRead:      {"file-read-data"},
+	ops.MetadataRead:  {"file-read-metadata"},
+	ops.DirectoryList: {"file-read-data"},
+	ops.XattrRead:     {"file-read-xattr"},
+	ops.DataWrite:     {"file-write-data"},
+	ops.MetadataWrite: {"file-write-mode", "file-write-owner", "file-write-times", "file-write-flags"},
+	ops.Create:        {"file-write-create"},
+	ops.Delete:        {"file-write-unlink"},
+	ops.XattrWrite:    {"file-write-xattr"},
+	ops.Ioctl:         {"file-ioctl"},
+	ops.Exec:          {"file-read-data", "process-exec"},
+	ops.Unknown:       {"file-read*"},
+}
+
+// OperationsFor returns the sandbox-exec operations needed for an op category.
+func OperationsFor(c ops.Category) []string {
+	return categoryOperations[c]
+}
+
+// BuildGranularSnippets emits one allow block per sandbox-exec operation, granting
+// each path only the operations that were exercised on it.
+func BuildGranularSnippets(accesses []processor.Access) (string, error) {
+	out, _, err := BuildGranularSnippetsWithRules(accesses, RuleOptions{})
+	return out, err
+}
+
+// BuildGranularSnippetsWithRules is BuildGranularSnippets with subpath/regex
+// synthesis applied to each block.
+func BuildGranularSnippetsWithRules(accesses []processor.Access, ro RuleOptions) (string, []Rule, error) {
+	b := &builder{rules: ro}
+	for _, blk := range granularBlocks(accesses) {
+		b.separate()
+		b.block(blk.operation, blk.paths)
+	}
+	out, err := b.String()
+	return out, b.report, err
+}
+
+type block struct {
+	operation string
+	paths     []string
+}
+
+// granularBlocks groups paths by the sandbox-exec operations they need, in
+// operationOrder.
+func granularBlocks(accesses []processor.Access) []block {
+	byOp := map[string][]string{}
+	for _, acc := range accesses {
+		seen := map[string]struct{}{}
+		for _, c := range acc.Categories {
+			for _, op := range categoryOperations[c] {
+				if _, ok := seen[op]; ok {
+					continue
+				}
+				seen[op] = struct{}{}
+				byOp[op] = append(byOp[op], acc.Path)
+			}
+		}
+	}
+	var out []block
+	for _, op := range operationOrder {
+		if paths := byOp[op]; len(paths) > 0 {
+			out = append(out, block{operation: op, paths: paths})
+		}
+	}
+	return out
+}
+
+// categoriesFor lists the op categories that map to a sandbox-exec operation.
+func categoriesFor(operation string) []ops.Category {
+	var out []ops.Category
+	for _, c := range append(ops.Categories(), ops.Unknown) {
+		for _, op := range categoryOperations[c] {
+			if op == operation {
+				out = append(out, c)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func TestBuildGranularSnippets(t *testing.T) {
+	accesses := []processor.Access{
+		{Path: "/etc/hosts", Categories: []ops.Category{ops.DataRead, ops.MetadataRead}},
+		{Path: "/tmp/new", Categories: []ops.Category{ops.Create, ops.DataWrite}},
+		{Path: "/tmp/old", Categories: []ops.Category{ops.Delete}},
+		{Path: "/usr/bin/tool", Categories: []ops.Category{ops.Exec}},
+		{Path: "/Users/a/file", Categories: []ops.Category{ops.XattrRead, ops.XattrWrite}},
+		{Path: "/dev/ttys000", Categories: []ops.Category{ops.Ioctl}},
+	}
+	out, err := BuildGranularSnippets(accesses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"(allow file-read-metadata\n  (literal \"/etc/hosts\")\n)",
+		"(allow file-read-data\n  (literal \"/etc/hosts\")\n  (literal \"/usr/bin/tool\")\n)",
+		"(allow file-write-create\n  (literal \"/tmp/new\")\n)",
+		"(allow file-write-data\n  (literal \"/tmp/new\")\n)",
+		"(allow file-write-unlink\n  (literal \"/tmp/old\")\n)",
+		"(allow file-read-xattr\n  (literal \"/Users/a/file\")\n)",
+		"(allow file-write-xattr\n  (literal \"/Users/a/file\")\n)",
+		"(allow file-ioctl\n  (literal \"/dev/ttys000\")\n)",
+		"(allow process-exec\n  (literal \"/usr/bin/tool\")\n)",
+	}
+	if !containsAll(out, want) {
+		t.Fatalf("granular snippet mismatch:\n%s", out)
+	}
+	if strings.Contains(out, "file-read*") || strings.Contains(out, "file-write*") {
+		t.Fatalf("granular snippet should not contain wildcard operations:\n%s", out)
+	}
+	if strings.Index(out, "file-read-metadata") > strings.Index(out, "file-read-data") {
+		t.Fatalf("blocks out of order:\n%s", out)
+	}
+}
+
+func TestBuildGranularSnippetsUnknownFallsBackToRead(t *testing.T) {
+	out, err := BuildGranularSnippets([]processor.Access{{Path: "/x", Categories: []ops.Category{ops.Unknown}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "(allow file-read*\n  (literal \"/x\")\n)") {
+		t.Fatalf("unknown ops should fall back to file-read*:\n%s", out)
+	}
+}
@@ -0,0 +1,101 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/hokupod/fs-tracer/internal/output"
+)
+
+// Param maps a sandbox-exec parameter (passed with `sandbox-exec -D NAME=PATH`)
+// to the root path it replaces in generated rules.
+type Param struct {
+	Name string
+	Path string
+}
+
+// DefaultParams returns the standard HOME, TMPDIR and PROJECT_DIR parameters,
+// skipping empty roots.
+func DefaultParams(home, tmpdir, projectDir string) []Param {
+	var out []Param
+	for _, p := range []Param{{"HOME", home}, {"TMPDIR", tmpdir}, {"PROJECT_DIR", projectDir}} {
+		if p.Path == "" {
+			continue
+		}
+		out = append(out, Param{Name: p.Name, Path: filepath.Clean(p.Path)})
+	}
+	return out
+}
+
+// ParseParam parses a NAME=PATH command-line substitution.
+func ParseParam(s string) (Param, error) {
+	name, path, ok := strings.Cut(s, "=")
+	if !ok || name == "" || path == "" {
+		return Param{}, fmt.Errorf("invalid sandbox param %q (want NAME=PATH)", s)
+	}
+	if !filepath.IsAbs(path) {
+		return Param{}, fmt.Errorf("sandbox param %s must be an absolute path: %q", name, path)
+	}
+	return Param{Name: name, Path: filepath.Clean(path)}, nil
+}
+
+// Invocation renders the sandbox-exec command line that supplies params.
+// Roots under /var, /tmp and /etc are passed in their /private form, since
+// the sandbox matches rules against resolved paths.
+func Invocation(params []Param, command []string) string {
+	parts := []string{"sandbox-exec"}
+	for _, p := range params {
+		parts = append(parts, "-D", output.ShellJoin([]string{p.Name + "=" + privatePath(p.Path)}))
+	}
+	parts = append(parts, "-f", "profile.sb")
+	if len(command) > 0 {
+		parts = append(parts, output.ShellJoin(command))
+	}
+	return strings.Join(parts, " ")
+}
+
+// matchParam returns the param whose root is the longest prefix of path and
+// the remainder after it. fs_usage reports /var, /tmp and /etc through their
+// /private targets, so roots under those aliases match both spellings.
+func matchParam(path string, params []Param) (Param, string, bool) {
+	var (
+		best     Param
+		bestRest string
+		bestLen  = -1
+	)
+	for _, p := range params {
+		for _, root := range rootSpellings(p.Path) {
+			if path != root && !strings.HasPrefix(path, root+"/") {
+				continue
+			}
+			if len(root) > bestLen {
+				best, bestRest, bestLen = p, strings.TrimPrefix(path, root), len(root)
+			}
+		}
+	}
+	return best, bestRest, bestLen >= 0
+}
+
+func rootSpellings(root string) []string {
+	for _, alias := range []string{"/var", "/tmp", "/etc"} {
+		if root == alias || strings.HasPrefix(root, alias+"/") {
+			return []string{root, "/private" + root}
+		}
+		if private := "/private" + alias; root == private || strings.HasPrefix(root, private+"/") {
+			return []string{root, strings.TrimPrefix(root, "/private")}
+		}
+	}
+	return []string{root}
+}
+
+// privatePath returns root through /private when it lies under one of the
+// /var, /tmp and /etc symlinks.
+func privatePath(root string) string {
+	for _, alias := range []string{"/var", "/tmp", "/etc"} {
+		if root == alias || strings.HasPrefix(root, alias+"/") {
+			return "/private" + root
+		}
+	}
+	return root
+}
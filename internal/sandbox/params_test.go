@@ -0,0 +1,80 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSnippetsWithParams(t *testing.T) {
+	params := DefaultParams("/Users/alice", "/var/folders/xx/T/", "/Users/alice/src/proj")
+	reads := []string{"/Users/alice/.gitconfig", "/Users/alice/src/proj/go.mod", "/private/var/folders/xx/T/build.1", "/etc/hosts"}
+	out, _, err := BuildSnippetsWithRules(reads, []string{"/Users/alice"}, RuleOptions{Params: params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		";; sandbox-exec -D HOME=/Users/alice -D TMPDIR=/private/var/folders/xx/T -D PROJECT_DIR=/Users/alice/src/proj -f profile.sb",
+		`(literal (string-append (param "HOME") "/.gitconfig"))`,
+		`(literal (string-append (param "PROJECT_DIR") "/go.mod"))`,
+		`(literal (string-append (param "TMPDIR") "/build.1"))`,
+		`(literal "/etc/hosts")`,
+		`(literal (param "HOME"))`,
+	}
+	if !containsAll(out, want) {
+		t.Fatalf("parameterized snippet mismatch:\n%s", out)
+	}
+	if strings.Contains(out, `"/Users/alice`) {
+		t.Fatalf("home path leaked into snippet:\n%s", out)
+	}
+}
+
+func TestRegexRulesAvoidParamRoots(t *testing.T) {
+	reads := []string{"/Users/alice/.cache/log.1", "/Users/alice/.cache/log.22", "/opt/log.1", "/opt/log.22"}
+	out, report, err := BuildSnippetsWithRules(reads, nil, RuleOptions{Regex: true, Params: []Param{{"HOME", "/Users/alice"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsAll(out, []string{`(subpath (string-append (param "HOME") "/.cache"))`, `(regex #"^/opt/log\.[0-9]+$")`}) ||
+		strings.Contains(out, "^/Users/alice") {
+		t.Fatalf("regex under a param root not replaced:\n%s", out)
+	}
+	notes := ruleNotes(report)
+	if len(notes) != 1 || !strings.Contains(notes[0], "would hard-code the HOME root") {
+		t.Fatalf("notes = %q", notes)
+	}
+}
+
+func TestInvocationPrivateRoots(t *testing.T) {
+	got := Invocation([]Param{{"TMPDIR", "/var/folders/xx/T"}, {"SCRATCH", "/private/tmp/s"}, {"ETC", "/etc"}, {"VARIANT", "/variant"}}, nil)
+	want := "sandbox-exec -D TMPDIR=/private/var/folders/xx/T -D SCRATCH=/private/tmp/s -D ETC=/private/etc -D VARIANT=/variant -f profile.sb"
+	if got != want {
+		t.Fatalf("Invocation = %q\nwant %q", got, want)
+	}
+}
+
+func TestParseParam(t *testing.T) {
+	p, err := ParseParam("CACHE=/Users/alice/Library/Caches/")
+	if err != nil || p.Name != "CACHE" || p.Path != "/Users/alice/Library/Caches" {
+		t.Fatalf("ParseParam = %+v, %v", p, err)
+	}
+	for _, bad := range []string{"CACHE", "=/x", "CACHE=relative"} {
+		if _, err := ParseParam(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestBuildProfileParamsInvocation(t *testing.T) {
+	cfg := sampleProfileConfig()
+	cfg.Rules = RuleOptions{Params: []Param{{Name: "BIN", Path: "/usr/local/bin"}}}
+	out, _, err := BuildProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsAll(out, []string{
+		";; run: sandbox-exec -D BIN=/usr/local/bin -f profile.sb mytool --config 'my file.yml'",
+		`(allow process-exec (literal (string-append (param "BIN") "/mytool")))`,
+	}) {
+		t.Fatalf("profile params mismatch:\n%s", out)
+	}
+}
@@ -0,0 +1,177 @@
+package sandbox
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// Base selects the default stance of a generated profile.
+type Base string
+
+const (
+	// BaseDenyDefault denies everything and allows only what was observed.
+	BaseDenyDefault Base = "deny-default"
+	// BaseAllowDefault allows everything but denies writes outside the observed set.
+	BaseAllowDefault Base = "allow-default"
+)
+
+// ParseBase validates a --sandbox-base value; empty selects BaseDenyDefault.
+func ParseBase(s string) (Base, error) {
+	switch Base(s) {
+	case "", BaseDenyDefault:
+		return BaseDenyDefault, nil
+	case BaseAllowDefault:
+		return BaseAllowDefault, nil
+	default:
+		return "", fmt.Errorf("unknown sandbox base %q (want %s or %s)", s, BaseDenyDefault, BaseAllowDefault)
+	}
+}
+
+// ProfileConfig describes a complete sandbox-exec profile.
+type ProfileConfig struct {
+	Base       Base
+	Command    []string
+	Executable string
+	// Execs lists the other binaries the trace executed, such as children
+	// started by Executable; deny-default profiles allow executing them too.
+	Execs          []string
+	TracedAt       time.Time
+	Reads          []string
+	Writes         []string
+	Accesses       []processor.Access
+	LeastPrivilege bool
+	// Network allows network access under a deny-default base; set it when
+	// the trace shows socket activity.
+	Network bool
+	Rules   RuleOptions
+	// Launch holds the fs-tracer flags that recreated the command's
+	// environment; empty omits the header line.
+	Launch []string
+}
+
+// BuildProfile renders a runnable .sb profile: a header recording the traced
+// command, the base template, and one commented section per access category.
+// It also returns the rules synthesized according to cfg.Rules.
+func BuildProfile(cfg ProfileConfig) (string, []Rule, error) {
+	b := &builder{rules: cfg.Rules}
+	writeHeader(b, cfg)
+	b.add(list(sym("version"), sym("1")))
+
+	if cfg.Base == BaseAllowDefault {
+		b.add(list(sym("allow"), sym("default")))
+		b.add(Expr{Kind: ExprBlank}, comment("Writes are denied except for the paths observed during the trace."))
+		b.add(list(sym("deny"), sym("file-write*")))
+		writeFileSections(b, cfg, true)
+		return b.profile()
+	}
+
+	b.add(list(sym("deny"), sym("default")))
+	b.add(list(sym("import"), str("system.sb")))
+	b.section("process")
+	b.add(list(sym("allow"), sym("process-fork")))
+	b.add(list(sym("allow"), sym("signal"), list(sym("target"), sym("self"))))
+	if execs := execPaths(cfg); len(execs) > 0 {
+		e := list(sym("allow"), sym("process-exec"))
+		e.Block = len(execs) > 1
+		for _, p := range execs {
+			e.List = append(e.List, b.filter(Rule{Kind: RuleLiteral, Value: p}))
+		}
+		b.add(e)
+	}
+	b.section("system")
+	b.add(list(sym("allow"), sym("sysctl-read")))
+	b.add(list(sym("allow"), sym("mach-lookup")))
+	if cfg.Network {
+		b.section("network")
+		b.add(list(sym("allow"), sym("network*")))
+	}
+	writeFileSections(b, cfg, false)
+	return b.profile()
+}
+
+// profile returns the finished profile document and its synthesized rules.
+func (b *builder) profile() (string, []Rule, error) {
+	out, err := b.String()
+	if err != nil {
+		return "", nil, err
+	}
+	return out + "\n", b.report, nil
+}
+
+// execPaths returns Executable followed by the other traced execs, sorted
+// and without duplicates.
+func execPaths(cfg ProfileConfig) []string {
+	seen := map[string]struct{}{}
+	var out, rest []string
+	if cfg.Executable != "" {
+		out = append(out, cfg.Executable)
+		seen[cfg.Executable] = struct{}{}
+	}
+	for _, p := range cfg.Execs {
+		if _, ok := seen[p]; !ok {
+			seen[p] = struct{}{}
+			rest = append(rest, p)
+		}
+	}
+	sort.Strings(rest)
+	return append(out, rest...)
+}
+
+func writeHeader(b *builder, cfg ProfileConfig) {
+	b.add(comment("Generated by fs-tracer"))
+	if len(cfg.Command) > 0 {
+		b.add(comment("command: " + output.ShellJoin(cfg.Command)))
+	}
+	if len(cfg.Launch) > 0 {
+		b.add(comment("launch: " + output.ShellJoin(cfg.Launch)))
+	}
+	if !cfg.TracedAt.IsZero() {
+		b.add(comment("traced: " + cfg.TracedAt.Format(time.RFC3339)))
+	}
+	b.add(comment(fmt.Sprintf("base: %s", cfg.Base)))
+	if len(cfg.Command) > 0 {
+		b.add(comment("run: " + Invocation(cfg.Rules.Params, cfg.Command)))
+	}
+	b.add(Expr{Kind: ExprBlank})
+}
+
+// writeFileSections emits the observed file rules. writesOnly skips read rules,
+// which are redundant under an allow-default base.
+func writeFileSections(b *builder, cfg ProfileConfig, writesOnly bool) {
+	if cfg.LeastPrivilege {
+		for _, blk := range granularBlocks(cfg.Accesses) {
+			if writesOnly && !isWriteOperation(blk.operation) {
+				continue
+			}
+			b.section(blk.operation + ": " + joinCategories(categoriesFor(blk.operation)))
+			b.block(blk.operation, blk.paths)
+		}
+		return
+	}
+	if len(cfg.Reads) > 0 && !writesOnly {
+		b.section("file reads")
+		b.block("file-read*", cfg.Reads)
+	}
+	if len(cfg.Writes) > 0 {
+		b.section("file writes")
+		b.block("file-write*", cfg.Writes)
+	}
+}
+
+func isWriteOperation(op string) bool {
+	return strings.HasPrefix(op, "file-write")
+}
+
+func joinCategories(cats []ops.Category) string {
+	names := make([]string, 0, len(cats))
+	for _, c := range cats {
+		names = append(names, string(c))
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,104 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func sampleProfileConfig() ProfileConfig {
+	return ProfileConfig{
+		Base:       BaseDenyDefault,
+		Command:    []string{"mytool", "--config", "my file.yml"},
+		Executable: "/usr/local/bin/mytool",
+		TracedAt:   time.Date(2025, time.November, 29, 10, 0, 0, 0, time.UTC),
+		Reads:      []string{"/etc/hosts"},
+		Writes:     []string{"/tmp/out"},
+	}
+}
+
+func TestBuildProfileDenyDefault(t *testing.T) {
+	out, _, err := BuildProfile(sampleProfileConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		";; command: mytool --config 'my file.yml'",
+		";; traced: 2025-11-29T10:00:00Z",
+		"(version 1)\n(deny default)\n(import \"system.sb\")",
+		"(allow process-fork)",
+		"(allow process-exec (literal \"/usr/local/bin/mytool\"))",
+		"(allow sysctl-read)",
+		"(allow mach-lookup)",
+		";; --- file reads ---\n(allow file-read*\n  (literal \"/etc/hosts\")\n)",
+		";; --- file writes ---\n(allow file-write*\n  (literal \"/tmp/out\")\n)",
+	}
+	if !containsAll(out, want) {
+		t.Fatalf("profile missing content:\n%s", out)
+	}
+	if !strings.HasPrefix(out, ";; Generated by fs-tracer\n") {
+		t.Fatalf("header missing:\n%s", out)
+	}
+}
+
+func TestBuildProfileAllowsChildExecs(t *testing.T) {
+	cfg := sampleProfileConfig()
+	cfg.Execs = []string{"/usr/local/bin/mytool", "/usr/bin/git", "/bin/sh"}
+	out, _, err := BuildProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "(allow process-exec\n  (literal \"/usr/local/bin/mytool\")\n  (literal \"/bin/sh\")\n  (literal \"/usr/bin/git\")\n)"
+	if !strings.Contains(out, want) {
+		t.Fatalf("child execs not allowed:\n%s", out)
+	}
+}
+
+func TestBuildProfileAllowDefault(t *testing.T) {
+	cfg := sampleProfileConfig()
+	cfg.Base = BaseAllowDefault
+	out, _, err := BuildProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsAll(out, []string{"(allow default)", "(deny file-write*)", "(allow file-write*\n  (literal \"/tmp/out\")"}) {
+		t.Fatalf("allow-default profile missing content:\n%s", out)
+	}
+	if strings.Contains(out, "(deny default)") || strings.Contains(out, "file-read*") {
+		t.Fatalf("allow-default profile should not deny by default or list reads:\n%s", out)
+	}
+	if strings.Index(out, "(deny file-write*)") > strings.Index(out, "(allow file-write*") {
+		t.Fatalf("deny must precede allow so observed writes win:\n%s", out)
+	}
+}
+
+func TestBuildProfileLeastPrivilegeSections(t *testing.T) {
+	cfg := sampleProfileConfig()
+	cfg.LeastPrivilege = true
+	cfg.Accesses = []processor.Access{
+		{Path: "/etc/hosts", Categories: []ops.Category{ops.MetadataRead}},
+		{Path: "/tmp/out", Categories: []ops.Category{ops.Create}},
+	}
+	out, _, err := BuildProfile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsAll(out, []string{
+		";; --- file-read-metadata: metadata-read ---\n(allow file-read-metadata",
+		";; --- file-write-create: create ---\n(allow file-write-create",
+	}) {
+		t.Fatalf("least-privilege sections missing:\n%s", out)
+	}
+}
+
+func TestParseBase(t *testing.T) {
+	if b, err := ParseBase(""); err != nil || b != BaseDenyDefault {
+		t.Fatalf("empty base = %q, %v", b, err)
+	}
+	if _, err := ParseBase("permissive"); err == nil {
+		t.Fatalf("expected error for unknown base")
+	}
+}
@@ -0,0 +1,439 @@
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ExprKind distinguishes the node types of a parsed SBPL profile.
+type ExprKind int
+
+const (
+	ExprList ExprKind = iota
+	ExprSymbol
+	ExprString
+	ExprRegex
+	// ExprComment and ExprBlank only exist in generated documents: a top-level
+	// ";;" comment line and an empty separator line. Parse never returns them.
+	ExprComment
+	ExprBlank
+)
+
+// Expr is a node of an SBPL s-expression. Symbols, strings and regex literals
+// carry Value; lists carry List. Line and the source offsets point back into
+// the parsed text for error messages and reports.
+type Expr struct {
+	Kind  ExprKind
+	Value string
+	List  []Expr
+	Line  int
+	Start int
+	End   int
+	// Block prints a list with its leading symbols on the first line and each
+	// remaining element on its own indented line.
+	Block bool
+	// Comment is printed after the node on the same line.
+	Comment string
+}
+
+func sym(s string) Expr     { return Expr{Kind: ExprSymbol, Value: s} }
+func str(s string) Expr     { return Expr{Kind: ExprString, Value: s} }
+func regex(s string) Expr   { return Expr{Kind: ExprRegex, Value: s} }
+func comment(s string) Expr { return Expr{Kind: ExprComment, Value: s} }
+func list(items ...Expr) Expr {
+	return Expr{Kind: ExprList, List: items}
+}
+
+// Head returns the symbol at the start of a list, or "".
+func (e Expr) Head() string {
+	if e.Kind != ExprList || len(e.List) == 0 || e.List[0].Kind != ExprSymbol {
+		return ""
+	}
+	return e.List[0].Value
+}
+
+// Parse reads SBPL source into top-level expressions. It understands line
+// comments (;), block comments (#| |#), strings with Scheme escapes and
+// regex literals (#"...").
+func Parse(src string) ([]Expr, error) {
+	p := &parser{src: src, line: 1}
+	var out []Expr
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) {
+			return out, nil
+		}
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+}
+
+type parser struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("sbpl line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f':
+			p.pos++
+		case c == ';':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case strings.HasPrefix(p.src[p.pos:], "#|"):
+			end := strings.Index(p.src[p.pos+2:], "|#")
+			if end < 0 {
+				p.line += strings.Count(p.src[p.pos:], "\n")
+				p.pos = len(p.src)
+				return
+			}
+			p.line += strings.Count(p.src[p.pos:p.pos+2+end], "\n")
+			p.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) expr() (Expr, error) {
+	start, line := p.pos, p.line
+	switch c := p.src[p.pos]; {
+	case c == '(':
+		p.pos++
+		e := Expr{Kind: ExprList, Line: line, Start: start}
+		for {
+			p.skipSpace()
+			if p.pos >= len(p.src) {
+				return Expr{}, fmt.Errorf("sbpl line %d: unterminated list", line)
+			}
+			if p.src[p.pos] == ')' {
+				p.pos++
+				e.End = p.pos
+				return e, nil
+			}
+			child, err := p.expr()
+			if err != nil {
+				return Expr{}, err
+			}
+			e.List = append(e.List, child)
+		}
+	case c == ')':
+		return Expr{}, p.errorf("unexpected )")
+	case c == '"':
+		s, err := p.str(false)
+		if err != nil {
+			return Expr{}, err
+		}
+		return Expr{Kind: ExprString, Value: s, Line: line, Start: start, End: p.pos}, nil
+	case strings.HasPrefix(p.src[p.pos:], `#"`):
+		p.pos++
+		s, err := p.str(true)
+		if err != nil {
+			return Expr{}, err
+		}
+		return Expr{Kind: ExprRegex, Value: s, Line: line, Start: start, End: p.pos}, nil
+	default:
+		for p.pos < len(p.src) && !isDelimiter(p.src[p.pos]) {
+			p.pos++
+		}
+		return Expr{Kind: ExprSymbol, Value: p.src[start:p.pos], Line: line, Start: start, End: p.pos}, nil
+	}
+}
+
+// str reads a double-quoted string starting at p.pos. Regex literals keep
+// backslashes verbatim (they belong to the regex) except before a quote.
+func (p *parser) str(regex bool) (string, error) {
+	line := p.line
+	p.pos++ // opening quote
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return b.String(), nil
+		case '\n':
+			p.line++
+		case '\\':
+			if p.pos+1 >= len(p.src) {
+				return "", fmt.Errorf("sbpl line %d: unterminated string", line)
+			}
+			next := p.src[p.pos+1]
+			if regex {
+				if next != '"' {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(next)
+				p.pos += 2
+				continue
+			}
+			n, err := p.escape(&b)
+			if err != nil {
+				return "", err
+			}
+			p.pos += n
+			continue
+		}
+		b.WriteByte(c)
+		p.pos++
+	}
+	return "", fmt.Errorf("sbpl line %d: unterminated string", line)
+}
+
+// escape decodes the escape sequence at p.pos into b and returns its length.
+func (p *parser) escape(b *strings.Builder) (int, error) {
+	next := p.src[p.pos+1]
+	switch next {
+	case 'n':
+		b.WriteByte('\n')
+	case 't':
+		b.WriteByte('\t')
+	case 'r':
+		b.WriteByte('\r')
+	case '0':
+		b.WriteByte(0)
+	case '\\', '"':
+		b.WriteByte(next)
+	case '\n':
+		// Line continuation.
+		p.line++
+	case 'x':
+		// TinyScheme reads at most two hex digits after \x.
+		end := p.pos + 2
+		for end < len(p.src) && end < p.pos+4 && isHexDigit(p.src[end]) {
+			end++
+		}
+		if end == p.pos+2 {
+			return 0, p.errorf("invalid \\x escape")
+		}
+		v, err := strconv.ParseUint(p.src[p.pos+2:end], 16, 8)
+		if err != nil {
+			return 0, p.errorf("invalid \\x escape: %v", err)
+		}
+		b.WriteByte(byte(v))
+		return end - p.pos, nil
+	default:
+		return 0, p.errorf("unknown escape \\%c", next)
+	}
+	return 2, nil
+}
+
+func isDelimiter(c byte) bool {
+	return c == '(' || c == ')' || c == '"' || c == ';' || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Print renders a document. Strings are escaped so that Parse reads back the
+// exact bytes; comment text spanning several lines gets one ";;" per line.
+func Print(doc []Expr) string {
+	var buf bytes.Buffer
+	for _, e := range doc {
+		switch e.Kind {
+		case ExprBlank:
+		case ExprComment:
+			for i, line := range strings.Split(e.Value, "\n") {
+				if i > 0 {
+					buf.WriteByte('\n')
+				}
+				buf.WriteString(";; ")
+				buf.WriteString(line)
+			}
+		default:
+			printExpr(&buf, e, "")
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func printExpr(buf *bytes.Buffer, e Expr, indent string) {
+	switch e.Kind {
+	case ExprSymbol:
+		buf.WriteString(e.Value)
+	case ExprString:
+		buf.WriteString(quoteString(e.Value))
+	case ExprRegex:
+		buf.WriteString(quoteRegex(e.Value))
+	case ExprList:
+		buf.WriteByte('(')
+		i := 0
+		for ; i < len(e.List) && (!e.Block || e.List[i].Kind == ExprSymbol); i++ {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			printExpr(buf, e.List[i], indent)
+		}
+		if e.Block {
+			for _, child := range e.List[i:] {
+				buf.WriteByte('\n')
+				buf.WriteString(indent + "  ")
+				printExpr(buf, child, indent+"  ")
+			}
+			buf.WriteByte('\n')
+			buf.WriteString(indent)
+		}
+		buf.WriteByte(')')
+	}
+	if e.Comment != "" {
+		buf.WriteString(" ; ")
+		buf.WriteString(strings.Join(strings.Fields(e.Comment), " "))
+	}
+}
+
+// quoteString renders s as an SBPL string literal. Backslash, quote and the
+// common whitespace escapes use their short forms; other control bytes use \xHH.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\x%02x`, c)
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// quoteRegex renders s as a #"..." literal. Regex escapes pass through
+// untouched; only quotes need escaping because the reader keeps other
+// backslash pairs verbatim.
+func quoteRegex(s string) string {
+	var b strings.Builder
+	b.WriteString(`#"`)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && s[i+1] != '"':
+			b.WriteString(s[i : i+2])
+			i++
+		case c == '\\' && i+1 < len(s):
+			// \" matches a quote, which is what the reader yields for \".
+			b.WriteString(`\"`)
+			i++
+		case c == '\\':
+			// A trailing backslash would escape the closing quote.
+			b.WriteString(`\\`)
+		case c == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Validate parses src and checks that it has the same forms as doc, ignoring
+// comments, blank lines and layout.
+func Validate(src string, doc []Expr) error {
+	got, err := Parse(src)
+	if err != nil {
+		return err
+	}
+	want := stripComments(doc)
+	if len(got) != len(want) {
+		return fmt.Errorf("sbpl round trip: %d forms, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if err := sameExpr(got[i], want[i]); err != nil {
+			return fmt.Errorf("sbpl round trip, line %d: %w", got[i].Line, err)
+		}
+	}
+	return nil
+}
+
+func stripComments(doc []Expr) []Expr {
+	var out []Expr
+	for _, e := range doc {
+		if e.Kind != ExprComment && e.Kind != ExprBlank {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func sameExpr(got, want Expr) error {
+	if got.Kind != want.Kind {
+		return fmt.Errorf("node kind %d, want %d", got.Kind, want.Kind)
+	}
+	if got.Value != want.Value {
+		if want.Kind == ExprRegex && regexEquivalent(got.Value, want.Value) {
+			return nil
+		}
+		return fmt.Errorf("value %q, want %q", got.Value, want.Value)
+	}
+	if len(got.List) != len(want.List) {
+		return fmt.Errorf("list of %d elements, want %d", len(got.List), len(want.List))
+	}
+	for i := range want.List {
+		if err := sameExpr(got.List[i], want.List[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regexEquivalent accepts the rewrites quoteRegex makes: \" reads back as a
+// bare quote and a trailing lone backslash is doubled.
+func regexEquivalent(got, want string) bool {
+	var b strings.Builder
+	for i := 0; i < len(want); i++ {
+		switch {
+		case want[i] == '\\' && i+1 < len(want) && want[i+1] == '"':
+			b.WriteByte('"')
+			i++
+		case want[i] == '\\' && i+1 < len(want):
+			b.WriteString(want[i : i+2])
+			i++
+		case want[i] == '\\':
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(want[i])
+		}
+	}
+	return got == b.String()
+}
+
+// format prints doc and verifies that the output parses back to the same
+// forms. Builders construct documents from escaped values only, so a failure
+// is a printer bug rather than bad input.
+func format(doc []Expr) (string, error) {
+	out := Print(doc)
+	if err := Validate(out, doc); err != nil {
+		return "", fmt.Errorf("sandbox: generated profile does not round-trip: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,127 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	src := `;; header comment
+(version 1)
+#| block
+comment |#
+(allow file-read* (literal "/a \"b\"\n") (regex #"^/tmp/x\.[0-9]+$"))
+`
+	exprs, err := Parse(src)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if len(exprs) != 2 {
+		t.Fatalf("expected 2 forms, got %d", len(exprs))
+	}
+	if exprs[0].Head() != "version" || exprs[0].List[1].Value != "1" {
+		t.Fatalf("version form mismatch: %+v", exprs[0])
+	}
+	rule := exprs[1]
+	if rule.Head() != "allow" || rule.Line != 5 {
+		t.Fatalf("allow form mismatch: head=%q line=%d", rule.Head(), rule.Line)
+	}
+	lit := rule.List[2].List[1]
+	if lit.Kind != ExprString || lit.Value != "/a \"b\"\n" {
+		t.Fatalf("string escape mismatch: %q", lit.Value)
+	}
+	re := rule.List[3].List[1]
+	if re.Kind != ExprRegex || re.Value != `^/tmp/x\.[0-9]+$` {
+		t.Fatalf("regex literal mismatch: %q", re.Value)
+	}
+}
+
+func TestParseHexEscape(t *testing.T) {
+	exprs, err := Parse(`"a\x7fb\x01c\x9"`)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if exprs[0].Value != "a\x7fb\x01c\x09" {
+		t.Fatalf("hex escape mismatch: %q", exprs[0].Value)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{`(allow`, `)`, `"open`, `"bad \q"`} {
+		if _, err := Parse(src); err == nil {
+			t.Fatalf("expected error for %q", src)
+		}
+	}
+}
+
+func TestPrintRoundTrip(t *testing.T) {
+	values := []string{
+		`/plain`,
+		`/back\slash`,
+		`/quote"d`,
+		"/new\nline",
+		"/tab\tand\rcr",
+		"/ctl\x00\x01\x1f\x7f",
+		"/hex\x01f",
+		"/utf8/日本語",
+	}
+	for _, v := range values {
+		doc := []Expr{
+			comment("path:\n" + v),
+			list(sym("allow"), sym("file-read*"), list(sym("literal"), str(v))),
+		}
+		src := Print(doc)
+		if err := Validate(src, doc); err != nil {
+			t.Fatalf("round trip failed for %q: %v\n%s", v, err, src)
+		}
+	}
+}
+
+func TestPrintRegexRoundTrip(t *testing.T) {
+	for _, v := range []string{`^/tmp/x\.[0-9]+$`, `^/a"b$`, `^/a\"b$`, `^/a\\$`, `^/a\`} {
+		doc := []Expr{list(sym("regex"), regex(v))}
+		if err := Validate(Print(doc), doc); err != nil {
+			t.Fatalf("round trip failed for %q: %v", v, err)
+		}
+	}
+}
+
+func TestPrintEscapes(t *testing.T) {
+	got := Print([]Expr{str("a\\b\"c\nd\te\rf\x01g")})
+	want := `"a\\b\"c\nd\te\rf\x01g"` + "\n"
+	if got != want {
+		t.Fatalf("escaped string mismatch:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestPrintBlock(t *testing.T) {
+	e := list(sym("allow"), sym("file-read*"), list(sym("literal"), str("/a")), list(sym("subpath"), str("/b")))
+	e.Block = true
+	e.List[3].Comment = "3 paths"
+	got := Print([]Expr{comment("header"), {Kind: ExprBlank}, e})
+	want := ";; header\n\n(allow file-read*\n  (literal \"/a\")\n  (subpath \"/b\") ; 3 paths\n)\n"
+	if got != want {
+		t.Fatalf("block layout mismatch:\n%s", got)
+	}
+}
+
+func TestValidateMismatch(t *testing.T) {
+	doc := []Expr{list(sym("allow"), sym("file-read*"), list(sym("literal"), str("/a\"b")))}
+	if err := Validate(`(allow file-read* (literal "/a"))`, doc); err == nil {
+		t.Fatal("expected value mismatch")
+	}
+	if err := Validate(`(allow file-read* (literal "/a\"b")) (version 1)`, doc); err == nil {
+		t.Fatal("expected form count mismatch")
+	}
+	// Unescaped output is what the validator guards against.
+	if err := Validate(`(allow file-read* (literal "/a"b"))`, doc); err == nil {
+		t.Fatal("expected unescaped quote to be rejected")
+	}
+}
+
+func TestFormatReportsRoundTripFailure(t *testing.T) {
+	// A symbol cannot hold a space, so it prints as two symbols.
+	if _, err := format([]Expr{list(sym("allow"), sym("file read"))}); err == nil || !strings.Contains(err.Error(), "does not round-trip") {
+		t.Fatalf("format error = %v", err)
+	}
+}
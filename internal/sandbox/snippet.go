@@ -2,39 +2,113 @@ package sandbox
 
 import (
 	"bytes"
-	"sort"
+	"fmt"
 	"strings"
 )
 
 // BuildSnippets converts read/write path sets into sandbox-exec S expressions.
-func BuildSnippets(reads, writes []string) string {
-	var buf bytes.Buffer
+func BuildSnippets(reads, writes []string) (string, error) {
+	out, _, err := BuildSnippetsWithRules(reads, writes, RuleOptions{})
+	return out, err
+}
+
+// BuildSnippetsWithRules is BuildSnippets with subpath/regex synthesis. It also
+// returns the synthesized rules so callers can report what they absorbed.
+func BuildSnippetsWithRules(reads, writes []string, ro RuleOptions) (string, []Rule, error) {
+	b := &builder{rules: ro}
+	b.paramComment()
 	if len(reads) > 0 {
-		writeBlock(&buf, "file-read*", reads)
+		b.block("file-read*", reads)
 	}
 	if len(writes) > 0 {
-		if buf.Len() > 0 {
+		b.separate()
+		b.block("file-write*", writes)
+	}
+	out, err := b.String()
+	return out, b.report, err
+}
+
+// FormatReport lists each synthesized rule with the literals it absorbed.
+func FormatReport(rules []Rule) string {
+	var buf bytes.Buffer
+	for _, r := range rules {
+		fmt.Fprintf(&buf, "%s %q absorbed %d literals:\n", r.Kind, r.Value, len(r.Absorbed))
+		for _, p := range r.Absorbed {
+			buf.WriteString("  ")
+			buf.WriteString(p)
 			buf.WriteByte('\n')
 		}
-		writeBlock(&buf, "file-write*", writes)
 	}
-	return strings.TrimSpace(buf.String())
+	return strings.TrimSuffix(buf.String(), "\n")
 }
 
-func writeBlock(buf *bytes.Buffer, perm string, paths []string) {
-	sorted := append([]string(nil), paths...)
-	sort.Strings(sorted)
-	buf.WriteString("(allow ")
-	buf.WriteString(perm)
-	buf.WriteByte('\n')
-	for _, p := range sorted {
-		buf.WriteString("  (literal \"")
-		buf.WriteString(escapeLiteral(p))
-		buf.WriteString("\")\n")
+// builder accumulates a profile document and the rules synthesized for it.
+// All output goes through Print, so values never need escaping by hand.
+type builder struct {
+	doc    []Expr
+	rules  RuleOptions
+	report []Rule
+}
+
+func (b *builder) add(e ...Expr) {
+	b.doc = append(b.doc, e...)
+}
+
+// separate adds a blank line unless the document is still empty.
+func (b *builder) separate() {
+	if len(b.doc) > 0 {
+		b.add(Expr{Kind: ExprBlank})
+	}
+}
+
+// section starts a commented group of forms.
+func (b *builder) section(title string) {
+	b.add(Expr{Kind: ExprBlank}, comment("--- "+title+" ---"))
+}
+
+func (b *builder) block(perm string, paths []string) {
+	rules := Synthesize(paths, b.rules)
+	b.report = append(b.report, Synthesized(rules)...)
+	e := list(sym("allow"), sym(perm))
+	e.Block = true
+	for _, r := range rules {
+		f := b.filter(r)
+		if len(r.Absorbed) > 0 {
+			f.Comment = fmt.Sprintf("%d paths", len(r.Absorbed))
+		}
+		e.List = append(e.List, f)
+	}
+	b.add(e)
+}
+
+// paramComment records the sandbox-exec invocation that supplies the
+// configured params, so a pasted snippet stays self-describing.
+func (b *builder) paramComment() {
+	if len(b.rules.Params) == 0 {
+		return
+	}
+	b.add(comment(Invocation(b.rules.Params, nil)))
+}
+
+// filter builds a single path filter, substituting params where a root matches.
+// Regex rules are left as-is because sandbox-exec regexes must be literals;
+// Synthesize keeps them clear of param roots.
+func (b *builder) filter(r Rule) Expr {
+	if r.Kind == RuleRegex {
+		return list(sym("regex"), regex(r.Value))
+	}
+	value := str(r.Value)
+	if p, rest, ok := matchParam(r.Value, b.rules.Params); ok {
+		value = list(sym("param"), str(p.Name))
+		if rest != "" {
+			value = list(sym("string-append"), value, str(rest))
+		}
 	}
-	buf.WriteString(")\n")
+	return list(sym(string(r.Kind)), value)
 }
 
-func escapeLiteral(s string) string {
-	return strings.ReplaceAll(s, "\"", "\\\"")
+// String prints the document, checked to parse back to the same forms.
+func (b *builder) String() (string, error) {
+	out, err := format(b.doc)
+	return strings.TrimSpace(out), err
 }
@@ -8,14 +8,20 @@ import (
 func TestBuildSnippets(t *testing.T) {
 	read := []string{"/etc/hosts", "/etc/resolv.conf"}
 	write := []string{"/tmp/out.log"}
-	out := BuildSnippets(read, write)
+	out, err := BuildSnippets(read, write)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !containsAll(out, []string{"file-read*", "(literal \"/etc/hosts\")", "(literal \"/etc/resolv.conf\")", "file-write*", "(literal \"/tmp/out.log\")"}) {
 		t.Fatalf("snippet missing expected content:\n%s", out)
 	}
 }
 
 func TestBuildSnippetsReadOnly(t *testing.T) {
-	out := BuildSnippets([]string{"/a"}, nil)
+	out, err := BuildSnippets([]string{"/a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !containsAll(out, []string{"file-read*", "(literal \"/a\")"}) {
 		t.Fatalf("read-only snippet incorrect: %s", out)
 	}
@@ -32,3 +38,20 @@ func containsAll(s string, subs []string) bool {
 	}
 	return true
 }
+
+func TestBuildSnippetsEscapesPaths(t *testing.T) {
+	out, err := BuildSnippets([]string{`/a\b`, "/new\nline", `/q"uote`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsAll(out, []string{`(literal "/a\\b")`, `(literal "/new\nline")`, `(literal "/q\"uote")`}) {
+		t.Fatalf("paths not escaped:\n%s", out)
+	}
+	exprs, err := Parse(out)
+	if err != nil {
+		t.Fatalf("snippet does not parse: %v\n%s", err, out)
+	}
+	if got := exprs[0].List[3].List[1].Value; got != "/new\nline" {
+		t.Fatalf("round trip mismatch: %q", got)
+	}
+}
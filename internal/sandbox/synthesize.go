@@ -0,0 +1,216 @@
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// RuleKind is the sandbox-exec path filter used by a Rule.
+type RuleKind string
+
+const (
+	RuleLiteral RuleKind = "literal"
+	RuleSubpath RuleKind = "subpath"
+	RuleRegex   RuleKind = "regex"
+)
+
+// Rule is a single path filter. Synthesized rules (subpath and regex) list the
+// literal paths they replaced in Absorbed.
+type Rule struct {
+	Kind     RuleKind
+	Value    string
+	Absorbed []string
+	// Note explains a rule that replaces the one synthesis first chose; it
+	// is reported as a warning.
+	Note string
+}
+
+// RuleOptions controls how observed paths become filters. A zero value emits
+// one literal per path.
+type RuleOptions struct {
+	Collapse processor.CollapseOptions
+	// Regex replaces groups of siblings that differ only by numeric, hashed or
+	// random name segments with a single regex rule.
+	Regex bool
+	// MinRegexGroup is the smallest sibling group turned into a regex (default 2).
+	MinRegexGroup int
+	// Params replaces known roots in literal and subpath rules with
+	// (param "NAME") so profiles are portable across users and machines.
+	// sandbox-exec regexes must be literals, so a regex under a param root
+	// becomes a subpath of its directory instead.
+	Params []Param
+}
+
+// Synthesize turns paths into rules: directories crossing the collapse
+// thresholds become subpath rules, and (when enabled) sibling names sharing a
+// variable-segment template become regex rules. Remaining paths stay literal.
+func Synthesize(paths []string, opts RuleOptions) []Rule {
+	literals, groups := processor.CollapseDirs(paths, opts.Collapse)
+	var rules []Rule
+	for _, g := range groups {
+		rules = append(rules, Rule{Kind: RuleSubpath, Value: g.Dir, Absorbed: g.Absorbed})
+	}
+	if opts.Regex {
+		var regexRules []Rule
+		regexRules, literals = synthesizeRegex(literals, opts.MinRegexGroup)
+		for _, r := range regexRules {
+			rules = append(rules, portableRegex(r, opts.Params))
+		}
+	}
+	for _, p := range literals {
+		rules = append(rules, Rule{Kind: RuleLiteral, Value: p})
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return ruleSortKey(rules[i]) < ruleSortKey(rules[j]) })
+	return rules
+}
+
+// portableRegex replaces r with a subpath of its directory when the
+// directory lies under a param root, which the regex would hard-code.
+func portableRegex(r Rule, params []Param) Rule {
+	dir := filepath.Dir(r.Absorbed[0])
+	p, _, ok := matchParam(dir, params)
+	if !ok {
+		return r
+	}
+	return Rule{
+		Kind:     RuleSubpath,
+		Value:    dir,
+		Absorbed: r.Absorbed,
+		Note:     fmt.Sprintf("regex %s would hard-code the %s root; allowing subpath %s instead", r.Value, p.Name, dir),
+	}
+}
+
+// Synthesized filters rules down to the subpath and regex rules, for reporting.
+func Synthesized(rules []Rule) []Rule {
+	var out []Rule
+	for _, r := range rules {
+		if r.Kind != RuleLiteral {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func ruleSortKey(r Rule) string {
+	if len(r.Absorbed) > 0 {
+		return r.Absorbed[0]
+	}
+	return r.Value
+}
+
+var (
+	digitsRe = regexp.MustCompile(`^[0-9]+$`)
+	hexRe    = regexp.MustCompile(`^[0-9a-fA-F]{8,}$`)
+)
+
+const (
+	digitsPattern = "[0-9]+"
+	// randomPattern covers hashes and random suffixes alike so that siblings
+	// generated by the same mkstemp-style call share one template.
+	randomPattern = "[A-Za-z0-9]+"
+)
+
+// synthesizeRegex groups sibling literals by a name template and returns regex
+// rules for groups of at least minGroup members plus the literals left over.
+func synthesizeRegex(paths []string, minGroup int) ([]Rule, []string) {
+	if minGroup < 2 {
+		minGroup = 2
+	}
+	byTemplate := map[string][]string{}
+	var order []string
+	var rest []string
+	for _, p := range paths {
+		tmpl, ok := nameTemplate(p)
+		if !ok {
+			rest = append(rest, p)
+			continue
+		}
+		if _, seen := byTemplate[tmpl]; !seen {
+			order = append(order, tmpl)
+		}
+		byTemplate[tmpl] = append(byTemplate[tmpl], p)
+	}
+	var rules []Rule
+	for _, tmpl := range order {
+		members := byTemplate[tmpl]
+		if len(members) < minGroup {
+			rest = append(rest, members...)
+			continue
+		}
+		rules = append(rules, Rule{Kind: RuleRegex, Value: tmpl, Absorbed: members})
+	}
+	sort.Strings(rest)
+	return rules, rest
+}
+
+// nameTemplate builds an anchored regex for p in which numeric, hex and random
+// looking segments of the base name are wildcards. ok is false when the name has
+// no variable segment.
+func nameTemplate(p string) (string, bool) {
+	dir, name := filepath.Split(p)
+	if name == "" {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	b.WriteString(regexp.QuoteMeta(dir))
+	variable := false
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && !isSeparator(name[i]) {
+			continue
+		}
+		if tok := name[start:i]; tok != "" {
+			if pat := segmentPattern(tok); pat != "" {
+				b.WriteString(pat)
+				variable = true
+			} else {
+				b.WriteString(regexp.QuoteMeta(tok))
+			}
+		}
+		if i < len(name) {
+			b.WriteString(regexp.QuoteMeta(name[i : i+1]))
+		}
+		start = i + 1
+	}
+	b.WriteString("$")
+	return b.String(), variable
+}
+
+func isSeparator(c byte) bool {
+	return c == '.' || c == '-' || c == '_' || c == '@' || c == '+'
+}
+
+// segmentPattern returns a wildcard for segments that look generated: version
+// numbers, hashes, and random suffixes mixing letters and digits.
+func segmentPattern(tok string) string {
+	switch {
+	case digitsRe.MatchString(tok):
+		return digitsPattern
+	case hexRe.MatchString(tok), len(tok) >= 6 && hasLetterAndDigit(tok):
+		return randomPattern
+	default:
+		return ""
+	}
+}
+
+func hasLetterAndDigit(s string) bool {
+	var letter, digit bool
+	for _, r := range s {
+		switch {
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsLetter(r):
+			letter = true
+		default:
+			return false
+		}
+	}
+	return letter && digit
+}
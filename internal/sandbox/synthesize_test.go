@@ -0,0 +1,87 @@
+package sandbox
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func TestSynthesizeSubpath(t *testing.T) {
+	var paths []string
+	for i := 0; i < 10; i++ {
+		paths = append(paths, fmt.Sprintf("/Users/a/Library/Caches/foo/obj%d", i))
+	}
+	paths = append(paths, "/etc/hosts")
+	rules := Synthesize(paths, RuleOptions{Collapse: processor.CollapseOptions{MinCount: 10}})
+	if len(rules) != 2 {
+		t.Fatalf("expected subpath + literal, got %+v", rules)
+	}
+	if rules[0].Kind != RuleSubpath || rules[0].Value != "/Users/a/Library/Caches/foo" || len(rules[0].Absorbed) != 10 {
+		t.Fatalf("subpath rule mismatch: %+v", rules[0])
+	}
+	if rules[1].Kind != RuleLiteral || rules[1].Value != "/etc/hosts" {
+		t.Fatalf("literal rule mismatch: %+v", rules[1])
+	}
+}
+
+func TestSynthesizeRegex(t *testing.T) {
+	paths := []string{
+		"/tmp/build.a8F3kq9Z.log",
+		"/tmp/build.Zq71mmA2.log",
+		"/tmp/build.k2j3h4g5.log",
+		"/opt/lib/libfoo.1.2.dylib",
+		"/opt/lib/libfoo.1.3.dylib",
+		"/etc/hosts",
+	}
+	rules := Synthesize(paths, RuleOptions{Regex: true})
+	var regexes []Rule
+	for _, r := range rules {
+		if r.Kind == RuleRegex {
+			regexes = append(regexes, r)
+		}
+	}
+	if len(regexes) != 2 {
+		t.Fatalf("expected two regex rules, got %+v", rules)
+	}
+	for _, r := range regexes {
+		re := regexp.MustCompile(r.Value)
+		for _, p := range r.Absorbed {
+			if !re.MatchString(p) {
+				t.Fatalf("regex %q does not match absorbed %q", r.Value, p)
+			}
+		}
+		if re.MatchString("/etc/hosts") {
+			t.Fatalf("regex %q too broad", r.Value)
+		}
+	}
+	if got := Synthesized(rules); len(got) != 2 {
+		t.Fatalf("Synthesized should return the regex rules only, got %+v", got)
+	}
+}
+
+func TestSynthesizeRegexNeedsGroup(t *testing.T) {
+	rules := Synthesize([]string{"/tmp/only.a8F3kq9Z"}, RuleOptions{Regex: true})
+	want := []Rule{{Kind: RuleLiteral, Value: "/tmp/only.a8F3kq9Z"}}
+	if !reflect.DeepEqual(rules, want) {
+		t.Fatalf("single member should stay literal: %+v", rules)
+	}
+}
+
+func TestBuildSnippetsWithRulesReport(t *testing.T) {
+	reads := []string{"/d/a", "/d/b", "/e"}
+	out, report, err := BuildSnippetsWithRules(reads, nil, RuleOptions{Collapse: processor.CollapseOptions{MinCount: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "(subpath \"/d\") ; 2 paths") || !strings.Contains(out, "(literal \"/e\")") {
+		t.Fatalf("snippet mismatch:\n%s", out)
+	}
+	text := FormatReport(report)
+	if !strings.Contains(text, "subpath \"/d\" absorbed 2 literals:\n  /d/a\n  /d/b") {
+		t.Fatalf("report mismatch:\n%s", text)
+	}
+}
@@ -0,0 +1,182 @@
+// Package systemd turns traced read/write sets into a hardening drop-in for a
+// service unit.
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+// apiRoots stay writable kernel file systems under ProtectSystem=strict.
+var apiRoots = []string{"/proc", "/sys", "/dev"}
+
+// tmpRoots are replaced by PrivateTmp=.
+var tmpRoots = []string{"/tmp", "/var/tmp"}
+
+// inaccessibleCandidates are hidden from the service when nothing beneath them
+// was accessed.
+var inaccessibleCandidates = []string{"/home", "/root", "/run/user", "/srv", "/mnt", "/media", "/opt", "/boot"}
+
+// Config describes the traced run a drop-in is generated for.
+type Config struct {
+	Command  []string
+	TracedAt time.Time
+	Reads    []string
+	Writes   []string
+	Collapse processor.CollapseOptions
+	// Launch holds the fs-tracer flags that recreated the command's
+	// environment; empty omits the header line.
+	Launch []string
+}
+
+// Directives is the sandboxing part of a [Service] section.
+type Directives struct {
+	PrivateTmp        bool
+	ReadWritePaths    []string
+	ReadOnlyPaths     []string
+	InaccessiblePaths []string
+	// Rootless lists the traced paths left out because granting them would
+	// need a rule on /, which covers the whole filesystem.
+	Rootless []string
+}
+
+// Derive computes the directives. PrivateTmp is enabled unless the service
+// read files under /tmp or /var/tmp that it did not write itself, which means
+// it shares them with other processes. Written paths grant their parent
+// directory so files can be created, renamed into place and removed. Path
+// lists are collapsed per cfg.Collapse and reduced to their minimal roots.
+// "/" itself is never listed: as a ReadWritePaths entry it would undo
+// ProtectSystem=strict.
+func Derive(cfg Config) Directives {
+	written := map[string]struct{}{}
+	for _, p := range cfg.Writes {
+		written[p] = struct{}{}
+	}
+	d := Directives{PrivateTmp: true}
+	for _, p := range cfg.Reads {
+		if _, ok := written[p]; !ok && processor.WithinAny(p, tmpRoots) {
+			d.PrivateTmp = false
+			break
+		}
+	}
+	skip := func(p string) bool {
+		return processor.WithinAny(p, apiRoots) || (d.PrivateTmp && processor.WithinAny(p, tmpRoots))
+	}
+
+	var writeDirs []string
+	for _, p := range cfg.Writes {
+		switch dir := filepath.Dir(p); {
+		case dir == "/":
+			d.Rootless = append(d.Rootless, p)
+		case !skip(dir):
+			writeDirs = append(writeDirs, dir)
+		}
+	}
+	d.ReadWritePaths = processor.CollapsedRoots(writeDirs, cfg.Collapse)
+
+	var reads []string
+	for _, p := range cfg.Reads {
+		switch {
+		case p == "/":
+			d.Rootless = append(d.Rootless, p)
+		case !skip(p) && !processor.WithinAny(p, d.ReadWritePaths):
+			reads = append(reads, p)
+		}
+	}
+	d.ReadOnlyPaths = processor.CollapsedRoots(reads, cfg.Collapse)
+
+	all := append(append([]string(nil), cfg.Reads...), cfg.Writes...)
+	for _, c := range inaccessibleCandidates {
+		if !touches(all, c) {
+			d.InaccessiblePaths = append(d.InaccessiblePaths, c)
+		}
+	}
+	return d
+}
+
+// BuildDropIn renders a drop-in file for the traced service. The warnings
+// name the paths left out because they would need a rule on /.
+func BuildDropIn(cfg Config) (dropIn string, warnings []string) {
+	d := Derive(cfg)
+	for _, p := range d.Rootless {
+		warnings = append(warnings, "systemd: not granting / for "+p+": it would cover the whole filesystem")
+	}
+	var buf bytes.Buffer
+	buf.WriteString("# Generated by fs-tracer\n")
+	if len(cfg.Command) > 0 {
+		buf.WriteString(output.Comment("# ", "command: "+output.ShellJoin(cfg.Command)))
+	}
+	if len(cfg.Launch) > 0 {
+		buf.WriteString(output.Comment("# ", "launch: "+output.ShellJoin(cfg.Launch)))
+	}
+	if !cfg.TracedAt.IsZero() {
+		buf.WriteString(output.Comment("# ", "traced: "+cfg.TracedAt.Format(time.RFC3339)))
+	}
+	for _, p := range d.Rootless {
+		buf.WriteString(output.Comment("# ", "skipped (would grant /): "+p))
+	}
+	buf.WriteString("# Install as /etc/systemd/system/<unit>.d/fs-tracer.conf, then run systemctl daemon-reload.\n")
+	buf.WriteString("[Service]\n")
+	buf.WriteString("ProtectSystem=strict\n")
+	fmt.Fprintf(&buf, "PrivateTmp=%s\n", yesNo(d.PrivateTmp))
+	// A leading "-" keeps the unit starting when a path has since disappeared.
+	for _, p := range d.ReadWritePaths {
+		fmt.Fprintf(&buf, "ReadWritePaths=%s\n", quotePath("-"+p))
+	}
+	for _, p := range d.ReadOnlyPaths {
+		fmt.Fprintf(&buf, "ReadOnlyPaths=%s\n", quotePath("-"+p))
+	}
+	for _, p := range d.InaccessiblePaths {
+		fmt.Fprintf(&buf, "InaccessiblePaths=%s\n", quotePath("-"+p))
+	}
+	return buf.String(), warnings
+}
+
+// touches reports whether any path lies within root or on the way to it.
+func touches(paths []string, root string) bool {
+	for _, p := range paths {
+		if processor.Within(p, root) || (p != "/" && processor.Within(root, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// quotePath escapes specifiers and quotes paths that contain whitespace,
+// quotes or backslashes, following systemd's unquoting rules.
+func quotePath(p string) string {
+	p = strings.ReplaceAll(p, "%", "%%")
+	if !strings.ContainsAny(p, " \t\n\"'\\") {
+		return p
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(p); i++ {
+		switch c := p[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func yesNo(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
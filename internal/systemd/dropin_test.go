@@ -0,0 +1,89 @@
+package systemd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/processor"
+)
+
+func TestDerive(t *testing.T) {
+	d := Derive(Config{
+		Reads:  []string{"/etc/app.conf", "/usr/lib/libc.so", "/proc/self/status", "/var/lib/app/state.db", "/opt/app/bin/app"},
+		Writes: []string{"/var/lib/app/state.db", "/var/log/app/app.log", "/tmp/scratch"},
+	})
+	want := Directives{
+		PrivateTmp:        true,
+		ReadWritePaths:    []string{"/var/lib/app", "/var/log/app"},
+		ReadOnlyPaths:     []string{"/etc/app.conf", "/opt/app/bin/app", "/usr/lib/libc.so"},
+		InaccessiblePaths: []string{"/home", "/root", "/run/user", "/srv", "/mnt", "/media", "/boot"},
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("directives mismatch:\n got %+v\nwant %+v", d, want)
+	}
+}
+
+func TestDeriveSharedTmp(t *testing.T) {
+	d := Derive(Config{Reads: []string{"/tmp/.X11-unix/X0"}, Writes: []string{"/tmp/out"}})
+	if d.PrivateTmp {
+		t.Fatal("reading a foreign /tmp file must disable PrivateTmp")
+	}
+	if !reflect.DeepEqual(d.ReadWritePaths, []string{"/tmp"}) {
+		t.Fatalf("ReadWritePaths = %v", d.ReadWritePaths)
+	}
+}
+
+func TestDeriveCollapse(t *testing.T) {
+	d := Derive(Config{
+		Reads:    []string{"/usr/share/app/a", "/usr/share/app/b", "/usr/share/app/c"},
+		Collapse: processor.CollapseOptions{MinCount: 3},
+	})
+	if !reflect.DeepEqual(d.ReadOnlyPaths, []string{"/usr/share/app"}) {
+		t.Fatalf("ReadOnlyPaths = %v", d.ReadOnlyPaths)
+	}
+}
+
+func TestDeriveNeverGrantsRoot(t *testing.T) {
+	d := Derive(Config{
+		Reads:  []string{"/", "/etc/app.conf"},
+		Writes: []string{"/out", "/var/lib/app/state.db"},
+	})
+	if !reflect.DeepEqual(d.ReadWritePaths, []string{"/var/lib/app"}) {
+		t.Fatalf("ReadWritePaths = %v", d.ReadWritePaths)
+	}
+	if !reflect.DeepEqual(d.ReadOnlyPaths, []string{"/etc/app.conf"}) {
+		t.Fatalf("ReadOnlyPaths = %v", d.ReadOnlyPaths)
+	}
+	if !reflect.DeepEqual(d.Rootless, []string{"/out", "/"}) {
+		t.Fatalf("Rootless = %v", d.Rootless)
+	}
+	out, warnings := BuildDropIn(Config{Writes: []string{"/out"}})
+	if strings.Contains(out, "ReadWritePaths=") || !strings.Contains(out, "# skipped (would grant /): /out\n") {
+		t.Fatalf("drop-in:\n%s", out)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "/out") {
+		t.Fatalf("warnings = %q", warnings)
+	}
+}
+
+func TestBuildDropIn(t *testing.T) {
+	out, _ := BuildDropIn(Config{
+		Command:  []string{"/usr/bin/app", "--serve"},
+		TracedAt: time.Date(2025, 11, 29, 10, 0, 0, 0, time.UTC),
+		Reads:    []string{"/etc/app dir/100%.conf"},
+		Writes:   []string{"/var/lib/app/state.db"},
+	})
+	for _, want := range []string{
+		"# command: /usr/bin/app --serve\n",
+		"[Service]\nProtectSystem=strict\nPrivateTmp=yes\n",
+		"ReadWritePaths=-/var/lib/app\n",
+		"ReadOnlyPaths=\"-/etc/app dir/100%%.conf\"\n",
+		"InaccessiblePaths=-/home\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("drop-in missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package systemd
+
+import "github.com/hokupod/fs-tracer/internal/sandbox"
+
+func init() { sandbox.Register(generator{}) }
+
+// generator emits a hardening drop-in for the traced service.
+type generator struct{}
+
+func (generator) Name() string { return "systemd" }
+
+func (generator) Generate(in sandbox.Input) (sandbox.Output, error) {
+	dropIn, warnings := BuildDropIn(Config{
+		Command:  in.Command,
+		Launch:   in.Launch,
+		TracedAt: in.TracedAt,
+		Reads:    in.Reads,
+		Writes:   in.Writes,
+		Collapse: in.Rules.Collapse,
+	})
+	return sandbox.Output{Data: []byte(dropIn), Warnings: warnings}, nil
+}
@@ -0,0 +1,324 @@
+// Package fstrace runs a command under fs_usage and reports the files it
+// accessed. It is the engine behind the fs-tracer CLI, for programs and test
+// harnesses that want the results as values instead of printed output.
+//
+// fs_usage needs root: either run as root and set NoSudo, or have sudo
+// credentials cached (sudo -v) before calling Trace.
+package fstrace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/aggregate"
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+)
+
+// Options configures a trace. Only Command is required.
+type Options struct {
+	// Command is the program to trace and its arguments.
+	Command []string
+	// Dir is the command's working directory; empty uses the current one.
+	Dir string
+	// Env lists KEY=VAL assignments applied to the command's environment,
+	// which starts as the caller's own, or empty with CleanEnv. UnsetEnv
+	// removes variables before Env is applied.
+	Env      []string
+	UnsetEnv []string
+	CleanEnv bool
+	// Stdin feeds the command's standard input. StdinFile takes precedence
+	// and is reopened for every run.
+	Stdin     io.Reader
+	StdinFile string
+	// Stdout and Stderr receive the command's output; nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+	// RunAs starts the command as this user (name or uid) and requires root.
+	// Without it, a command started as root runs as the sudo user.
+	RunAs string
+
+	// NoSudo runs fs_usage directly, which requires root.
+	NoSudo bool
+	// FollowChildren also traces the command's descendants.
+	FollowChildren bool
+	// NoPIDFilter keeps every event fs_usage reports with FollowChildren.
+	NoPIDFilter bool
+
+	// AllowProcesses, IgnoreProcesses and IgnorePrefixes filter events by
+	// process name and path prefix, on top of the built-in ignore lists
+	// unless Raw is set. IgnoreCWD ignores the command's working directory
+	// (Dir, or the current one), and a "." prefix stands for it.
+	AllowProcesses  []string
+	IgnoreProcesses []string
+	IgnorePrefixes  []string
+	IgnoreCWD       bool
+	Raw             bool
+	// Categories keeps only events whose op falls in one of these op
+	// categories (data-read, data-write, exec, ...).
+	Categories []string
+	// MaxDepth truncates paths to at most this many components; 0 keeps them.
+	MaxDepth int
+	// DirsOnly records parent directories instead of paths.
+	DirsOnly bool
+
+	// Timeout, MaxEvents and StopOnPath end a run early: the command's
+	// process group gets SIGTERM, then SIGKILL after KillGrace (default 5s).
+	// StopOnPath is a glob; without a "/" it matches the base name.
+	Timeout    time.Duration
+	MaxEvents  int
+	StopOnPath string
+	KillGrace  time.Duration
+
+	// Repeat runs the command this many times and merges the events;
+	// Access.Runs counts the runs that touched each path.
+	Repeat int
+
+	// KeepEvents stores every event for Result.Events. Beyond MemoryLimit
+	// bytes they are spilled to a temporary file; 0 keeps them in memory.
+	KeepEvents  bool
+	MemoryLimit int64
+
+	// WaitAttach holds the command until fs_usage reports its first event, at
+	// most AttachDelay (5s by default); AttachDelay alone holds it for that
+	// long. Both re-execute GateExecutable (default os.Executable) with
+	// GateCommand, see RunGate.
+	WaitAttach     bool
+	AttachDelay    time.Duration
+	GateExecutable string
+
+	// Signals delivers signals to forward to the command's process group.
+	// The first ends the trace, which is still reported; a second kills the
+	// command and Trace returns an *AbortError.
+	Signals <-chan os.Signal
+	// OnEvent is called with each event that passes the filters as it
+	// arrives, from a single goroutine. It must not block for long: fs_usage
+	// drops events when its output is not read.
+	OnEvent func(Event)
+	// Log receives diagnostics such as truncation notices; nil discards
+	// them. Debug adds every fs_usage line and parse error.
+	Log   io.Writer
+	Debug bool
+
+	// Runner produces fs_usage output; nil runs fs_usage. Recorded output
+	// can be replayed by returning it from a custom Runner.
+	Runner Runner
+	// Hooks replace command construction and process inspection.
+	Hooks Hooks
+}
+
+// Runner starts the event source for the command with the given PID and
+// base name. fs_usage output is read from the returned stream until it is
+// closed.
+type Runner interface {
+	Run(pid int, comm string) (io.ReadCloser, error)
+}
+
+// Hooks replace the parts of a trace that touch the system, mainly for tests.
+// Nil fields use the defaults.
+type Hooks struct {
+	// NewCmd builds the command from Options.Command.
+	NewCmd func(argv []string) (*exec.Cmd, error)
+	// BaseDate supplies the date for fs_usage's time-of-day timestamps.
+	BaseDate func() time.Time
+	// ChildFinder, ThreadLister and CommFinder inspect processes for
+	// FollowChildren.
+	ChildFinder  func(rootPID int) ([]int, error)
+	ThreadLister func(pid int) ([]uint64, error)
+	CommFinder   func(pid int) (string, error)
+	// DisablePIDFilter keeps every event with FollowChildren, like
+	// Options.NoPIDFilter.
+	DisablePIDFilter bool
+}
+
+// Event is a file system call reported by fs_usage.
+type Event struct {
+	Timestamp time.Time
+	// RawTimestamp is the time of day as fs_usage printed it.
+	RawTimestamp string
+	PID          int
+	Comm         string
+	Op           string
+	Path         string
+}
+
+// Access is a path with what the traced command did to it.
+type Access struct {
+	Path string
+	// Categories are the op categories exercised on Path, in catalogue order.
+	Categories []string
+	// Runs counts the runs that accessed Path.
+	Runs int
+}
+
+// Result is the outcome of a trace. Call Close when done with it.
+type Result struct {
+	// ExitCode is the command's exit status in the first run that failed, or
+	// 0. A command killed by a signal reports 128+signal.
+	ExitCode int
+	// Executable and Dir describe the command as started in the first run.
+	Executable string
+	Dir        string
+	// Home is HOME in the command's environment, or empty when it has none.
+	Home string
+	// Start and End bound the trace, from the first run's start to the last
+	// run's exit.
+	Start time.Time
+	End   time.Time
+	// Runs counts the runs performed; an interrupt skips the remaining ones.
+	Runs int
+	// Truncated says why a run was stopped early, or is empty.
+	Truncated string
+	// Interrupted reports that a signal or context cancellation ended the
+	// trace.
+	Interrupted bool
+
+	// Paths lists the unique paths, sorted. Reads and Writes split them: a
+	// path is read when any non-write op touched it and written when any
+	// write op did.
+	Paths    []string
+	Reads    []string
+	Writes   []string
+	Accesses []Access
+	// Ops lists the distinct normalized op names seen, sorted.
+	Ops   []string
+	Stats Stats
+
+	agg *aggregate.Aggregator
+}
+
+// Stats counts what happened to fs_usage's output.
+type Stats struct {
+	// Lines is the number of lines read from fs_usage.
+	Lines int
+	// Unparseable lines were not fs_usage event lines.
+	Unparseable int
+	// Captured events were attributed to the traced command.
+	Captured int
+	// Kept events also passed the filters.
+	Kept int
+	// Spilled events were written to the temporary file (see MemoryLimit).
+	Spilled int
+	// PIDFilter reports that events were matched against the command's
+	// process tree, as FollowChildren does unless NoPIDFilter is set.
+	PIDFilter bool
+	// ThreadLookupBypass reports that thread lookups were refused, so the
+	// PID filter let every process through.
+	ThreadLookupBypass bool
+	// ZeroMatchBypass reports that no event matched the process tree, so
+	// the PID filter fell back to matching process names.
+	ZeroMatchBypass bool
+}
+
+// Events calls fn for each stored event in arrival order; it requires
+// Options.KeepEvents. It stops at the first error.
+func (r *Result) Events(fn func(Event) error) error {
+	return r.agg.Events(func(ev fsusage.Event) error { return fn(Event(ev)) })
+}
+
+// Prune drops the paths accessed in fewer than minRuns runs and returns how
+// many were dropped. Stored events are kept.
+func (r *Result) Prune(minRuns int) int {
+	n := r.agg.Prune(minRuns)
+	r.fill()
+	return n
+}
+
+// Close removes the temporary event file, if any.
+func (r *Result) Close() error {
+	return r.agg.Close()
+}
+
+// Stage is a step of a trace that can fail.
+type Stage int
+
+const (
+	// StageSetup covers building the command and resolving Options.
+	StageSetup Stage = iota + 1
+	// StageStart is starting the command.
+	StageStart
+	// StageTracer is starting fs_usage.
+	StageTracer
+	// StageRead covers reading fs_usage output and storing events.
+	StageRead
+)
+
+// Error is a failure to run a trace.
+type Error struct {
+	Stage Stage
+	Err   error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func stageErr(stage Stage, format string, args ...any) *Error {
+	return &Error{Stage: stage, Err: fmt.Errorf(format, args...)}
+}
+
+// AbortError reports that a second signal from Options.Signals killed the
+// command. No result is returned.
+type AbortError struct {
+	Signal syscall.Signal
+}
+
+func (e *AbortError) Error() string {
+	return signalName(e.Signal) + " received again"
+}
+
+// Trace runs the command, Options.Repeat times if set, and returns the
+// merged accesses. Cancelling ctx stops the current run like Timeout and
+// skips the remaining ones.
+func Trace(ctx context.Context, opts Options) (*Result, error) {
+	t, err := newTracer(opts)
+	if err != nil {
+		return nil, err
+	}
+	return t.trace(ctx)
+}
+
+// Events starts a trace and returns its filtered events as they arrive. The
+// channel is closed when the trace ends; wait then returns the error that
+// ended it, if any, and blocks until the channel is closed when called
+// earlier. The channel is unbuffered and the tracer waits for each event to
+// be received, so keep reading until it is closed or cancel ctx. Use Trace
+// with OnEvent to get the Result as well.
+func Events(ctx context.Context, opts Options) (events <-chan Event, wait func() error, err error) {
+	ch := make(chan Event)
+	next := opts.OnEvent
+	opts.OnEvent = func(ev Event) {
+		if next != nil {
+			next(ev)
+		}
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+		}
+	}
+	opts.KeepEvents = false
+	t, err := newTracer(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := make(chan struct{})
+	var traceErr error
+	go func() {
+		defer close(ch)
+		defer close(done)
+		res, err := t.trace(ctx)
+		if err != nil {
+			traceErr = err
+			return
+		}
+		_ = res.Close()
+	}()
+	return ch, func() error {
+		<-done
+		return traceErr
+	}, nil
+}
@@ -0,0 +1,115 @@
+package fstrace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// GateCommand is the argument that makes a binary run RunGate. fs-tracer
+// handles it as a hidden subcommand; programs embedding this package that set
+// Options.WaitAttach or Options.AttachDelay must handle it too.
+const GateCommand = "__gate"
+
+// fs-tracer's exit codes for invalid arguments and a command that failed to
+// start, used by the gate helper and for unknown command states.
+const (
+	exitInvalidArgs = 90
+	exitCmdStartErr = 91
+)
+
+// defaultAttachWait caps --wait-attach when no --attach-delay is given.
+const defaultAttachWait = 5 * time.Second
+
+// gateProbeInterval is how often the gated helper touches its probe path, so
+// the tracer has something to report once it is attached.
+const gateProbeInterval = 10 * time.Millisecond
+
+// gate holds yourcmd in a helper process until release. The helper has the
+// PID yourcmd will have, because it execs into it.
+type gate struct {
+	release func()
+	probe   string
+}
+
+// gateCommand wraps cmd in the gate helper: self __gate PROBE PATH ARGV...
+// The helper reads the release pipe as fd 3.
+func gateCommand(self string, cmd *exec.Cmd) (*exec.Cmd, *gate, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	probe := filepath.Join(os.TempDir(), ".fs-tracer-attach-"+strconv.Itoa(os.Getpid()))
+	helper := exec.Command(self, append([]string{GateCommand, probe, cmd.Path}, cmd.Args...)...)
+	helper.Dir = cmd.Dir
+	helper.ExtraFiles = []*os.File{r}
+	released := false
+	g := &gate{probe: probe, release: func() {
+		if !released {
+			released = true
+			_ = w.Close()
+		}
+		_ = r.Close()
+	}}
+	return helper, g, nil
+}
+
+// RunGate is the gate helper: it probes until fd 3 reaches EOF, then execs
+// PATH with ARGV. args are PROBE PATH ARGV... It only returns on failure.
+func RunGate(args []string, stderr io.Writer) int {
+	if len(args) < 3 {
+		fmt.Fprintln(stderr, "usage: fs-tracer", GateCommand, "PROBE PATH ARGV...")
+		return exitInvalidArgs
+	}
+	probe, path, argv := args[0], args[1], args[2:]
+	release := os.NewFile(3, "gate")
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, release)
+		close(done)
+	}()
+	ticker := time.NewTicker(gateProbeInterval)
+	defer ticker.Stop()
+	for waiting := true; waiting; {
+		_, _ = os.Stat(probe)
+		select {
+		case <-done:
+			waiting = false
+		case <-ticker.C:
+		}
+	}
+	release.Close()
+	if err := syscall.Exec(path, argv, os.Environ()); err != nil {
+		fmt.Fprintln(stderr, "failed to start yourcmd:", err)
+		return exitCmdStartErr
+	}
+	return 0
+}
+
+// waitAttach blocks until yourcmd may be released: after the runner's first
+// line with WaitAttach (at most AttachDelay, 5s by default), or after
+// AttachDelay alone. A stop condition releases it at once.
+func waitAttach(opts Options, attached, stopped <-chan struct{}, stderr io.Writer) {
+	if !opts.WaitAttach {
+		attached = nil
+	}
+	limit := opts.AttachDelay
+	if opts.WaitAttach && limit <= 0 {
+		limit = defaultAttachWait
+	}
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+	select {
+	case <-attached:
+	case <-stopped:
+	case <-timer.C:
+		if opts.WaitAttach {
+			fmt.Fprintf(stderr, "no trace output after %s; starting yourcmd anyway\n", limit)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package fstrace
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runAsUser is the account Options.RunAs starts yourcmd as.
+type runAsUser struct {
+	name string
+	home string
+	cred *syscall.Credential
+}
+
+// lookupRunAs resolves a user name or numeric uid. Switching users
+// needs root, so it fails otherwise.
+func lookupRunAs(name string) (*runAsUser, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("switching to %s requires running fs-tracer as root (e.g. via sudo)", name)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		var idErr error
+		if u, idErr = user.LookupId(name); idErr != nil {
+			return nil, err
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has non-numeric uid %q", u.Username, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %s has non-numeric gid %q", u.Username, u.Gid)
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(g))
+			}
+		}
+	}
+	return &runAsUser{name: u.Username, home: u.HomeDir, cred: cred}, nil
+}
+
+// apply starts cmd as the user, keeping the rest of SysProcAttr.
+func (u *runAsUser) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = u.cred
+}
+
+// commandEnv returns yourcmd's environment: the caller's own (or an empty one
+// with CleanEnv), the RunAs user's HOME, USER and LOGNAME, minus UnsetEnv,
+// plus Env in order.
+func commandEnv(opts Options, runAs *runAsUser) []string {
+	var env []string
+	if !opts.CleanEnv {
+		env = os.Environ()
+	}
+	if runAs != nil {
+		env = setEnv(env, "HOME="+runAs.home)
+		env = setEnv(env, "USER="+runAs.name)
+		env = setEnv(env, "LOGNAME="+runAs.name)
+	}
+	for _, key := range opts.UnsetEnv {
+		env = unsetEnv(env, key)
+	}
+	for _, kv := range opts.Env {
+		env = setEnv(env, kv)
+	}
+	return env
+}
+
+// setEnv replaces or appends the KEY=VAL assignment kv.
+func setEnv(env []string, kv string) []string {
+	key, _, _ := strings.Cut(kv, "=")
+	return append(unsetEnv(env, key), kv)
+}
+
+// envValue returns key's value in env, or "" when it is unset.
+func envValue(env []string, key string) string {
+	for i := len(env) - 1; i >= 0; i-- {
+		if k, v, _ := strings.Cut(env[i], "="); k == key {
+			return v
+		}
+	}
+	return ""
+}
+
+func unsetEnv(env []string, key string) []string {
+	out := env[:0:0]
+	for _, e := range env {
+		if k, _, _ := strings.Cut(e, "="); k != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func applyCredential(cmd *exec.Cmd) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	sudoUID := os.Getenv("SUDO_UID")
+	sudoGID := os.Getenv("SUDO_GID")
+	if sudoUID == "" || sudoGID == "" {
+		return fmt.Errorf("running as root is unsupported without SUDO_UID/GID; yourcmd must run as original user")
+	}
+	uid, err := strconv.ParseUint(sudoUID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid SUDO_UID: %w", err)
+	}
+	gid, err := strconv.ParseUint(sudoGID, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid SUDO_GID: %w", err)
+	}
+	// Keep Setpgid: stop conditions signal yourcmd's process group.
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid: uint32(uid),
+		Gid: uint32(gid),
+	}
+	return nil
+}
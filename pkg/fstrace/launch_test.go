@@ -0,0 +1,47 @@
+package fstrace
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCommandEnv(t *testing.T) {
+	t.Setenv("FS_TRACER_KEEP", "1")
+	t.Setenv("FS_TRACER_DROP", "1")
+	env := commandEnv(Options{UnsetEnv: []string{"FS_TRACER_DROP"}, Env: []string{"FS_TRACER_KEEP=2", "A=b=c"}}, nil)
+	got := map[string]int{}
+	for _, e := range env {
+		got[e]++
+	}
+	if got["FS_TRACER_KEEP=2"] != 1 || got["FS_TRACER_KEEP=1"] != 0 || got["FS_TRACER_DROP=1"] != 0 || got["A=b=c"] != 1 {
+		t.Fatalf("env = %v", env)
+	}
+
+	clean := commandEnv(Options{CleanEnv: true, Env: []string{"A=1"}}, &runAsUser{name: "alice", home: "/home/alice"})
+	want := []string{"HOME=/home/alice", "USER=alice", "LOGNAME=alice", "A=1"}
+	if !reflect.DeepEqual(clean, want) {
+		t.Fatalf("clean env = %v, want %v", clean, want)
+	}
+}
+
+func TestLookupRunAs(t *testing.T) {
+	if os.Geteuid() != 0 {
+		if _, err := lookupRunAs("root"); err == nil {
+			t.Fatal("--run-as should require root")
+		}
+		return
+	}
+	for _, name := range []string{"root", "0"} {
+		u, err := lookupRunAs(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.name != "root" || u.cred.Uid != 0 || u.cred.Gid != 0 {
+			t.Fatalf("lookupRunAs(%q) = %+v", name, u)
+		}
+	}
+	if _, err := lookupRunAs("no-such-user-fs-tracer"); err == nil {
+		t.Fatal("unknown user accepted")
+	}
+}
@@ -0,0 +1,169 @@
+package fstrace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// defaultKillGrace is how long yourcmd may take to exit after SIGTERM before
+// it is killed.
+const defaultKillGrace = 5 * time.Second
+
+// stopper ends a trace early. The first stop records why and sends SIGTERM to
+// yourcmd's process group, escalating to SIGKILL after the grace period.
+type stopper struct {
+	pgid    int
+	grace   time.Duration
+	exited  chan struct{}
+	stopped chan struct{}
+
+	mu          sync.Mutex
+	reason      string
+	interrupted bool
+	aborted     syscall.Signal
+}
+
+func newStopper(pgid int, grace time.Duration) *stopper {
+	if grace <= 0 {
+		grace = defaultKillGrace
+	}
+	return &stopper{pgid: pgid, grace: grace, exited: make(chan struct{}), stopped: make(chan struct{})}
+}
+
+func (s *stopper) stop(reason string) {
+	s.send(reason, syscall.SIGTERM, true)
+}
+
+// interrupt forwards sig to yourcmd's process group without escalating;
+// yourcmd decides how to react.
+func (s *stopper) interrupt(sig syscall.Signal) {
+	s.mu.Lock()
+	s.interrupted = true
+	s.mu.Unlock()
+	s.send("interrupted by "+signalName(sig), sig, false)
+}
+
+// abort records that sig gave up on the trace and kills yourcmd.
+func (s *stopper) abort(sig syscall.Signal) {
+	s.mu.Lock()
+	s.aborted = sig
+	s.mu.Unlock()
+	s.kill()
+}
+
+// kill sends SIGKILL to yourcmd's process group.
+func (s *stopper) kill() {
+	_ = syscall.Kill(-s.pgid, syscall.SIGKILL)
+}
+
+// send records reason unless a stop is already under way, then signals the
+// process group. Repeated stops are no-ops; interrupts are always forwarded.
+func (s *stopper) send(reason string, sig syscall.Signal, escalate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.exited:
+		// yourcmd finished on its own; nothing was cut short.
+		return
+	default:
+	}
+	if s.reason == "" {
+		s.reason = reason
+		close(s.stopped)
+	} else if escalate {
+		return
+	}
+	_ = syscall.Kill(-s.pgid, sig)
+	if !escalate {
+		return
+	}
+	go func() {
+		select {
+		case <-s.exited:
+		case <-time.After(s.grace):
+			s.kill()
+		}
+	}()
+}
+
+// markExited records that yourcmd has been reaped.
+func (s *stopper) markExited() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.exited)
+}
+
+// truncated returns why the trace was stopped early, or "".
+func (s *stopper) truncated() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+// wasInterrupted reports whether a signal was forwarded to yourcmd, which
+// also ends a --repeat series.
+func (s *stopper) wasInterrupted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.interrupted
+}
+
+// abortedBy returns the signal that aborted the trace, or 0.
+func (s *stopper) abortedBy() syscall.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+// forwardSignals relays the first signal to yourcmd's process group so the
+// trace ends and is still reported. A second signal kills yourcmd and aborts
+// the trace.
+func forwardSignals(sigs <-chan os.Signal, s *stopper, done <-chan struct{}) {
+	interrupted := false
+	for {
+		select {
+		case <-done:
+			return
+		case sig := <-sigs:
+			ssig, ok := sig.(syscall.Signal)
+			if !ok {
+				continue
+			}
+			if !interrupted {
+				interrupted = true
+				s.interrupt(ssig)
+				continue
+			}
+			s.abort(ssig)
+			return
+		}
+	}
+}
+
+func signalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGHUP:
+		return "SIGHUP"
+	case syscall.SIGQUIT:
+		return "SIGQUIT"
+	}
+	return sig.String()
+}
+
+// matchStopPath reports whether p matches a --stop-on-path glob. Patterns
+// without a slash match the base name, like .gitignore entries.
+func matchStopPath(pattern, p string) bool {
+	if !strings.Contains(pattern, "/") {
+		p = filepath.Base(p)
+	}
+	ok, _ := filepath.Match(pattern, p)
+	return ok
+}
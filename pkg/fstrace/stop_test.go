@@ -0,0 +1,52 @@
+package fstrace
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestMatchStopPath(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.lock", "/srv/app/db.lock", true},
+		{"ready", "/tmp/ready", true},
+		{"/tmp/*", "/tmp/ready", true},
+		{"/tmp/*", "/tmp/a/ready", false},
+		{"*.lock", "/srv/app/db.lock.tmp", false},
+	}
+	for _, c := range cases {
+		if got := matchStopPath(c.pattern, c.path); got != c.want {
+			t.Errorf("matchStopPath(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+}
+
+func TestStopperIgnoresExitedCommand(t *testing.T) {
+	s := newStopper(0, 0)
+	s.markExited()
+	// pgid 0 would signal our own group if the stop went through.
+	s.stop("late")
+	if got := s.truncated(); got != "" {
+		t.Fatalf("truncated() = %q after exit", got)
+	}
+}
+
+func TestApplyCredentialKeepsProcessGroup(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("credentials are only applied when running as root")
+	}
+	t.Setenv("SUDO_UID", "1")
+	t.Setenv("SUDO_GID", "1")
+	cmd := exec.Command("true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := applyCredential(cmd); err != nil {
+		t.Fatal(err)
+	}
+	if !cmd.SysProcAttr.Setpgid || cmd.SysProcAttr.Credential == nil {
+		t.Fatalf("SysProcAttr = %+v", cmd.SysProcAttr)
+	}
+}
@@ -0,0 +1,700 @@
+package fstrace
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hokupod/fs-tracer/internal/aggregate"
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/processor"
+	"github.com/hokupod/fs-tracer/internal/procinfo"
+)
+
+// tracer holds what stays the same across the runs of a trace.
+type tracer struct {
+	opts     Options
+	log      io.Writer
+	runner   Runner
+	newCmd   func([]string) (*exec.Cmd, error)
+	baseDate func() time.Time
+	env      []string
+	runAs    *runAsUser
+	filters  processor.Filters
+	agg      *aggregate.Aggregator
+	stats    Stats
+}
+
+func newTracer(opts Options) (*tracer, error) {
+	t := &tracer{opts: opts, log: opts.Log, runner: opts.Runner, newCmd: opts.Hooks.NewCmd, baseDate: opts.Hooks.BaseDate}
+	if t.log == nil {
+		t.log = io.Discard
+	}
+	if t.runner == nil {
+		t.runner = fsusage.SudoFsUsageRunner{NoSudo: opts.NoSudo, All: opts.FollowChildren}
+	}
+	if t.newCmd == nil {
+		t.newCmd = defaultCmdBuilder
+	}
+	if t.baseDate == nil {
+		t.baseDate = time.Now
+	}
+	var categories []ops.Category
+	for _, name := range opts.Categories {
+		c, err := ops.ParseCategory(name)
+		if err != nil {
+			return nil, &Error{Stage: StageSetup, Err: err}
+		}
+		categories = append(categories, c)
+	}
+	if opts.RunAs != "" {
+		u, err := lookupRunAs(opts.RunAs)
+		if err != nil {
+			return nil, stageErr(StageSetup, "run as %s: %w", opts.RunAs, err)
+		}
+		t.runAs = u
+	}
+	t.env = commandEnv(opts, t.runAs)
+	t.filters = processor.Filters{
+		AllowProcesses:  opts.AllowProcesses,
+		IgnoreProcesses: opts.IgnoreProcesses,
+		IgnorePrefixes:  expandPrefixes(opts.IgnorePrefixes, opts.IgnoreCWD, opts.Dir),
+		Categories:      categories,
+		MaxDepth:        opts.MaxDepth,
+		Raw:             opts.Raw,
+	}
+	t.agg = aggregate.New(aggregate.Options{
+		DirsOnly:    opts.DirsOnly,
+		KeepEvents:  opts.KeepEvents,
+		MemoryLimit: opts.MemoryLimit,
+	})
+	return t, nil
+}
+
+// trace performs the runs and collects their merged result. The runs stop
+// early once one is interrupted.
+func (t *tracer) trace(ctx context.Context) (*Result, error) {
+	runs := t.opts.Repeat
+	if runs < 1 {
+		runs = 1
+	}
+	res := &Result{Start: time.Now(), Home: envValue(t.env, "HOME"), agg: t.agg}
+	for i := 1; i <= runs; i++ {
+		if i > 1 {
+			t.agg.NextRun()
+		}
+		r, err := t.run(ctx)
+		if err == nil && r.aborted != 0 {
+			err = &AbortError{Signal: r.aborted}
+		}
+		if err != nil {
+			_ = t.agg.Close()
+			return nil, err
+		}
+		res.Runs = i
+		if i == 1 {
+			res.Executable, res.Dir = r.executable, r.dir
+		}
+		if r.truncated != "" {
+			res.Truncated = r.truncated
+		}
+		// The first failing run decides the exit status.
+		if c := exitCodeFromCmd(r.errCmd); c != 0 {
+			if runs > 1 {
+				fmt.Fprintf(t.log, "run %d of %d: yourcmd exited with status %d\n", i, runs, c)
+			}
+			if res.ExitCode == 0 {
+				res.ExitCode = c
+			}
+		}
+		if r.interrupted {
+			res.Interrupted = true
+			if i < runs {
+				fmt.Fprintf(t.log, "skipping the remaining %d of %d runs\n", runs-i, runs)
+			}
+			break
+		}
+	}
+	res.End = time.Now()
+	if res.Dir == "" {
+		res.Dir, _ = os.Getwd()
+	} else if abs, err := filepath.Abs(res.Dir); err == nil {
+		res.Dir = abs
+	}
+	res.Stats = t.stats
+	res.Stats.Spilled = t.agg.Spilled()
+	res.fill()
+	return res, nil
+}
+
+// fill copies the aggregated accesses into the exported fields.
+func (r *Result) fill() {
+	r.Paths = r.agg.Paths()
+	r.Reads, r.Writes = r.agg.ReadWrite()
+	accesses := r.agg.Accesses()
+	r.Accesses = make([]Access, 0, len(accesses))
+	for _, acc := range accesses {
+		cats := make([]string, 0, len(acc.Categories))
+		for _, c := range acc.Categories {
+			cats = append(cats, string(c))
+		}
+		r.Accesses = append(r.Accesses, Access{Path: acc.Path, Categories: cats, Runs: r.agg.Seen(acc.Path)})
+	}
+	r.Ops = r.agg.OpNames()
+}
+
+// runResult describes one finished run of yourcmd.
+type runResult struct {
+	errCmd      error
+	executable  string
+	dir         string
+	truncated   string
+	interrupted bool
+	aborted     syscall.Signal
+}
+
+// run starts yourcmd once and feeds its filtered events into t.agg.
+func (t *tracer) run(ctx context.Context) (runResult, error) {
+	opts, stderr, debug := t.opts, t.log, t.opts.Debug
+	baseDateValue := t.baseDate()
+
+	cmd, err := t.newCmd(opts.Command)
+	if err != nil {
+		return runResult{}, stageErr(StageSetup, "failed to build command: %w", err)
+	}
+	if opts.Dir != "" {
+		cmd.Dir = opts.Dir
+	}
+	// Metadata and fs_usage's comm filter name yourcmd, not the gate helper.
+	executable := cmd.Path
+	var gated *gate
+	if opts.WaitAttach || opts.AttachDelay > 0 {
+		// The helper would exec cmd.Path regardless, so report a failed
+		// lookup here as Start does without the gate.
+		if cmd.Err != nil {
+			return runResult{}, stageErr(StageStart, "failed to start yourcmd: %w", cmd.Err)
+		}
+		self := opts.GateExecutable
+		if self == "" {
+			if self, err = os.Executable(); err != nil {
+				return runResult{}, stageErr(StageStart, "failed to locate fs-tracer for gated start: %w", err)
+			}
+		}
+		if cmd, gated, err = gateCommand(self, cmd); err != nil {
+			return runResult{}, stageErr(StageStart, "failed to set up gated start: %w", err)
+		}
+		defer gated.release()
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = t.env
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	cmd.Stdin = opts.Stdin
+	// StdinFile is reopened for every run so each one reads the whole file.
+	if opts.StdinFile != "" {
+		f, err := os.Open(opts.StdinFile)
+		if err != nil {
+			return runResult{}, stageErr(StageSetup, "failed to open stdin: %w", err)
+		}
+		defer f.Close()
+		cmd.Stdin = f
+	}
+
+	if t.runAs != nil {
+		t.runAs.apply(cmd)
+	} else if err := applyCredential(cmd); err != nil {
+		return runResult{}, &Error{Stage: StageSetup, Err: err}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return runResult{}, stageErr(StageStart, "failed to start yourcmd: %w", err)
+	}
+
+	targetPID := cmd.Process.Pid
+	stops := newStopper(targetPID, opts.KillGrace)
+	if opts.Timeout > 0 {
+		timer := time.AfterFunc(opts.Timeout, func() {
+			stops.stop(fmt.Sprintf("--timeout %s elapsed", opts.Timeout))
+		})
+		defer timer.Stop()
+	}
+	forwardDone := make(chan struct{})
+	defer close(forwardDone)
+	go forwardSignals(opts.Signals, stops, forwardDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stops.stop(ctx.Err().Error())
+		case <-forwardDone:
+		}
+	}()
+
+	// Apply Go-side PID filtering only when we intentionally broaden fs_usage to all PIDs
+	// (i.e., --follow-children). When fs_usage is already invoked with the target PID,
+	// kernel-side filtering is sufficient and thread-id vs pid formatting differences
+	// in fs_usage output would otherwise drop valid events.
+	filterPID := opts.FollowChildren && !opts.Hooks.DisablePIDFilter && !opts.NoPIDFilter
+
+	var (
+		allowPID    func(int) bool
+		stopFollow  chan struct{}
+		tracker     *pidTracker
+		allowedComm map[string]struct{}
+	)
+
+	addComm := func(c string) {
+		if c == "" {
+			return
+		}
+		if allowedComm == nil {
+			allowedComm = make(map[string]struct{})
+		}
+		allowedComm[c] = struct{}{}
+	}
+
+	if !filterPID {
+		allowPID = func(int) bool { return true }
+	} else if opts.FollowChildren {
+		rootPID := targetPID
+		tracker = newPIDTracker(rootPID)
+		threadLister := opts.Hooks.ThreadLister
+		if threadLister == nil {
+			threadLister = procinfo.ListThreads
+		}
+		commFinder := opts.Hooks.CommFinder
+		if commFinder == nil {
+			commFinder = defaultCommFinder
+		}
+
+		knownPIDs := map[int]struct{}{rootPID: {}}
+
+		addPIDWithThreads := func(pid int) {
+			tracker.addPID(pid)
+			tids, err := threadLister(pid)
+			if err != nil {
+				if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || strings.Contains(err.Error(), "protection") {
+					// Mach protection failure etc. → すぐに comm-only に寄せる
+					if tracker.setBypass() {
+						fmt.Fprintln(stderr, "pid filter switched to comm-only: thread lookup blocked (permission/protection)")
+					}
+					return
+				}
+				if !errors.Is(err, syscall.ESRCH) && !errors.Is(err, syscall.EINVAL) {
+					if tracker.setBypass() {
+						fmt.Fprintln(stderr, "pid filter disabled after thread lookup failure:", err)
+					}
+				}
+				return
+			}
+			if c, err := commFinder(pid); err == nil {
+				cBase := filepath.Base(c)
+				addComm(cBase)
+				// thread handles are only trusted when their comm is known/allowed to reduce accidental PID collisions.
+				if len(tids) > 0 {
+					if _, ok := allowedComm[cBase]; ok {
+						tracker.addThreads(tids)
+					}
+				}
+			}
+		}
+
+		addPIDWithThreads(rootPID)
+
+		finder := opts.Hooks.ChildFinder
+		if finder == nil {
+			finder = defaultChildFinder
+		}
+
+		updateChildren := func() {
+			children, err := finder(rootPID)
+			if err != nil {
+				if debug {
+					fmt.Fprintln(stderr, "child finder error:", err)
+				}
+				return
+			}
+			for _, c := range children {
+				if _, ok := knownPIDs[c]; ok {
+					continue
+				}
+				knownPIDs[c] = struct{}{}
+				addPIDWithThreads(c)
+			}
+		}
+
+		refreshThreads := func() {
+			for pid := range knownPIDs {
+				addPIDWithThreads(pid)
+			}
+		}
+
+		updateChildren()
+
+		childTicker := time.NewTicker(500 * time.Millisecond)
+		tidTicker := time.NewTicker(2 * time.Second)
+		stopFollow = make(chan struct{})
+		go func() {
+			defer childTicker.Stop()
+			defer tidTicker.Stop()
+			for {
+				select {
+				case <-childTicker.C:
+					updateChildren()
+				case <-tidTicker.C:
+					refreshThreads()
+				case <-stopFollow:
+					return
+				}
+			}
+		}()
+
+		allowPID = tracker.allowID
+	} else {
+		rootPID := targetPID
+		allowPID = func(pid int) bool { return pid == rootPID }
+	}
+
+	defer func() {
+		if stopFollow != nil {
+			close(stopFollow)
+		}
+	}()
+
+	comm := executable
+	if comm == "" && len(cmd.Args) > 0 {
+		comm = cmd.Args[0]
+	}
+	if filterPID && opts.FollowChildren {
+		addComm(filepath.Base(comm))
+	}
+	runnerPID := targetPID
+	reader, err := t.runner.Run(runnerPID, filepath.Base(comm))
+	if err != nil {
+		_ = cmd.Process.Kill()
+		return runResult{}, stageErr(StageTracer, "failed to start fs_usage: %w", err)
+	}
+
+	eventsCh := make(chan fsusage.Event)
+	// attached is closed on the first line from the runner: the tracer is live.
+	attached := make(chan struct{})
+	var attachOnce sync.Once
+	scanErrCh := make(chan error, 1)
+
+	// Collector drains events concurrently to avoid blocking fs_usage scanner.
+	// Filters apply per event so --stream can write each one as it arrives.
+	var (
+		aggErr        error
+		collectDoneCh = make(chan struct{})
+	)
+	go func() {
+		full := false
+		captured := 0
+		for ev := range eventsCh {
+			// Once a stop condition fired, keep draining until yourcmd exits.
+			if full {
+				continue
+			}
+			captured++
+			if kept, ok := t.filters.Apply(ev); ok {
+				t.stats.Kept++
+				if opts.OnEvent != nil {
+					opts.OnEvent(Event(kept))
+				}
+				if err := t.agg.Add(kept); err != nil && aggErr == nil {
+					aggErr = err
+				}
+			}
+			switch {
+			case opts.MaxEvents > 0 && captured >= opts.MaxEvents:
+				full = true
+				stops.stop(fmt.Sprintf("--max-events %d reached", opts.MaxEvents))
+			case opts.StopOnPath != "" && matchStopPath(opts.StopOnPath, ev.Path):
+				full = true
+				stops.stop(fmt.Sprintf("%s matched --stop-on-path %s", ev.Path, opts.StopOnPath))
+			}
+		}
+		close(collectDoneCh)
+	}()
+
+	go func(r io.ReadCloser) {
+		defer close(eventsCh)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 128*1024), 512*1024)
+		parsedCount := 0
+		passedCount := 0
+		zeroMatchNotified := false
+		for scanner.Scan() {
+			attachOnce.Do(func() { close(attached) })
+			t.stats.Lines++
+			line := scanner.Text()
+			if debug {
+				fmt.Fprintln(stderr, "fs_usage:", line)
+			}
+			ev, err := fsusage.ParseLine(line, baseDateValue)
+			if err != nil {
+				t.stats.Unparseable++
+				if debug {
+					fmt.Fprintln(stderr, "parse error:", err, "line:", line)
+				}
+				continue
+			}
+			// Always skip fs-tracer itself to avoid self-noise even in bypass/fallback paths.
+			if ev.PID == os.Getpid() || ev.Comm == filepath.Base(os.Args[0]) {
+				continue
+			}
+			if gated != nil && ev.Path == gated.probe {
+				continue
+			}
+			if filterPID {
+				parsedCount++
+				allowed := allowPID(ev.PID)
+				// Always permit events whose comm is already known, to reduce reliance on TID/PID formatting.
+				if !allowed {
+					if _, ok := allowedComm[ev.Comm]; ok {
+						allowed = true
+					}
+				}
+				if !allowed && passedCount == 0 && parsedCount >= zeroMatchBypassThreshold && tracker != nil && !tracker.isBypass() {
+					if !zeroMatchNotified {
+						fmt.Fprintln(stderr, "pid filter switched to comm-only after zero-match streak")
+						zeroMatchNotified = true
+						t.stats.ZeroMatchBypass = true
+					}
+					// Fall back to comm-based allowlist for the current and future events of this comm.
+					addComm(ev.Comm)
+					if _, ok := allowedComm[ev.Comm]; ok {
+						allowed = true
+					}
+				}
+				if !allowed {
+					continue
+				}
+				passedCount++
+			}
+			t.stats.Captured++
+			eventsCh <- ev
+		}
+		if err := scanner.Err(); err != nil {
+			scanErrCh <- err
+		}
+	}(reader)
+
+	if gated != nil {
+		waitAttach(opts, attached, stops.stopped, stderr)
+		gated.release()
+	}
+
+	errCmd := cmd.Wait()
+	stops.markExited()
+	_ = reader.Close()
+
+	// Wait for collector to finish draining events.
+	<-collectDoneCh
+
+	select {
+	case scanErr := <-scanErrCh:
+		if scanErr != nil {
+			if !isBenignClose(scanErr) {
+				return runResult{}, stageErr(StageRead, "fs_usage read error: %w", scanErr)
+			}
+		}
+	default:
+	}
+
+	if reason := stops.truncated(); reason != "" {
+		fmt.Fprintf(stderr, "trace truncated (%s); output covers the events captured until then\n", reason)
+	}
+
+	if filterPID {
+		t.stats.PIDFilter = true
+	}
+	if tracker != nil && tracker.isBypass() {
+		t.stats.ThreadLookupBypass = true
+	}
+	if aggErr != nil {
+		return runResult{}, stageErr(StageRead, "failed to store events: %w", aggErr)
+	}
+	return runResult{
+		errCmd:      errCmd,
+		executable:  executable,
+		dir:         cmd.Dir,
+		truncated:   stops.truncated(),
+		interrupted: stops.wasInterrupted() || ctx.Err() != nil,
+		aborted:     stops.abortedBy(),
+	}, nil
+}
+
+type pidTracker struct {
+	mu     sync.RWMutex
+	allow  map[uint64]struct{}
+	bypass bool
+}
+
+const zeroMatchBypassThreshold = 50
+
+func newPIDTracker(rootPID int) *pidTracker {
+	return &pidTracker{allow: map[uint64]struct{}{uint64(rootPID): {}}}
+}
+
+func (t *pidTracker) allowID(id int) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.bypass {
+		return true
+	}
+	_, ok := t.allow[uint64(id)]
+	return ok
+}
+
+func (t *pidTracker) addPID(pid int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.allow[uint64(pid)] = struct{}{}
+}
+
+func (t *pidTracker) addThreads(tids []uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tid := range tids {
+		t.allow[tid] = struct{}{}
+	}
+}
+
+// setBypass enables allow-all mode; returns true when state changed.
+func (t *pidTracker) setBypass() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.bypass {
+		return false
+	}
+	t.bypass = true
+	return true
+}
+
+func (t *pidTracker) isBypass() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.bypass
+}
+
+func exitCodeFromCmd(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+	return exitCmdStartErr
+}
+
+func isBenignClose(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "file already closed") || strings.Contains(msg, "use of closed file") || errors.Is(err, io.ErrClosedPipe)
+}
+
+// expandPrefixes resolves the IgnoreCWD directory: the command's dir when
+// set, fs-tracer's own working directory otherwise.
+func expandPrefixes(prefixes []string, ignoreCwd bool, dir string) []string {
+	out := make([]string, 0, len(prefixes)+1)
+	cwd := ""
+	if ignoreCwd {
+		if dir != "" {
+			if abs, err := filepath.Abs(dir); err == nil {
+				cwd = abs
+			}
+		} else if wd, err := os.Getwd(); err == nil {
+			cwd = wd
+		}
+	}
+	for _, p := range prefixes {
+		if p == "." && cwd != "" {
+			out = append(out, cwd)
+			continue
+		}
+		out = append(out, p)
+	}
+	if ignoreCwd && cwd != "" {
+		out = append(out, cwd)
+	}
+	return out
+}
+
+func defaultChildFinder(rootPID int) ([]int, error) {
+	cmd := exec.Command("ps", "-Ao", "pid,ppid")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	return parseDescendants(rootPID, output)
+}
+
+func defaultCommFinder(pid int) (string, error) {
+	cmd := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func parseDescendants(rootPID int, psOutput []byte) ([]int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(psOutput))
+	parents := make(map[int]int)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		pid, err1 := strconv.Atoi(fields[0])
+		ppid, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		parents[pid] = ppid
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return collectDescendants(rootPID, parents), nil
+}
+
+func collectDescendants(rootPID int, parents map[int]int) []int {
+	out := []int{}
+	queue := []int{rootPID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for pid, ppid := range parents {
+			if ppid == current {
+				out = append(out, pid)
+				queue = append(queue, pid)
+			}
+		}
+	}
+	return out
+}
+
+func defaultCmdBuilder(argv []string) (*exec.Cmd, error) {
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("no command specified")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	return cmd, nil
+}
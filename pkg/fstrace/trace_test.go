@@ -0,0 +1,238 @@
+package fstrace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// logRunner serves a recorded fs_usage log.
+type logRunner string
+
+func (l logRunner) Run(pid int, comm string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(string(l))), nil
+}
+
+type failingRunner struct{}
+
+func (failingRunner) Run(pid int, comm string) (io.ReadCloser, error) {
+	return nil, errors.New("no fs_usage here")
+}
+
+const sampleLog = "10:00:00.000 open /etc/hosts 0.0001 mytool.1\n" +
+	"10:00:00.001 write /tmp/out 0.0001 mytool.1\n" +
+	"not an fs_usage line\n"
+
+func testOptions(runner Runner, script string) Options {
+	return Options{
+		Command:   []string{"mytool"},
+		Runner:    runner,
+		KillGrace: 200 * time.Millisecond,
+		Hooks: Hooks{
+			NewCmd: func([]string) (*exec.Cmd, error) {
+				return exec.Command("sh", "-c", script), nil
+			},
+			BaseDate: func() time.Time { return time.Date(2025, time.November, 29, 0, 0, 0, 0, time.Local) },
+		},
+	}
+}
+
+func TestTraceResult(t *testing.T) {
+	res, err := Trace(context.Background(), testOptions(logRunner(sampleLog), "exit 3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if res.ExitCode != 3 || res.Runs != 1 || res.Interrupted || res.Truncated != "" {
+		t.Fatalf("result = %+v", res)
+	}
+	if !reflect.DeepEqual(res.Paths, []string{"/etc/hosts", "/tmp/out"}) ||
+		!reflect.DeepEqual(res.Reads, []string{"/etc/hosts"}) ||
+		!reflect.DeepEqual(res.Writes, []string{"/tmp/out"}) {
+		t.Fatalf("paths %v, reads %v, writes %v", res.Paths, res.Reads, res.Writes)
+	}
+	want := []Access{
+		{Path: "/etc/hosts", Categories: []string{"data-read"}, Runs: 1},
+		{Path: "/tmp/out", Categories: []string{"data-write"}, Runs: 1},
+	}
+	if !reflect.DeepEqual(res.Accesses, want) {
+		t.Fatalf("Accesses = %+v, want %+v", res.Accesses, want)
+	}
+	if res.Stats.Lines != 3 || res.Stats.Unparseable != 1 || res.Stats.Captured != 2 || res.Stats.Kept != 2 {
+		t.Fatalf("Stats = %+v", res.Stats)
+	}
+	if res.Start.IsZero() || res.End.Before(res.Start) {
+		t.Fatalf("Start %v, End %v", res.Start, res.End)
+	}
+}
+
+func TestTraceFollowsCommandEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	log := "10:00:00.000 open " + dir + "/go.mod 0.0001 mytool.1\n10:00:00.001 open /etc/hosts 0.0001 mytool.1\n"
+	opts := testOptions(logRunner(log), "true")
+	opts.Dir, opts.IgnoreCWD, opts.Env = dir, true, []string{"HOME=/home/tracee"}
+	res, err := Trace(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if res.Home != "/home/tracee" || res.Dir != dir {
+		t.Fatalf("Home %q, Dir %q", res.Home, res.Dir)
+	}
+	if !reflect.DeepEqual(res.Paths, []string{"/etc/hosts"}) {
+		t.Fatalf("--ignore-cwd kept %v", res.Paths)
+	}
+}
+
+func TestTraceKeepEvents(t *testing.T) {
+	opts := testOptions(logRunner(sampleLog), "true")
+	opts.KeepEvents = true
+	res, err := Trace(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	var ops []string
+	_ = res.Events(func(ev Event) error {
+		ops = append(ops, ev.Op+" "+ev.Path)
+		return nil
+	})
+	if !reflect.DeepEqual(ops, []string{"open /etc/hosts", "write /tmp/out"}) {
+		t.Fatalf("events = %v", ops)
+	}
+}
+
+func TestTraceRepeatCountsRuns(t *testing.T) {
+	opts := testOptions(logRunner(sampleLog), "true")
+	opts.Repeat = 2
+	res, err := Trace(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if res.Runs != 2 || res.Accesses[0].Runs != 2 {
+		t.Fatalf("runs %d, accesses %+v", res.Runs, res.Accesses)
+	}
+	if res.Prune(3) != 2 || len(res.Paths) != 0 {
+		t.Fatalf("Prune left %v", res.Paths)
+	}
+}
+
+func TestTraceErrorStages(t *testing.T) {
+	_, err := Trace(context.Background(), testOptions(failingRunner{}, "true"))
+	var terr *Error
+	if !errors.As(err, &terr) || terr.Stage != StageTracer || !strings.Contains(err.Error(), "failed to start fs_usage") {
+		t.Fatalf("err = %v", err)
+	}
+	opts := testOptions(logRunner(""), "true")
+	opts.Categories = []string{"bogus"}
+	if _, err := Trace(context.Background(), opts); !errors.As(err, &terr) || terr.Stage != StageSetup {
+		t.Fatalf("invalid category: err = %v", err)
+	}
+	for _, wait := range []bool{false, true} {
+		opts := testOptions(logRunner(""), "")
+		opts.Hooks.NewCmd = func([]string) (*exec.Cmd, error) { return exec.Command("no-such-command-fs-tracer"), nil }
+		opts.WaitAttach, opts.GateExecutable = wait, "/bin/true"
+		_, err := Trace(context.Background(), opts)
+		if !errors.As(err, &terr) || terr.Stage != StageStart || !errors.Is(err, exec.ErrNotFound) {
+			t.Fatalf("missing command (gated %v): err = %v", wait, err)
+		}
+	}
+}
+
+func TestTraceContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	opts := testOptions(logRunner(sampleLog), "sleep 10")
+	opts.Repeat = 3
+	res, err := Trace(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Close()
+	if !res.Interrupted || res.Runs != 1 || res.Truncated != context.DeadlineExceeded.Error() {
+		t.Fatalf("result = %+v", res)
+	}
+	if res.ExitCode != 128+int(syscall.SIGTERM) {
+		t.Fatalf("exit code = %d", res.ExitCode)
+	}
+}
+
+func TestTraceAbortOnSecondSignal(t *testing.T) {
+	sigs := make(chan os.Signal, 2)
+	opts := testOptions(logRunner(""), `trap "" INT; sleep 10`)
+	opts.Signals = sigs
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		sigs <- syscall.SIGINT
+		sigs <- syscall.SIGINT
+	}()
+	_, err := Trace(context.Background(), opts)
+	var abort *AbortError
+	if !errors.As(err, &abort) || abort.Signal != syscall.SIGINT || err.Error() != "SIGINT received again" {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	ch, wait, err := Events(context.Background(), testOptions(logRunner(sampleLog), "true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for ev := range ch {
+		paths = append(paths, ev.Path)
+	}
+	if !reflect.DeepEqual(paths, []string{"/etc/hosts", "/tmp/out"}) {
+		t.Fatalf("streamed %v", paths)
+	}
+	if err := wait(); err != nil {
+		t.Fatalf("wait = %v", err)
+	}
+}
+
+func TestEventsReportsTraceError(t *testing.T) {
+	ch, wait, err := Events(context.Background(), testOptions(failingRunner{}, "true"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+		t.Fatal("unexpected event")
+	}
+	var terr *Error
+	if err := wait(); !errors.As(err, &terr) || terr.Stage != StageTracer {
+		t.Fatalf("wait = %v", err)
+	}
+}
+
+func TestParseDescendants(t *testing.T) {
+	ps := "  PID  PPID\n  10   1\n  11   10\n  12   1\n  13   12\n"
+	desc, err := parseDescendants(1, []byte(ps))
+	if err != nil {
+		t.Fatalf("parseDescendants error: %v", err)
+	}
+	got := strings.Join(toStrings(desc), ",")
+	want := "10,11,12,13"
+	if got != want {
+		t.Fatalf("unexpected descendants: %s", got)
+	}
+}
+
+func toStrings(nums []int) []string {
+	out := make([]string, 0, len(nums))
+	sorted := append([]int(nil), nums...)
+	sort.Ints(sorted)
+	for _, n := range sorted {
+		out = append(out, fmt.Sprintf("%d", n))
+	}
+	return out
+}
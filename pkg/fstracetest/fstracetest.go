@@ -0,0 +1,260 @@
+// Package fstracetest runs commands under fstrace from Go tests and asserts
+// on the files they accessed:
+//
+//	func TestBuildStaysInTempDir(t *testing.T) {
+//		dir := t.TempDir()
+//		tr := fstracetest.Run(t, fstrace.Options{Command: []string{"./mytool", "--out", dir}})
+//		tr.AssertNoWritesOutside(dir)
+//		tr.AssertNoAccess("/Users/*/.ssh")
+//	}
+//
+// Tracing needs root, see package fstrace. Short-lived commands may exit
+// before fs_usage attaches; set Options.WaitAttach and call Main from
+// TestMain so the test binary can act as the gate helper.
+package fstracetest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hokupod/fs-tracer/internal/fsusage"
+	"github.com/hokupod/fs-tracer/internal/ops"
+	"github.com/hokupod/fs-tracer/internal/output"
+	"github.com/hokupod/fs-tracer/pkg/fstrace"
+)
+
+// maxListed caps the events or paths quoted in a failure message.
+const maxListed = 20
+
+// Main runs the tests, or the gate helper when the test binary is
+// re-executed for Options.WaitAttach. Call it from TestMain:
+//
+//	func TestMain(m *testing.M) { fstracetest.Main(m) }
+func Main(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == fstrace.GateCommand {
+		os.Exit(fstrace.RunGate(os.Args[2:], os.Stderr))
+	}
+	os.Exit(m.Run())
+}
+
+// Trace is a finished trace that assertions report failures to.
+type Trace struct {
+	// Result holds the exit code and the classified accesses. Its temporary
+	// files are already removed; use Events for the events.
+	*fstrace.Result
+	// Events lists the events that passed the filters, in arrival order.
+	Events []fstrace.Event
+
+	tb testing.TB
+}
+
+// Run traces opts.Command and fails the test if the trace cannot run. Events
+// are always kept, and diagnostics go to the test log unless opts.Log is
+// set. The trace is cancelled when the test ends.
+func Run(tb testing.TB, opts fstrace.Options) *Trace {
+	tb.Helper()
+	opts.KeepEvents = true
+	if opts.Log == nil {
+		opts.Log = logWriter{tb}
+	}
+	res, err := fstrace.Trace(tb.Context(), opts)
+	if err != nil {
+		tb.Fatalf("fstracetest: trace %s: %v", output.ShellJoin(opts.Command), err)
+	}
+	defer res.Close()
+	tr := &Trace{Result: res, tb: tb}
+	if err := res.Events(func(ev fstrace.Event) error {
+		tr.Events = append(tr.Events, ev)
+		return nil
+	}); err != nil {
+		tb.Fatalf("fstracetest: read events: %v", err)
+	}
+	return tr
+}
+
+// RunCmd traces cmd like Run. cmd's Dir, Env, Stdin, Stdout and Stderr take
+// precedence over opts, and opts.Repeat is ignored as a Cmd starts only once.
+func RunCmd(tb testing.TB, cmd *exec.Cmd, opts fstrace.Options) *Trace {
+	tb.Helper()
+	opts.Command = cmd.Args
+	if len(opts.Command) == 0 {
+		opts.Command = []string{cmd.Path}
+	}
+	if cmd.Dir != "" {
+		opts.Dir = cmd.Dir
+	}
+	if cmd.Env != nil {
+		opts.CleanEnv, opts.UnsetEnv, opts.Env = true, nil, cmd.Env
+	}
+	if cmd.Stdin != nil {
+		opts.Stdin, opts.StdinFile = cmd.Stdin, ""
+	}
+	if cmd.Stdout != nil {
+		opts.Stdout = cmd.Stdout
+	}
+	if cmd.Stderr != nil {
+		opts.Stderr = cmd.Stderr
+	}
+	opts.Repeat = 1
+	opts.Hooks.NewCmd = func([]string) (*exec.Cmd, error) { return cmd, nil }
+	return Run(tb, opts)
+}
+
+// AssertNoWritesOutside reports every write to a path outside dirs. Symlinked
+// dirs also match their targets, so t.TempDir() works on macOS, where
+// fs_usage reports /private/var for /var.
+func (tr *Trace) AssertNoWritesOutside(dirs ...string) {
+	tr.tb.Helper()
+	var roots []string
+	for _, d := range dirs {
+		roots = append(roots, resolve(d)...)
+	}
+	var bad []fstrace.Event
+	for _, ev := range tr.Events {
+		if ev.Path == "" || !ops.Classify(ev.Op).IsWrite() {
+			continue
+		}
+		if !within(ev.Path, roots) {
+			bad = append(bad, ev)
+		}
+	}
+	if len(bad) > 0 {
+		tr.tb.Errorf("fstracetest: %d writes outside %s:\n%s", len(bad), strings.Join(dirs, ", "), eventList(bad))
+	}
+}
+
+// AssertReads reports the paths the command did not read. A path counts as
+// read when any op other than a write touched it, as in Result.Reads.
+func (tr *Trace) AssertReads(paths ...string) {
+	tr.tb.Helper()
+	read := map[string]struct{}{}
+	for _, p := range tr.Reads {
+		read[p] = struct{}{}
+	}
+	var missing []string
+	for _, p := range paths {
+		found := false
+		for _, c := range resolve(p) {
+			if _, ok := read[c]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) > 0 {
+		tr.tb.Errorf("fstracetest: expected reads not seen:\n%s\nthe command read %d paths:\n%s",
+			pathList(missing), len(tr.Reads), pathList(tr.Reads))
+	}
+}
+
+// AssertNoAccess reports every event whose path, or one of its parent
+// directories, matches the glob pattern. As with Options.StopOnPath, a
+// pattern without a "/" matches base names, so ".env" matches any .env file
+// and "/Users/*/.ssh" everything below ~/.ssh.
+func (tr *Trace) AssertNoAccess(pattern string) {
+	tr.tb.Helper()
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		tr.tb.Fatalf("fstracetest: invalid pattern %q: %v", pattern, err)
+	}
+	var bad []fstrace.Event
+	for _, ev := range tr.Events {
+		if ev.Path != "" && matchTree(pattern, ev.Path) {
+			bad = append(bad, ev)
+		}
+	}
+	if len(bad) > 0 {
+		tr.tb.Errorf("fstracetest: %d accesses match %q:\n%s", len(bad), pattern, eventList(bad))
+	}
+}
+
+// resolve returns p made absolute and, when it differs, with symlinks
+// resolved.
+func resolve(p string) []string {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = filepath.Clean(p)
+	}
+	out := []string{abs}
+	if real, err := filepath.EvalSymlinks(abs); err == nil && real != abs {
+		out = append(out, real)
+	}
+	return out
+}
+
+func within(p string, roots []string) bool {
+	for _, r := range roots {
+		if p == r || strings.HasPrefix(p, strings.TrimSuffix(r, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTree matches pattern against p and each of its parent directories.
+func matchTree(pattern, p string) bool {
+	hasSlash := strings.Contains(pattern, "/")
+	for {
+		name := p
+		if !hasSlash {
+			name = filepath.Base(p)
+		}
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false
+		}
+		p = parent
+	}
+}
+
+func eventList(events []fstrace.Event) string {
+	lines := make([]string, 0, len(events))
+	for _, ev := range events {
+		lines = append(lines, output.EventLine(fsusage.Event(ev)))
+	}
+	return indentList(lines)
+}
+
+func pathList(paths []string) string {
+	if len(paths) == 0 {
+		return "  (none)"
+	}
+	return indentList(paths)
+}
+
+func indentList(lines []string) string {
+	var b strings.Builder
+	for i, l := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		if i == maxListed {
+			fmt.Fprintf(&b, "  ... and %d more", len(lines)-i)
+			break
+		}
+		b.WriteString("  " + l)
+	}
+	return b.String()
+}
+
+// logWriter sends fstrace diagnostics to the test log, one call per line.
+type logWriter struct {
+	tb testing.TB
+}
+
+func (w logWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		w.tb.Log(string(line))
+	}
+	return len(p), nil
+}
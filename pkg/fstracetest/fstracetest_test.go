@@ -0,0 +1,163 @@
+package fstracetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hokupod/fs-tracer/pkg/fstrace"
+)
+
+func TestMain(m *testing.M) { Main(m) }
+
+type logRunner string
+
+func (l logRunner) Run(pid int, comm string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(string(l))), nil
+}
+
+// recorder collects the failures an assertion reports.
+type recorder struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...any) {
+	r.Errorf(format, args...)
+}
+
+func fakeOptions(dir string, lines ...string) fstrace.Options {
+	var log strings.Builder
+	for i, l := range lines {
+		fmt.Fprintf(&log, "10:00:00.%03d %s 0.0001 mytool.1\n", i, l)
+	}
+	return fstrace.Options{
+		Command: []string{"mytool"},
+		Dir:     dir,
+		Runner:  logRunner(log.String()),
+		Hooks: fstrace.Hooks{
+			NewCmd:   func([]string) (*exec.Cmd, error) { return exec.Command("true"), nil },
+			BaseDate: func() time.Time { return time.Date(2025, time.November, 29, 0, 0, 0, 0, time.Local) },
+		},
+	}
+}
+
+func traceWith(t *testing.T, opts fstrace.Options) (*Trace, *recorder) {
+	tr := Run(t, opts)
+	rec := &recorder{TB: t}
+	tr.tb = rec
+	return tr, rec
+}
+
+func TestRunKeepsEvents(t *testing.T) {
+	tr := Run(t, fakeOptions("", "open /etc/hosts", "write /tmp/out"))
+	if len(tr.Events) != 2 || tr.Events[1].Path != "/tmp/out" {
+		t.Fatalf("events = %+v", tr.Events)
+	}
+	if len(tr.Writes) != 1 || tr.ExitCode != 0 {
+		t.Fatalf("result = %+v", tr.Result)
+	}
+}
+
+func TestAssertNoWritesOutside(t *testing.T) {
+	dir := t.TempDir()
+	tr, rec := traceWith(t, fakeOptions(dir,
+		"write "+filepath.Join(dir, "a.o"),
+		"open /etc/passwd",
+		"write /etc/passwd",
+	))
+	tr.AssertNoWritesOutside(dir)
+	if len(rec.errs) != 1 {
+		t.Fatalf("errors = %q", rec.errs)
+	}
+	msg := rec.errs[0]
+	if !strings.HasPrefix(msg, "fstracetest: 1 writes outside "+dir+":\n") ||
+		!strings.Contains(msg, `op=write path="/etc/passwd"`) || strings.Contains(msg, "a.o") || strings.Contains(msg, "op=open") {
+		t.Fatalf("message:\n%s", msg)
+	}
+
+	rec.errs = nil
+	tr.AssertNoWritesOutside(dir, "/etc")
+	if len(rec.errs) != 0 {
+		t.Fatalf("errors = %q", rec.errs)
+	}
+}
+
+func TestAssertReads(t *testing.T) {
+	tr, rec := traceWith(t, fakeOptions("", "open /etc/hosts", "write /tmp/out"))
+	tr.AssertReads("/etc/hosts")
+	if len(rec.errs) != 0 {
+		t.Fatalf("errors = %q", rec.errs)
+	}
+	tr.AssertReads("/etc/hosts", "/tmp/out", "/etc/xdg/mytool.conf")
+	want := "fstracetest: expected reads not seen:\n  /tmp/out\n  /etc/xdg/mytool.conf\nthe command read 1 paths:\n  /etc/hosts"
+	if len(rec.errs) != 1 || rec.errs[0] != want {
+		t.Fatalf("errors = %q", rec.errs)
+	}
+}
+
+func TestAssertNoAccess(t *testing.T) {
+	tr, rec := traceWith(t, fakeOptions("",
+		"open /home/me/.ssh/id_ed25519",
+		"stat64 /home/me/.sshd",
+		"open /srv/app/.env",
+		"open /etc/hosts",
+	))
+	tr.AssertNoAccess("/home/*/.ssh")
+	tr.AssertNoAccess(".env")
+	tr.AssertNoAccess("/etc/shadow")
+	if len(rec.errs) != 2 {
+		t.Fatalf("errors = %q", rec.errs)
+	}
+	if !strings.Contains(rec.errs[0], `1 accesses match "/home/*/.ssh"`) || !strings.Contains(rec.errs[0], "id_ed25519") {
+		t.Fatalf("message:\n%s", rec.errs[0])
+	}
+	if !strings.Contains(rec.errs[1], `path="/srv/app/.env"`) {
+		t.Fatalf("message:\n%s", rec.errs[1])
+	}
+	tr.AssertNoAccess("[")
+	if len(rec.errs) != 3 || !strings.Contains(rec.errs[2], "invalid pattern") {
+		t.Fatalf("errors = %q", rec.errs)
+	}
+}
+
+func TestRunCmd(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", `pwd; echo "$FOO"`)
+	cmd.Dir = dir
+	cmd.Env = []string{"FOO=bar", "PATH=/usr/bin:/bin"}
+	cmd.Stdout = &out
+	opts := fakeOptions("", "open /etc/hosts")
+	opts.Repeat = 3
+	tr := RunCmd(t, cmd, opts)
+	if tr.Runs != 1 || tr.Dir != dir {
+		t.Fatalf("runs %d, dir %q", tr.Runs, tr.Dir)
+	}
+	real, _ := filepath.EvalSymlinks(dir)
+	if got := out.String(); got != real+"\nbar\n" && got != dir+"\nbar\n" {
+		t.Fatalf("output = %q", got)
+	}
+}
+
+func TestIndentListCaps(t *testing.T) {
+	var paths []string
+	for i := 0; i < maxListed+5; i++ {
+		paths = append(paths, fmt.Sprintf("/p%d", i))
+	}
+	got := pathList(paths)
+	if !strings.HasSuffix(got, "\n  /p19\n  ... and 5 more") {
+		t.Fatalf("list ends with %q", got[len(got)-40:])
+	}
+}